@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+const defaultBodyCaptureLimit = 8 * 1024
+
+// bodyCapturePolicy tells how much of a body is kept as http.*.body.content, and how.
+type bodyCapturePolicy struct {
+	limit    int
+	drop     bool
+	base64   bool
+	headTail bool // keep the end of the body as well as its beginning, each taking half of the limit
+}
+
+// apply sets the options of a rule, i.e. a limit in bytes, drop, base64 or head-tail.
+func (p bodyCapturePolicy) apply(options []string) (bodyCapturePolicy, error) {
+	for _, o := range options {
+		switch o = strings.TrimSpace(o); o {
+		case "drop":
+			p.drop = true
+		case "base64":
+			p.base64 = true
+		case "head-tail":
+			p.headTail = true
+		default:
+			limit, err := strconv.Atoi(o)
+			if err != nil || limit < 0 {
+				return p, fmt.Errorf("invalid option %q", o)
+			}
+			p.limit = limit
+		}
+	}
+	return p, nil
+}
+
+type bodyCaptureRule struct {
+	direction string // request or response, empty for both
+	pattern   string // of media types, as matched by path.Match
+	options   []string
+}
+
+// parseBodyCaptureRule parses rules written as [request:|response:]<media type pattern>=<option>[;<option>...], e.g. response:image/*=drop.
+func parseBodyCaptureRule(s string) (r bodyCaptureRule, err error) {
+	pattern, options, ok := strings.Cut(strings.TrimSpace(s), "=")
+	if !ok || options == "" {
+		return r, fmt.Errorf("invalid body capture rule %q: missing options", s)
+	}
+	for _, d := range []string{"request", "response"} {
+		if strings.HasPrefix(pattern, d+":") {
+			r.direction, pattern = d, strings.TrimPrefix(pattern, d+":")
+		}
+	}
+	if _, err = path.Match(pattern, ""); err != nil || pattern == "" {
+		return r, fmt.Errorf("invalid body capture rule %q: invalid media type pattern", s)
+	}
+	r.pattern, r.options = strings.ToLower(pattern), strings.Split(options, ";")
+	if _, err = (bodyCapturePolicy{}).apply(r.options); err != nil {
+		return r, fmt.Errorf("invalid body capture rule %q: %w", s, err)
+	}
+	return
+}
+
+// bodyCapturePolicies holds the default policy of each direction, refined by the first rule matching the media type of the body.
+type bodyCapturePolicies struct {
+	request  bodyCapturePolicy
+	response bodyCapturePolicy
+	rules    []bodyCaptureRule
+}
+
+func newBodyCapturePolicies(requestLimit, responseLimit int, headTail bool, rules []string) (bcp *bodyCapturePolicies, err error) {
+	if requestLimit < 0 || responseLimit < 0 {
+		return nil, fmt.Errorf("invalid body capture limits: request %d, response %d", requestLimit, responseLimit)
+	}
+	bcp = &bodyCapturePolicies{
+		request:  bodyCapturePolicy{limit: requestLimit, headTail: headTail},
+		response: bodyCapturePolicy{limit: responseLimit, headTail: headTail},
+	}
+	for _, s := range rules {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		r, err := parseBodyCaptureRule(s)
+		if err != nil {
+			return nil, err
+		}
+		bcp.rules = append(bcp.rules, r)
+	}
+	return
+}
+
+func (bcp *bodyCapturePolicies) policyOf(direction string, h http.Header) bodyCapturePolicy {
+	p := bcp.request
+	if direction == "response" {
+		p = bcp.response
+	}
+
+	mt, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	for _, r := range bcp.rules {
+		if r.direction != "" && r.direction != direction {
+			continue
+		}
+		if ok, _ := path.Match(r.pattern, mt); ok {
+			p, _ = p.apply(r.options)
+			break
+		}
+	}
+	return p
+}
+
+// captureBuffer keeps the part of a body told by its policy while counting its whole length.
+type captureBuffer struct {
+	policy bodyCapturePolicy
+	head   []byte
+	tail   []byte // holds more than the kept tail, which is compacted once in a while
+	n      int64
+}
+
+func newCaptureBuffer(policy bodyCapturePolicy) *captureBuffer {
+	return &captureBuffer{policy: policy}
+}
+
+func (cb *captureBuffer) limits() (head, tail int) {
+	if cb.policy.drop {
+		return 0, 0
+	}
+	if cb.policy.headTail {
+		return cb.policy.limit - cb.policy.limit/2, cb.policy.limit / 2
+	}
+	return cb.policy.limit, 0
+}
+
+func (cb *captureBuffer) Write(p []byte) (n int, err error) {
+	n = len(p)
+	cb.n += int64(n)
+
+	headLimit, tailLimit := cb.limits()
+	if k := headLimit - len(cb.head); k > 0 {
+		if k > len(p) {
+			k = len(p)
+		}
+		cb.head, p = append(cb.head, p[:k]...), p[k:]
+	}
+	if tailLimit == 0 || len(p) == 0 {
+		return
+	}
+	if len(p) >= tailLimit {
+		cb.tail = append(cb.tail[:0], p[len(p)-tailLimit:]...)
+		return
+	}
+	cb.tail = append(cb.tail, p...)
+	if len(cb.tail) > 2*tailLimit {
+		cb.tail = append(cb.tail[:0], cb.tail[len(cb.tail)-tailLimit:]...)
+	}
+	return
+}
+
+func (cb *captureBuffer) Close() error { return nil }
+
+func (cb *captureBuffer) keptTail() []byte {
+	if _, tailLimit := cb.limits(); len(cb.tail) > tailLimit {
+		return cb.tail[len(cb.tail)-tailLimit:]
+	}
+	return cb.tail
+}
+
+// Len returns the length of the whole body.
+func (cb *captureBuffer) Len() int64 {
+	return cb.n
+}
+
+// String returns the kept part of the body, i.e. its head directly followed by its tail, encoded in base64 if told so.
+func (cb *captureBuffer) String() string {
+	b := append(append([]byte(nil), cb.head...), cb.keptTail()...)
+	if cb.policy.base64 {
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return string(b)
+}
+
+func (cb *captureBuffer) capture() *ecsx.BodyCapture {
+	tail := len(cb.keptTail())
+	c := &ecsx.BodyCapture{
+		Truncated:     int64(len(cb.head)+tail) < cb.n,
+		OriginalBytes: cb.n,
+		Dropped:       cb.policy.drop,
+	}
+	if c.Truncated && tail > 0 {
+		c.HeadBytes, c.TailBytes = len(cb.head), tail
+	}
+	if cb.policy.base64 && !cb.policy.drop {
+		c.Encoding = "base64"
+	}
+	return c
+}
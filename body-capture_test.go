@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+func TestParseBodyCaptureRule(t *testing.T) {
+	r, err := parseBodyCaptureRule("response:image/*=drop")
+	require.NoError(t, err)
+	assert.Equal(t, bodyCaptureRule{direction: "response", pattern: "image/*", options: []string{"drop"}}, r)
+
+	r, err = parseBodyCaptureRule("Application/JSON=65536;head-tail")
+	require.NoError(t, err)
+	assert.Equal(t, bodyCaptureRule{pattern: "application/json", options: []string{"65536", "head-tail"}}, r)
+
+	for _, s := range []string{"text/plain", "text/plain=", "=drop", "text/[=drop", "text/plain=-1", "text/plain=gzip"} {
+		_, err = parseBodyCaptureRule(s)
+		assert.Error(t, err, "should reject %q", s)
+	}
+}
+
+func TestBodyCapturePolicies(t *testing.T) {
+	bcp, err := newBodyCapturePolicies(10, 20, false, []string{
+		"response:image/*=drop",
+		"application/octet-stream=base64;16",
+		"text/*=4;head-tail",
+		"text/plain=100",
+		"",
+	})
+	require.NoError(t, err)
+
+	h := func(ct string) http.Header { return http.Header{"Content-Type": {ct}} }
+	assert.Equal(t, bodyCapturePolicy{limit: 10}, bcp.policyOf("request", h("application/json")))
+	assert.Equal(t, bodyCapturePolicy{limit: 20}, bcp.policyOf("response", h("")))
+	assert.Equal(t, bodyCapturePolicy{limit: 10}, bcp.policyOf("request", h("image/png")), "should only apply to responses")
+	assert.Equal(t, bodyCapturePolicy{limit: 20, drop: true}, bcp.policyOf("response", h("image/png")))
+	assert.Equal(t, bodyCapturePolicy{limit: 16, base64: true}, bcp.policyOf("request", h("application/octet-stream")))
+	assert.Equal(t, bodyCapturePolicy{limit: 4, headTail: true}, bcp.policyOf("response", h("text/plain; charset=utf-8")), "should apply the first matching rule")
+
+	_, err = newBodyCapturePolicies(-1, 0, false, nil)
+	assert.Error(t, err)
+	_, err = newBodyCapturePolicies(0, 0, false, []string{"image/*"})
+	assert.Error(t, err)
+}
+
+func TestCaptureBuffer(t *testing.T) {
+	write := func(cb *captureBuffer, chunks ...string) *captureBuffer {
+		for _, c := range chunks {
+			n, err := cb.Write([]byte(c))
+			require.NoError(t, err)
+			require.Equal(t, len(c), n)
+		}
+		return cb
+	}
+
+	cb := write(newCaptureBuffer(bodyCapturePolicy{limit: 8}), "hello")
+	assert.Equal(t, "hello", cb.String())
+	assert.Equal(t, &ecsx.BodyCapture{OriginalBytes: 5}, cb.capture())
+
+	cb = write(newCaptureBuffer(bodyCapturePolicy{limit: 8}), "hello ", "world")
+	assert.Equal(t, "hello wo", cb.String())
+	assert.Equal(t, int64(11), cb.Len())
+	assert.Equal(t, &ecsx.BodyCapture{Truncated: true, OriginalBytes: 11}, cb.capture())
+
+	cb = write(newCaptureBuffer(bodyCapturePolicy{limit: 7, headTail: true}), "abc", "def", "g", "hij", "klmnopq", "r", "s", "t", "uvwxyz")
+	assert.Equal(t, "abcdxyz", cb.String())
+	assert.Equal(t, &ecsx.BodyCapture{Truncated: true, OriginalBytes: 26, HeadBytes: 4, TailBytes: 3}, cb.capture())
+
+	cb = write(newCaptureBuffer(bodyCapturePolicy{limit: 8, headTail: true}), "abcdef")
+	assert.Equal(t, "abcdef", cb.String(), "should not duplicate a body fitting the limit")
+	assert.Equal(t, &ecsx.BodyCapture{OriginalBytes: 6}, cb.capture())
+
+	cb = write(newCaptureBuffer(bodyCapturePolicy{limit: 8, drop: true}), "hello")
+	assert.Empty(t, cb.String())
+	assert.Equal(t, &ecsx.BodyCapture{Truncated: true, OriginalBytes: 5, Dropped: true}, cb.capture())
+
+	cb = write(newCaptureBuffer(bodyCapturePolicy{limit: 8, base64: true}), "\x00\x01\x02")
+	assert.Equal(t, "AAEC", cb.String())
+	assert.Equal(t, &ecsx.BodyCapture{OriginalBytes: 3, Encoding: "base64"}, cb.capture())
+}
+
+func TestEnricherBodyCapture(t *testing.T) {
+	ercr, err := newEnricher(
+		enricherWithBodyCapture(16, 8, true, []string{"response:image/*=drop", "application/octet-stream=base64"}),
+		enricherWithRedaction("", nil, []string{"password"}, nil),
+	)
+	require.NoError(t, err)
+
+	payload := []byte("\x00password=secret\xff")
+	upload := httptest.NewRequest(http.MethodPost, "http://api.example.com/upload", bytes.NewReader(payload))
+	upload.Header.Set("Content-Type", "application/octet-stream")
+	image := testResponse(upload, http.StatusOK, http.Header{"Content-Type": {"image/png"}}, []byte("\x89PNG\r\n\x1a\n...."))
+	ctx := &httpRecordedMessageContext{ID: "capture"}
+	erc, err := ercr.EnrichRecord(testRecord(t, upload, image, ctx))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+	require.Len(t, docs, 1)
+
+	req := docs[0].HTTP.Request
+	require.NotNil(t, req.Capture)
+	assert.Equal(t, "base64", req.Capture.Encoding)
+	assert.Equal(t, int64(len(payload)), req.Capture.OriginalBytes)
+	assert.Equal(t, int64(len(payload)), req.Body.Bytes)
+	b, err := base64.StdEncoding.DecodeString(req.Body.Content)
+	require.NoError(t, err, "should keep valid base64 once redacted")
+	assert.Len(t, b, 16)
+
+	res := docs[0].HTTP.Response
+	require.NotNil(t, res.Capture)
+	assert.True(t, res.Capture.Dropped)
+	assert.Equal(t, int64(12), res.Capture.OriginalBytes)
+	assert.Equal(t, int64(12), res.Body.Bytes, "should still count the dropped body")
+	assert.Empty(t, res.Body.Content)
+
+	upload = httptest.NewRequest(http.MethodPost, "http://api.example.com/upload", strings.NewReader("0123456789abcdefghijklmnopqrstuvwxyz"))
+	upload.Header.Set("Content-Type", "text/plain")
+	erc, err = ercr.EnrichRecord(testRecord(t, upload, testResponse(upload, http.StatusOK, http.Header{"Content-Type": {"text/plain"}}, []byte("ok")), ctx))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+	docs, err = erc.toECS()
+	require.NoError(t, err, "should not return error")
+	assert.Equal(t, "01234567stuvwxyz", docs[0].HTTP.Request.Body.Content)
+	assert.Equal(t, &ecsx.BodyCapture{Truncated: true, OriginalBytes: 36, HeadBytes: 8, TailBytes: 8}, docs[0].HTTP.Request.Capture)
+	assert.Equal(t, "ok", docs[0].HTTP.Response.Body.Content)
+
+	_, err = newEnricher(enricherWithBodyCapture(0, 0, false, []string{"image/*=all"}))
+	assert.Error(t, err)
+}
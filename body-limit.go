@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+const (
+	defaultBodyMaxSize           = 64 << 20
+	defaultDecompressionMaxRatio = 100
+	defaultRecordTimeout         = 30 * time.Second
+)
+
+// bodyLimits bound the bodies streamed to the sub-enrichers, zero disabling a limit.
+type bodyLimits struct {
+	maxSize  int64         // of a decoded body
+	maxRatio float64       // of a decoded body to its compressed size
+	timeout  time.Duration // to process a record
+}
+
+// limitedBody ends a body early, as if it were complete, once it exceeds the size limit or the deadline of its record passes.
+// The body is read in chunks of a few KiB, hence a record exceeds its deadline by the time spent processing one chunk at most.
+type limitedBody struct {
+	r        io.Reader
+	maxSize  int64
+	deadline time.Time
+	n        int64
+	reason   string
+}
+
+func newLimitedBody(r io.Reader, maxSize int64, deadline time.Time) *limitedBody {
+	return &limitedBody{r: r, maxSize: maxSize, deadline: deadline}
+}
+
+func (lb *limitedBody) Read(p []byte) (n int, err error) {
+	if lb.reason != "" {
+		return 0, io.EOF
+	}
+	if !lb.deadline.IsZero() && time.Now().After(lb.deadline) {
+		lb.reason = "timeout"
+		return 0, io.EOF
+	}
+	if lb.maxSize > 0 {
+		if lb.n >= lb.maxSize {
+			return 0, lb.exceeded()
+		}
+		if rest := lb.maxSize - lb.n; int64(len(p)) > rest {
+			p = p[:rest]
+		}
+	}
+
+	n, err = lb.r.Read(p)
+	lb.n += int64(n)
+	return
+}
+
+// exceeded tells whether the body goes on beyond the size limit.
+func (lb *limitedBody) exceeded() error {
+	var b [1]byte
+	for {
+		n, err := lb.r.Read(b[:])
+		if n > 0 {
+			lb.reason = "size"
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// limit returns how the body was limited, if it was, including by its decoding.
+func (lb *limitedBody) limit(encoding *ecsx.ContentEncoding) *ecsx.BodyLimit {
+	reason := lb.reason
+	if reason == "" && encoding != nil {
+		reason = encoding.Limit
+	}
+	if reason == "" {
+		return nil
+	}
+	return &ecsx.BodyLimit{Reason: reason, ProcessedBytes: lb.n}
+}
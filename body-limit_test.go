@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+func TestLimitedBody(t *testing.T) {
+	lb := newLimitedBody(strings.NewReader("hello"), 5, time.Time{})
+	b, err := io.ReadAll(lb)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	assert.Nil(t, lb.limit(nil), "should not be limited when the body fits")
+
+	lb = newLimitedBody(strings.NewReader("hello world"), 5, time.Time{})
+	b, err = io.ReadAll(lb)
+	require.NoError(t, err, "should stop gracefully")
+	assert.Equal(t, "hello", string(b))
+	assert.Equal(t, &ecsx.BodyLimit{Reason: "size", ProcessedBytes: 5}, lb.limit(nil))
+
+	lb = newLimitedBody(strings.NewReader("hello"), 0, time.Now().Add(-time.Second))
+	b, err = io.ReadAll(lb)
+	require.NoError(t, err, "should stop gracefully")
+	assert.Empty(t, b)
+	assert.Equal(t, &ecsx.BodyLimit{Reason: "timeout"}, lb.limit(nil))
+
+	lb = newLimitedBody(strings.NewReader("hello"), 0, time.Time{})
+	_, err = io.ReadAll(lb)
+	require.NoError(t, err)
+	assert.Equal(t, &ecsx.BodyLimit{Reason: "ratio", ProcessedBytes: 5}, lb.limit(&ecsx.ContentEncoding{Limit: "ratio"}))
+}
+
+func TestEnricherBodyLimits(t *testing.T) {
+	bomb := testEncode(t, make([]byte, 32<<20), "gzip")
+	req := httptest.NewRequest(http.MethodGet, "http://api.example.com/download", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	gzipped := http.Header{"Content-Type": {"text/plain"}, "Content-Encoding": {"gzip"}}
+	large := testResponse(httptest.NewRequest(http.MethodGet, "http://api.example.com/download", nil), http.StatusOK,
+		http.Header{"Content-Type": {"text/plain"}}, bytes.Repeat([]byte("a"), 4096))
+	ctx := &httpRecordedMessageContext{ID: "limits"}
+
+	ercr, err := newEnricher(enricherWithBodyLimits(1024, 100, time.Minute))
+	require.NoError(t, err)
+	erc, err := ercr.EnrichRecord(testRecord(t, req, testResponse(req, http.StatusOK, gzipped, bomb), ctx, large))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+	require.Len(t, docs, 2, "should go on with the following exchanges")
+
+	res := docs[0].HTTP.Response
+	require.NotNil(t, res.Limited)
+	assert.Equal(t, "size", res.Limited.Reason)
+	assert.Equal(t, int64(1024), res.Limited.ProcessedBytes)
+	assert.Equal(t, int64(len(bomb)), res.Encoding.CompressedBytes)
+	assert.Contains(t, docs[0].Tags, "truncated")
+	assert.Nil(t, docs[0].HTTP.Request.Limited)
+	assert.NotNil(t, docs[0].UserAgent, "should still produce the remaining enrichment")
+
+	require.NotNil(t, docs[1].HTTP.Response.Limited)
+	assert.Equal(t, "size", docs[1].HTTP.Response.Limited.Reason)
+
+	ercr, err = newEnricher(enricherWithBodyLimits(0, 100, time.Minute))
+	require.NoError(t, err)
+	erc, err = ercr.EnrichRecord(testRecord(t, req, testResponse(req, http.StatusOK, gzipped, bomb), ctx))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+	docs, err = erc.toECS()
+	require.NoError(t, err, "should not return error")
+	require.NotNil(t, docs[0].HTTP.Response.Limited)
+	assert.Equal(t, "ratio", docs[0].HTTP.Response.Limited.Reason)
+	assert.Less(t, docs[0].HTTP.Response.Limited.ProcessedBytes, int64(32<<20))
+}
+
+func TestEnricherRecordTimeout(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://api.example.com/download", nil)
+	res := testResponse(req, http.StatusOK, http.Header{"Content-Type": {"text/plain"}, "Content-Encoding": {"gzip"}}, testEncode(t, []byte("hello"), "gzip"))
+	next := testResponse(httptest.NewRequest(http.MethodGet, "http://api.example.com/download", nil), http.StatusOK,
+		http.Header{"Content-Type": {"text/plain"}}, []byte("world"))
+
+	ercr, err := newEnricher(enricherWithBodyLimits(0, 0, time.Nanosecond))
+	require.NoError(t, err)
+	erc, err := ercr.EnrichRecord(testRecord(t, req, res, &httpRecordedMessageContext{ID: "limits"}, next))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+	require.Len(t, docs, 2)
+
+	for _, doc := range docs {
+		require.NotNil(t, doc.HTTP.Response.Limited)
+		assert.Equal(t, "timeout", doc.HTTP.Response.Limited.Reason)
+		assert.Contains(t, doc.Tags, "truncated")
+		assert.Equal(t, 200, doc.HTTP.Response.StatusCode, "should still produce the document")
+	}
+
+	ercr, err = newEnricher(
+		enricherWithCRS("crs/coraza.conf", "crs/crs-setup.conf", "crs/rules/*.conf"),
+		enricherWithBodyLimits(0, 0, time.Nanosecond),
+	)
+	require.NoError(t, err)
+	req = httptest.NewRequest(http.MethodGet, "http://api.example.com/download?id=1'%20OR%20'1'='1'%20--", nil)
+	res = testResponse(req, http.StatusOK, http.Header{"Content-Type": {"text/plain"}}, []byte("world"))
+	erc, err = ercr.EnrichRecord(testRecord(t, req, res, &httpRecordedMessageContext{ID: "limits"}))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+	docs, err = erc.toECS()
+	require.NoError(t, err, "should not return error")
+	require.NotNil(t, docs[0].HTTP.Request.Limited, "should report the skipped processing of a bodiless request")
+	assert.Equal(t, "timeout", docs[0].HTTP.Request.Limited.Reason)
+	if docs[0].Threat != nil {
+		assert.Empty(t, docs[0].Threat.Enrichments, "should not run the rules once the deadline passed")
+	}
+
+	_, err = newEnricher(enricherWithBodyLimits(-1, 0, 0))
+	assert.Error(t, err)
+}
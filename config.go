@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 )
@@ -9,6 +10,19 @@ import (
 type config struct {
 	S3    configS3    `envPrefix:"S3_"`
 	GeoIP configGeoIP `envPrefix:"GEOIP_"`
+
+	Redaction  configRedaction  `envPrefix:"REDACTION_"`
+	Secrets    configSecrets    `envPrefix:"SECRETS_"`
+	Signatures configSignatures `envPrefix:"SIGNATURES_"`
+	OpenAPI    configOpenAPI    `envPrefix:"OPENAPI_"`
+	GraphQL    configGraphQL    `envPrefix:"GRAPHQL_"`
+	WebSocket  configWebSocket  `envPrefix:"WEBSOCKET_"`
+	GRPC       configGRPC       `envPrefix:"GRPC_"`
+	Recorder   configRecorder   `envPrefix:"RECORDER_"`
+
+	BodyParseLimit int           `env:"BODY_PARSE_LIMIT" envDefault:"65536"`
+	Limits         configLimits  `envPrefix:"LIMITS_"`
+	Capture        configCapture `envPrefix:"CAPTURE_"`
 }
 type configS3 struct {
 	Endpoint       string             `env:"ENDPOINT"`
@@ -25,6 +39,62 @@ type configGeoIP struct {
 	CityDBPath string `env:"CITY_DB_PATH"`
 }
 
+type configRedaction struct {
+	Mode     string   `env:"MODE" envDefault:"mask"`
+	Headers  []string `env:"HEADERS" envDefault:"authorization,proxy-authorization,cookie,set-cookie,x-api-key"`
+	Fields   []string `env:"FIELDS" envDefault:"password,passwd,secret,token,access_token,refresh_token,api_key,apikey"`
+	Patterns []string `env:"PATTERNS" envSeparator:"\n"`
+}
+
+type configSecrets struct {
+	RulesPath      string `env:"RULES_PATH"`
+	FingerprintKey string `env:"FINGERPRINT_KEY"`
+}
+
+type configSignatures struct {
+	RulesDir string `env:"RULES_DIR" envDefault:"signatures"`
+}
+
+type configOpenAPI struct {
+	SpecsDir string `env:"SPECS_DIR"`
+}
+
+type configGraphQL struct {
+	MaxDepth   int `env:"MAX_DEPTH" envDefault:"10"`
+	MaxAliases int `env:"MAX_ALIASES" envDefault:"15"`
+}
+
+type configWebSocket struct {
+	InspectMessages int `env:"INSPECT_MESSAGES" envDefault:"0"`
+}
+
+type configGRPC struct {
+	DescriptorSet string `env:"DESCRIPTOR_SET"`
+}
+
+type configLimits struct {
+	BodySize           int64         `env:"BODY_SIZE" envDefault:"67108864"`
+	DecompressionRatio float64       `env:"DECOMPRESSION_RATIO" envDefault:"100"`
+	RecordTime         time.Duration `env:"RECORD_TIME" envDefault:"30s"`
+	ZipSpoolSize       int64         `env:"ZIP_SPOOL_SIZE" envDefault:"1073741824"`
+	WARCRecordSize     int64         `env:"WARC_RECORD_SIZE" envDefault:"67108864"`
+}
+
+type configCapture struct {
+	RequestLimit  int      `env:"REQUEST_LIMIT" envDefault:"8192"`
+	ResponseLimit int      `env:"RESPONSE_LIMIT" envDefault:"8192"`
+	HeadTail      bool     `env:"HEAD_TAIL"`
+	Rules         []string `env:"RULES"`
+}
+
+type configRecorder struct {
+	Upstream  string `env:"UPSTREAM"`
+	Listen    string `env:"LISTEN" envDefault:":8081"`
+	Dir       string `env:"DIR"`
+	S3Prefix  string `env:"S3_PREFIX"`
+	BodyLimit int64  `env:"BODY_LIMIT" envDefault:"67108864"`
+}
+
 func newConfig() (*config, error) {
 	cfg := config{}
 	err := env.Parse(&cfg, env.Options{
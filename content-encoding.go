@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+	"go.uber.org/multierr"
+)
+
+const (
+	// decompressionRatioMinSize is the decompressed size below which the ratio is not checked, small bodies being compressed at high ratio legitimately.
+	decompressionRatioMinSize = 1 << 20
+
+	zstdMaxWindow = 8 << 20
+)
+
+// contentEncodings returns the content codings applied to the message, in the order they were applied.
+func contentEncodings(h http.Header) (encodings []string) {
+	for _, v := range h.Values("Content-Encoding") {
+		for _, e := range strings.Split(v, ",") {
+			if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+				encodings = append(encodings, e)
+			}
+		}
+	}
+	return
+}
+
+func decoderOf(encoding string) func(io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }
+	case "deflate":
+		return newDeflateReader
+	case "br":
+		return func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(brotli.NewReader(r)), nil }
+	case "zstd":
+		return func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		}
+	case "compress", "x-compress":
+		return func(r io.Reader) (io.ReadCloser, error) { return newUnixCompressReader(r), nil }
+	}
+	return nil
+}
+
+// newDeflateReader reads deflate content, which is meant to be zlib wrapped although some servers send raw deflate data.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	if h, err := br.Peek(2); err == nil && h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (n int, err error) {
+	n, err = cr.Reader.Read(p)
+	cr.n += int64(n)
+	return
+}
+
+// decodedBody reads a body decoded from the given content codings while recording its sizes into the encoding.
+type decodedBody struct {
+	io.Reader
+	raw      *countingReader
+	decoders []io.ReadCloser
+	maxRatio float64
+	deadline time.Time
+	encoding *ecsx.ContentEncoding
+}
+
+// decode undoes the content codings of a body in reverse order. Codings preceding an unsupported one are left as is and returned as remaining.
+// Decoding stops once the decoded body exceeds the given ratio to the compressed one, zero disabling the limit.
+func decode(r io.Reader, encodings []string, maxRatio float64) (body *decodedBody, remaining []string, err error) {
+	body = &decodedBody{raw: &countingReader{Reader: r}, maxRatio: maxRatio}
+	body.Reader = body.raw
+	if len(encodings) == 0 {
+		return
+	}
+
+	body.encoding = &ecsx.ContentEncoding{Chain: encodings}
+	i := len(encodings) - 1
+	for ; i >= 0; i-- {
+		if encodings[i] == "identity" {
+			continue
+		}
+		newDecoder := decoderOf(encodings[i])
+		if newDecoder == nil {
+			body.encoding.Error = fmt.Sprintf("unsupported encoding %q", encodings[i])
+			break
+		}
+		d, err := newDecoder(body.Reader)
+		if err != nil {
+			body.Close()
+			return nil, nil, fmt.Errorf("error decoding %s: %w", encodings[i], err)
+		}
+		body.decoders = append(body.decoders, d)
+		body.Reader = d
+	}
+	if i >= 0 {
+		remaining = encodings[:i+1]
+		body.encoding.Undecoded = remaining
+	}
+	return
+}
+
+func (db *decodedBody) Read(p []byte) (n int, err error) {
+	if db.encoding == nil || len(db.decoders) == 0 {
+		return db.Reader.Read(p)
+	}
+
+	e := db.encoding
+	if e.Limit != "" {
+		return 0, io.EOF
+	}
+
+	n, err = db.Reader.Read(p)
+	e.DecompressedBytes += int64(n)
+	e.CompressedBytes = db.raw.n
+	if r := db.maxRatio; r > 0 && e.DecompressedBytes > decompressionRatioMinSize && float64(e.DecompressedBytes) > r*float64(e.CompressedBytes) {
+		e.Limit = "ratio"
+	}
+	return
+}
+
+// Close closes the decoders, then reads the rest of the raw body so that its size is known even when decoding stopped early,
+// unless the deadline of the record passes.
+func (db *decodedBody) Close() (err error) {
+	for _, d := range db.decoders {
+		if errt := d.Close(); errt != nil {
+			err = multierr.Append(err, errt)
+		}
+	}
+	if _, errt := io.Copy(io.Discard, newLimitedBody(db.raw, 0, db.deadline)); errt != nil {
+		err = multierr.Append(err, errt)
+	}
+	if db.encoding != nil {
+		db.encoding.CompressedBytes = db.raw.n
+		if len(db.decoders) == 0 {
+			db.encoding.DecompressedBytes = db.raw.n
+		}
+	}
+	return
+}
+
+var errUnixCompressCorrupted = errors.New("corrupted compress data")
+
+// unixCompressReader decodes the LZW format of compress(1), which compress/lzw does not support: code width grows up to a maximum set in the header,
+// and the codes are written in groups of eight, a group being padded whenever the width changes or the table is cleared.
+// The compressed data is read a group at a time as the output is produced, so that neither of them is held whole.
+type unixCompressReader struct {
+	src *bufio.Reader
+	err error
+
+	group  []byte // of codes of the current width
+	buf    [16]byte
+	pos    int // in bits within the group
+	nbits  int
+	max    int // maximum number of bits
+	block  bool
+	maxent int // greatest code of the current width
+	free   int
+	old    int
+	fin    byte
+
+	prefix []uint16
+	suffix []byte
+	stack  []byte
+	out    []byte
+}
+
+func newUnixCompressReader(r io.Reader) *unixCompressReader {
+	return &unixCompressReader{src: bufio.NewReader(r)}
+}
+
+func (ur *unixCompressReader) init() error {
+	h := make([]byte, 3)
+	if _, err := io.ReadFull(ur.src, h); err != nil || h[0] != 0x1f || h[1] != 0x9d {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		return fmt.Errorf("invalid compress header")
+	}
+	ur.max, ur.block = int(h[2]&0x1f), h[2]&0x80 != 0
+	if ur.max < 9 || ur.max > 16 {
+		return fmt.Errorf("unsupported compress code width %d", ur.max)
+	}
+
+	ur.nbits, ur.maxent, ur.old, ur.free = 9, 1<<9-1, -1, 256
+	if ur.block {
+		ur.free = 257
+	}
+	ur.prefix, ur.suffix = make([]uint16, 1<<ur.max), make([]byte, 1<<ur.max)
+	for i := 0; i < 256; i++ {
+		ur.suffix[i] = byte(i)
+	}
+	return nil
+}
+
+// align skips the padding ending the current group of codes.
+func (ur *unixCompressReader) align() {
+	ur.pos = len(ur.group) * 8
+}
+
+// readCode reads the next code, reading the next group once the current one is exhausted, a group of eight codes spanning as many bytes as the width.
+func (ur *unixCompressReader) readCode() (code int, err error) {
+	if ur.pos+ur.nbits > len(ur.group)*8 {
+		n, err := io.ReadFull(ur.src, ur.buf[:ur.nbits])
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		ur.group, ur.pos = ur.buf[:n], 0
+		if ur.nbits > n*8 {
+			return 0, io.EOF
+		}
+	}
+	for i := 0; i < ur.nbits; i++ {
+		p := ur.pos + i
+		code |= int(ur.group[p/8]>>(p%8)&1) << i
+	}
+	ur.pos += ur.nbits
+	return code, nil
+}
+
+// step decodes the next code into the output, returning io.EOF once the input is exhausted.
+func (ur *unixCompressReader) step() error {
+	if ur.free > ur.maxent {
+		ur.align()
+		if ur.nbits++; ur.nbits == ur.max {
+			ur.maxent = 1 << ur.max
+		} else {
+			ur.maxent = 1<<ur.nbits - 1
+		}
+	}
+
+	code, err := ur.readCode()
+	if err != nil {
+		return err
+	}
+	if ur.old == -1 {
+		if code >= 256 {
+			return errUnixCompressCorrupted
+		}
+		ur.old, ur.fin = code, byte(code)
+		ur.out = append(ur.out, ur.fin)
+		return nil
+	}
+	if code == 256 && ur.block {
+		ur.free = 256
+		ur.align()
+		ur.nbits, ur.maxent = 9, 1<<9-1
+		return nil
+	}
+
+	in := code
+	ur.stack = ur.stack[:0]
+	if code >= ur.free {
+		if code > ur.free {
+			return errUnixCompressCorrupted
+		}
+		ur.stack = append(ur.stack, ur.fin)
+		code = ur.old
+	}
+	for code >= 256 {
+		ur.stack = append(ur.stack, ur.suffix[code])
+		code = int(ur.prefix[code])
+	}
+	ur.fin = ur.suffix[code]
+	ur.stack = append(ur.stack, ur.fin)
+	for i := len(ur.stack) - 1; i >= 0; i-- {
+		ur.out = append(ur.out, ur.stack[i])
+	}
+
+	if ur.free < 1<<ur.max {
+		ur.prefix[ur.free], ur.suffix[ur.free] = uint16(ur.old), ur.fin
+		ur.free++
+	}
+	ur.old = in
+	return nil
+}
+
+func (ur *unixCompressReader) Read(p []byte) (n int, err error) {
+	if ur.prefix == nil && ur.err == nil {
+		ur.err = ur.init()
+	}
+	for len(ur.out) < len(p) && ur.err == nil {
+		ur.err = ur.step()
+	}
+
+	n = copy(p, ur.out)
+	ur.out = ur.out[n:]
+	if n == 0 && ur.err != nil {
+		return 0, ur.err
+	}
+	return n, nil
+}
+
+func (ur *unixCompressReader) Close() error { return nil }
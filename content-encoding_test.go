@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEncode(t *testing.T, b []byte, encodings ...string) []byte {
+	for _, e := range encodings {
+		buf := bytes.Buffer{}
+		var w io.WriteCloser
+		switch e {
+		case "gzip":
+			w = gzip.NewWriter(&buf)
+		case "deflate":
+			w = zlib.NewWriter(&buf)
+		case "raw-deflate":
+			w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		case "br":
+			w = brotli.NewWriter(&buf)
+		case "zstd":
+			var err error
+			w, err = zstd.NewWriter(&buf)
+			require.NoError(t, err)
+		default:
+			t.Fatalf("unknown encoding %s", e)
+		}
+		_, err := w.Write(b)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		b = buf.Bytes()
+	}
+	return b
+}
+
+func TestDecode(t *testing.T) {
+	content := []byte(strings.Repeat(`{"name":"enricher","tags":["a","b"]}`, 100))
+	compressed, err := os.ReadFile("testdata/compress.txt.Z")
+	require.NoError(t, err)
+	plain, err := os.ReadFile("testdata/compress.txt")
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		body      []byte
+		encodings []string
+		content   []byte
+		remaining []string
+		errorMsg  string
+	}{
+		"gzip":         {body: testEncode(t, content, "gzip"), encodings: []string{"gzip"}},
+		"x-gzip":       {body: testEncode(t, content, "gzip"), encodings: []string{"x-gzip"}},
+		"zlib deflate": {body: testEncode(t, content, "deflate"), encodings: []string{"deflate"}},
+		"raw deflate":  {body: testEncode(t, content, "raw-deflate"), encodings: []string{"deflate"}},
+		"br":           {body: testEncode(t, content, "br"), encodings: []string{"br"}},
+		"zstd":         {body: testEncode(t, content, "zstd"), encodings: []string{"zstd"}},
+		"compress":     {body: compressed, encodings: []string{"compress"}, content: plain},
+		"stacked":      {body: testEncode(t, content, "gzip", "br"), encodings: []string{"gzip", "br"}},
+		"stacked zstd": {body: testEncode(t, content, "zstd", "gzip"), encodings: []string{"zstd", "identity", "gzip"}},
+		"identity":     {body: content, encodings: []string{"identity"}},
+		"unsupported": {
+			body: testEncode(t, content, "gzip"), encodings: []string{"rot13", "gzip"},
+			remaining: []string{"rot13"}, errorMsg: `unsupported encoding "rot13"`,
+		},
+	}
+	for name, tt := range tests {
+		body, remaining, err := decode(bytes.NewReader(tt.body), tt.encodings, 0)
+		require.NoError(t, err, name)
+		b, err := io.ReadAll(body)
+		require.NoError(t, err, name)
+		require.NoError(t, body.Close(), name)
+
+		want := tt.content
+		if want == nil {
+			want = content
+		}
+		assert.Equal(t, want, b, name)
+		assert.Equal(t, tt.remaining, remaining, name)
+		assert.Equal(t, tt.encodings, body.encoding.Chain, name)
+		assert.Equal(t, tt.errorMsg, body.encoding.Error, name)
+		assert.Equal(t, int64(len(tt.body)), body.encoding.CompressedBytes, name)
+		assert.Equal(t, int64(len(b)), body.encoding.DecompressedBytes, name)
+	}
+}
+
+func TestDecodeRatioLimit(t *testing.T) {
+	bomb := testEncode(t, make([]byte, 16<<20), "gzip")
+
+	body, _, err := decode(bytes.NewReader(bomb), []string{"gzip"}, 100)
+	require.NoError(t, err)
+	b, err := io.ReadAll(body)
+	require.NoError(t, err, "should stop gracefully")
+	require.NoError(t, body.Close())
+	assert.Equal(t, "ratio", body.encoding.Limit)
+	assert.Less(t, len(b), 16<<20)
+	assert.Equal(t, int64(len(bomb)), body.encoding.CompressedBytes, "should still measure the whole compressed body")
+}
+
+func TestDecodeRatioLimitStacked(t *testing.T) {
+	// zero codes of compress(1) data decode to a byte each, and are gzipped at a high ratio
+	compressed := append([]byte{0x1f, 0x9d, 0x90}, make([]byte, 64<<20)...)
+	bomb := testEncode(t, compressed, "gzip")
+
+	body, _, err := decode(bytes.NewReader(bomb), []string{"compress", "gzip"}, 100)
+	require.NoError(t, err)
+	_, err = io.Copy(io.Discard, body)
+	require.NoError(t, err, "should stop gracefully")
+	assert.Equal(t, "ratio", body.encoding.Limit)
+	assert.Less(t, body.raw.n, int64(len(bomb)), "should stop reading the gzipped compress data once the limit is reached")
+	require.NoError(t, body.Close())
+}
+
+func TestEnrichmentStackedEncodings(t *testing.T) {
+	content := `{"message":"hello"}`
+	req := httptest.NewRequest(http.MethodGet, "http://api.example.com/hello", nil)
+	res := testResponse(req, http.StatusOK, http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"gzip", "br"}},
+		testEncode(t, []byte(content), "gzip", "br"))
+
+	ercr, err := newEnricher()
+	require.NoError(t, err)
+	erc, err := ercr.EnrichRecord(testRecord(t, req, res, &httpRecordedMessageContext{ID: "encoding"}))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+
+	r := docs[0].HTTP.Response
+	assert.Equal(t, content, r.Body.Content)
+	assert.NotContains(t, r.Headers, "content-encoding")
+	require.NotNil(t, r.Encoding)
+	assert.Equal(t, []string{"gzip", "br"}, r.Encoding.Chain)
+	assert.Equal(t, int64(len(content)), r.Encoding.DecompressedBytes)
+	assert.Nil(t, docs[0].HTTP.Request.Encoding)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+var (
+	numericSegmentRegexp = regexp.MustCompile(`^[0-9]+$`)
+	uuidSegmentRegexp    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hashSegmentRegexp    = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+)
+
+// routeTemplate collapses the variable segments of a URL path so that requests to the same endpoint are grouped together.
+func routeTemplate(path string) string {
+	segs := strings.Split(path, "/")
+	for i, s := range segs {
+		switch {
+		case numericSegmentRegexp.MatchString(s):
+			segs[i] = "{id}"
+		case uuidSegmentRegexp.MatchString(s):
+			segs[i] = "{uuid}"
+		case hashSegmentRegexp.MatchString(s) && strings.ContainsAny(s, "0123456789"):
+			segs[i] = "{hash}"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// endpointSamplesLimit is the maximum number of distinct paths kept as samples for each undocumented endpoint.
+const endpointSamplesLimit = 5
+
+type endpointUsage struct {
+	Host        string   `json:"host,omitempty"`
+	Spec        string   `json:"spec,omitempty"`
+	Method      string   `json:"method"`
+	Route       string   `json:"route"`
+	OperationID string   `json:"operation_id,omitempty"`
+	Deprecated  bool     `json:"deprecated,omitempty"`
+	Count       int      `json:"count"`
+	Samples     []string `json:"samples,omitempty"`
+}
+
+func (u *endpointUsage) sample(path string) {
+	if len(u.Samples) >= endpointSamplesLimit {
+		return
+	}
+	for _, s := range u.Samples {
+		if s == path {
+			return
+		}
+	}
+	u.Samples = append(u.Samples, path)
+}
+
+type endpointReport struct {
+	Records      int              `json:"records"`
+	Failures     int              `json:"failures"`
+	Undocumented []*endpointUsage `json:"undocumented"`
+	Deprecated   []*endpointUsage `json:"deprecated"`
+	Unused       []*endpointUsage `json:"unused"`
+}
+
+type endpointKey struct {
+	owner  string
+	method string
+	route  string
+}
+
+// endpointDiscovery aggregates enriched documents into a report of undocumented endpoints, deprecated endpoints that still receive traffic,
+// and documented operations that never receive traffic.
+type endpointDiscovery struct {
+	registry *openAPIRegistry
+
+	records      int
+	failures     int
+	documented   map[endpointKey]*endpointUsage
+	undocumented map[endpointKey]*endpointUsage
+}
+
+func newEndpointDiscovery(registry *openAPIRegistry) *endpointDiscovery {
+	d := &endpointDiscovery{
+		registry:     registry,
+		documented:   map[endpointKey]*endpointUsage{},
+		undocumented: map[endpointKey]*endpointUsage{},
+	}
+	if registry == nil {
+		return d
+	}
+
+	for _, spec := range registry.specs {
+		prefixes := []string{""}
+		if len(spec.doc.Servers) > 0 {
+			prefixes = prefixes[:0]
+			for _, s := range spec.doc.Servers {
+				prefixes = append(prefixes, strings.TrimSuffix(s.URL, "/"))
+			}
+		}
+		for path, item := range spec.doc.Paths {
+			for method, op := range item.Operations() {
+				for _, prefix := range prefixes {
+					d.documented[endpointKey{spec.file, method, prefix + path}] = &endpointUsage{
+						Host:        spec.hosts[0],
+						Spec:        filepath.Base(spec.file),
+						Method:      method,
+						Route:       prefix + path,
+						OperationID: op.OperationID,
+						Deprecated:  op.Deprecated,
+					}
+				}
+			}
+		}
+	}
+	return d
+}
+
+func (d *endpointDiscovery) add(doc *ecsx.Document) {
+	d.records++
+	if doc.URL == nil || doc.HTTP == nil || doc.HTTP.Request == nil {
+		return
+	}
+
+	host := strings.ToLower(doc.URL.Domain)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	method := doc.HTTP.Request.Method
+
+	if doc.OpenAPI != nil && doc.OpenAPI.Matched && d.registry != nil {
+		if spec := d.registry.lookup(host); spec != nil {
+			if u, ok := d.documented[endpointKey{spec.file, method, doc.OpenAPI.Route}]; ok {
+				u.Count++
+				return
+			}
+		}
+	}
+
+	key := endpointKey{host, method, routeTemplate(doc.URL.Path)}
+	u, ok := d.undocumented[key]
+	if !ok {
+		u = &endpointUsage{Host: host, Method: method, Route: key.route}
+		d.undocumented[key] = u
+	}
+	u.Count++
+	u.sample(doc.URL.Path)
+}
+
+// addRecord enriches the given record and adds it to the report. Failing records are counted instead of aborting the whole report.
+func (d *endpointDiscovery) addRecord(ercr *enricher, r io.Reader) (err error) {
+	defer func() {
+		if err != nil {
+			d.failures++
+		}
+	}()
+
+	erc, err := ercr.EnrichRecord(r)
+	if err != nil {
+		return
+	}
+	defer erc.Close()
+
+	docs, err := erc.toECS()
+	if err != nil {
+		return
+	}
+	for _, doc := range docs {
+		d.add(doc)
+	}
+	return
+}
+
+// addRecords adds every record contained in r, see openRecordEntries.
+func (d *endpointDiscovery) addRecords(ercr *enricher, name string, r io.Reader) (err error) {
+	entries, err := openRecordEntries(name, r, ercr.ingestLimits)
+	if err != nil {
+		return
+	}
+	defer entries.Close()
+
+	for {
+		entry, record, err := entries.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if entryErr := (*recordEntryError)(nil); errors.As(err, &entryErr) {
+			log.Printf("error processing %s: %v", entryErr.entry, entryErr.err)
+			d.failures++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := d.addRecord(ercr, record); err != nil {
+			log.Printf("error processing %s: %v", entry, err)
+			io.Copy(io.Discard, record)
+		}
+	}
+}
+
+func (d *endpointDiscovery) report() *endpointReport {
+	r := &endpointReport{
+		Records:      d.records,
+		Failures:     d.failures,
+		Undocumented: []*endpointUsage{},
+		Deprecated:   []*endpointUsage{},
+		Unused:       []*endpointUsage{},
+	}
+	for _, u := range d.undocumented {
+		r.Undocumented = append(r.Undocumented, u)
+	}
+	for _, u := range d.documented {
+		switch {
+		case u.Count == 0:
+			r.Unused = append(r.Unused, u)
+		case u.Deprecated:
+			r.Deprecated = append(r.Deprecated, u)
+		}
+	}
+	for _, l := range [][]*endpointUsage{r.Undocumented, r.Deprecated, r.Unused} {
+		sortEndpointUsages(l)
+	}
+	return r
+}
+
+func sortEndpointUsages(l []*endpointUsage) {
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Host != l[j].Host {
+			return l[i].Host < l[j].Host
+		}
+		if l[i].Route != l[j].Route {
+			return l[i].Route < l[j].Route
+		}
+		return l[i].Method < l[j].Method
+	})
+}
+
+func (r *endpointReport) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		return fmt.Errorf("error encoding report: %w", err)
+	}
+	return nil
+}
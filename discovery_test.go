@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telkomindonesia/httpmsg-enricher/ecs"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+func TestRouteTemplate(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/":                   "/",
+		"/users/123":          "/users/{id}",
+		"/users/123/orders/9": "/users/{id}/orders/{id}",
+		"/bins/3c5e5f4c-1f0a-4f5e-9d1b-8a6f1c2b3d4e": "/bins/{uuid}",
+		"/blobs/9f86d081884c7d659a2feaa0c55ad015":    "/blobs/{hash}",
+		"/v1/accessibility":                          "/v1/accessibility",
+		"/static/deadbeefcafebabe":                   "/static/deadbeefcafebabe",
+	} {
+		assert.Equal(t, expected, routeTemplate(path), path)
+	}
+}
+
+func TestEndpointDiscovery(t *testing.T) {
+	ercr, err := newEnricher(enricherWithOpenAPI("testdata/openapi"))
+	require.NoError(t, err, "should load specs")
+
+	d := newEndpointDiscovery(ercr.openAPI)
+	f, err := os.ReadFile("testdata/record.txt")
+	require.NoError(t, err, "unexpected error in reading test data")
+	require.NoError(t, d.addRecord(ercr, bytes.NewReader(f)))
+	require.Error(t, d.addRecord(ercr, bytes.NewReader([]byte("invalid"))))
+
+	newDoc := func(host, method, path string, oas *ecsx.OpenAPI) *ecsx.Document {
+		return &ecsx.Document{
+			Document: ecs.Document{URL: &ecs.URL{Domain: host, Path: path}},
+			HTTP:     &ecsx.HTTP{Request: &ecsx.HTTPRequest{HTTPRequest: ecs.HTTPRequest{Method: method}}},
+			OpenAPI:  oas,
+		}
+	}
+	d.add(newDoc("mockbin.org", "GET", "/bins/3c5e5f4c-1f0a-4f5e-9d1b-8a6f1c2b3d4e", &ecsx.OpenAPI{Matched: true, Route: "/bins/{id}", Deprecated: true}))
+	d.add(newDoc("mockbin.org:443", "DELETE", "/bins/1", &ecsx.OpenAPI{}))
+	d.add(newDoc("mockbin.org", "DELETE", "/bins/2", &ecsx.OpenAPI{}))
+	d.add(newDoc("example.com", "GET", "/users/1", nil))
+
+	r := d.report()
+	assert.Equal(t, 5, r.Records)
+	assert.Equal(t, 1, r.Failures)
+	assert.Empty(t, r.Unused, "all documented operations are used")
+
+	require.Len(t, r.Deprecated, 1)
+	assert.Equal(t, "getBin", r.Deprecated[0].OperationID)
+	assert.Equal(t, 1, r.Deprecated[0].Count)
+
+	require.Len(t, r.Undocumented, 2)
+	assert.Equal(t, &endpointUsage{Host: "example.com", Method: "GET", Route: "/users/{id}", Count: 1, Samples: []string{"/users/1"}}, r.Undocumented[0])
+	assert.Equal(t, &endpointUsage{Host: "mockbin.org", Method: "DELETE", Route: "/bins/{id}", Count: 2, Samples: []string{"/bins/1", "/bins/2"}}, r.Undocumented[1])
+
+	d = newEndpointDiscovery(ercr.openAPI)
+	r = d.report()
+	assert.Len(t, r.Unused, 2, "should report unused operations")
+}
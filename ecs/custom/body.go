@@ -0,0 +1,19 @@
+package ecsx
+
+type BodyPart struct {
+	Name        string `json:"name,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	MimeType    string `json:"mime_type,omitempty"`
+	Size        int64  `json:"size"`
+	Value       string `json:"value,omitempty"`
+}
+
+type Body struct {
+	Type      string              `json:"type"`
+	JSON      interface{}         `json:"json,omitempty"`
+	Form      map[string][]string `json:"form,omitempty"`
+	Parts     []BodyPart          `json:"parts,omitempty"`
+	Truncated bool                `json:"truncated,omitempty"`
+	Error     string              `json:"error,omitempty"`
+}
@@ -0,0 +1,10 @@
+package ecsx
+
+import "time"
+
+type Connection struct {
+	ID        string     `json:"id,omitempty"`
+	Exchanges int        `json:"exchanges"`
+	Start     *time.Time `json:"start,omitempty"`
+	End       *time.Time `json:"end,omitempty"`
+}
@@ -6,5 +6,16 @@ type Document struct {
 	ecs.Document
 	HTTP *HTTP `json:"http"`
 
-	CRS *CRS `json:"_crs"`
+	CRS        *CRS        `json:"_crs"`
+	Secrets    []Secret    `json:"_secrets,omitempty"`
+	Files      []File      `json:"_files,omitempty"`
+	Signatures []Signature `json:"_signatures,omitempty"`
+	OpenAPI    *OpenAPI    `json:"_openapi,omitempty"`
+	GraphQL    *GraphQL    `json:"_graphql,omitempty"`
+	WebSocket  *WebSocket  `json:"_websocket,omitempty"`
+	SSE        *SSE        `json:"_sse,omitempty"`
+	GRPC       *GRPC       `json:"_grpc,omitempty"`
+	Latency    *Latency    `json:"_latency,omitempty"`
+	Connection *Connection `json:"_connection,omitempty"`
+	Ingest     *Ingest     `json:"_ingest,omitempty"`
 }
@@ -0,0 +1,14 @@
+package ecsx
+
+import "github.com/telkomindonesia/httpmsg-enricher/ecs"
+
+type File struct {
+	ecs.File
+
+	Field             string `json:"field,omitempty"`
+	DeclaredMimeType  string `json:"declared_mime_type,omitempty"`
+	ExtensionMimeType string `json:"extension_mime_type,omitempty"`
+	MimeMismatch      bool   `json:"mime_mismatch"`
+	ExtensionMismatch bool   `json:"extension_mismatch"`
+	Disguised         bool   `json:"disguised"`
+}
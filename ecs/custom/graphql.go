@@ -0,0 +1,20 @@
+package ecsx
+
+type GraphQLOperation struct {
+	Type          string   `json:"type"`
+	Name          string   `json:"name,omitempty"`
+	RootFields    []string `json:"root_fields,omitempty"`
+	Depth         int      `json:"depth"`
+	Aliases       int      `json:"aliases"`
+	Introspection bool     `json:"introspection,omitempty"`
+}
+
+type GraphQL struct {
+	Source           string             `json:"source"`
+	BatchSize        int                `json:"batch_size"`
+	Operations       []GraphQLOperation `json:"operations,omitempty"`
+	Introspection    bool               `json:"introspection,omitempty"`
+	ExcessiveDepth   bool               `json:"excessive_depth,omitempty"`
+	ExcessiveAliases bool               `json:"excessive_aliases,omitempty"`
+	Errors           []string           `json:"errors,omitempty"`
+}
@@ -0,0 +1,24 @@
+package ecsx
+
+import "encoding/json"
+
+type GRPCMessages struct {
+	Messages        int               `json:"messages"`
+	Bytes           int64             `json:"bytes"`
+	MaxMessageBytes int64             `json:"max_message_bytes"`
+	Compressed      int               `json:"compressed,omitempty"`
+	Decoded         []json.RawMessage `json:"decoded,omitempty"`
+}
+
+type GRPC struct {
+	Web        bool         `json:"web,omitempty"`
+	Service    string       `json:"service,omitempty"`
+	Method     string       `json:"method,omitempty"`
+	Encoding   string       `json:"encoding,omitempty"`
+	StatusCode *int         `json:"status_code,omitempty"`
+	Status     string       `json:"status,omitempty"`
+	Message    string       `json:"message,omitempty"`
+	Request    GRPCMessages `json:"request"`
+	Response   GRPCMessages `json:"response"`
+	Errors     []string     `json:"errors,omitempty"`
+}
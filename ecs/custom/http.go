@@ -2,19 +2,55 @@ package ecsx
 
 import "github.com/telkomindonesia/httpmsg-enricher/ecs"
 
+// ContentEncoding describes the content codings of a body, in the order they were applied.
+type ContentEncoding struct {
+	Chain             []string `json:"chain"`
+	Undecoded         []string `json:"undecoded,omitempty"`
+	CompressedBytes   int64    `json:"compressed_bytes"`
+	DecompressedBytes int64    `json:"decompressed_bytes"`
+	Limit             string   `json:"limit,omitempty"`
+	Error             string   `json:"error,omitempty"`
+}
+
+// BodyLimit tells why the processing of a body stopped before its end.
+type BodyLimit struct {
+	Reason         string `json:"reason"`
+	ProcessedBytes int64  `json:"processed_bytes"`
+}
+
+// BodyCapture tells which part of a body is kept in its content. When both its head and tail are kept, the content is made of both, directly following each other.
+type BodyCapture struct {
+	Truncated     bool   `json:"truncated"`
+	OriginalBytes int64  `json:"original_bytes"`
+	HeadBytes     int    `json:"head_bytes,omitempty"`
+	TailBytes     int    `json:"tail_bytes,omitempty"`
+	Encoding      string `json:"encoding,omitempty"`
+	Dropped       bool   `json:"dropped,omitempty"`
+}
+
 type HTTPRequest struct {
 	ecs.HTTPRequest
 
-	Headers map[string][]string `json:"_headers"`
+	Headers     map[string][]string `json:"_headers"`
+	ParsedBody  *Body               `json:"_body,omitempty"`
+	ReferrerURL *ecs.URL            `json:"_referrer,omitempty"`
+	Encoding    *ContentEncoding    `json:"_encoding,omitempty"`
+	Limited     *BodyLimit          `json:"_limited,omitempty"`
+	Capture     *BodyCapture        `json:"_capture,omitempty"`
 }
 type HTTPResponse struct {
 	ecs.HTTPResponse
 
-	Headers map[string][]string `json:"_headers"`
+	Headers    map[string][]string `json:"_headers"`
+	ParsedBody *Body               `json:"_body,omitempty"`
+	Encoding   *ContentEncoding    `json:"_encoding,omitempty"`
+	Limited    *BodyLimit          `json:"_limited,omitempty"`
+	Capture    *BodyCapture        `json:"_capture,omitempty"`
 }
 type HTTP struct {
 	ecs.HTTP
 
+	Route    string        `json:"route,omitempty"`
 	Request  *HTTPRequest  `json:"request,omitempty"`
 	Response *HTTPResponse `json:"response,omitempty"`
 }
@@ -0,0 +1,6 @@
+package ecsx
+
+type Ingest struct {
+	Entry string `json:"entry"`
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,10 @@
+package ecsx
+
+import "time"
+
+// Latency breaks down event.duration, in nanoseconds.
+type Latency struct {
+	Total    time.Duration  `json:"total"`
+	Upstream *time.Duration `json:"upstream,omitempty"`
+	Overhead *time.Duration `json:"overhead,omitempty"`
+}
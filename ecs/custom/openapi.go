@@ -0,0 +1,17 @@
+package ecsx
+
+type OpenAPIViolation struct {
+	In       string `json:"in"`
+	Location string `json:"location,omitempty"`
+	Message  string `json:"message"`
+}
+
+type OpenAPI struct {
+	Spec        string             `json:"spec,omitempty"`
+	SpecVersion string             `json:"spec_version,omitempty"`
+	Matched     bool               `json:"matched"`
+	OperationID string             `json:"operation_id,omitempty"`
+	Route       string             `json:"route,omitempty"`
+	Deprecated  bool               `json:"deprecated,omitempty"`
+	Violations  []OpenAPIViolation `json:"violations,omitempty"`
+}
@@ -0,0 +1,11 @@
+package ecsx
+
+type Secret struct {
+	RuleID      string  `json:"rule_id"`
+	Description string  `json:"description,omitempty"`
+	Location    string  `json:"location"`
+	Offset      *int64  `json:"offset,omitempty"`
+	Redacted    string  `json:"redacted"`
+	Fingerprint string  `json:"fingerprint"`
+	Entropy     float64 `json:"entropy,omitempty"`
+}
@@ -0,0 +1,15 @@
+package ecsx
+
+type SignatureString struct {
+	ID      string  `json:"id"`
+	Count   int     `json:"count"`
+	Offsets []int64 `json:"offsets,omitempty"`
+}
+
+type Signature struct {
+	Rule        string            `json:"rule"`
+	Tags        []string          `json:"tags,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Location    string            `json:"location"`
+	Strings     []SignatureString `json:"strings,omitempty"`
+}
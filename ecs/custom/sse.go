@@ -0,0 +1,23 @@
+package ecsx
+
+import "time"
+
+type SSEEventType struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+type SSE struct {
+	Events          int            `json:"events"`
+	Types           []SSEEventType `json:"types,omitempty"`
+	IDs             int            `json:"ids"`
+	FirstID         string         `json:"first_id,omitempty"`
+	LastID          string         `json:"last_id,omitempty"`
+	Comments        int            `json:"comments,omitempty"`
+	DataBytes       int64          `json:"data_bytes"`
+	MaxDataBytes    int64          `json:"max_data_bytes"`
+	Retry           *int64         `json:"retry,omitempty"`
+	Incomplete      bool           `json:"incomplete,omitempty"`
+	Duration        *time.Duration `json:"duration,omitempty"`
+	EventsPerSecond *float64       `json:"events_per_second,omitempty"`
+}
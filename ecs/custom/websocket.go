@@ -0,0 +1,28 @@
+package ecsx
+
+type WebSocketPeer struct {
+	Frames          int   `json:"frames"`
+	Messages        int   `json:"messages"`
+	Text            int   `json:"text"`
+	Binary          int   `json:"binary"`
+	Bytes           int64 `json:"bytes"`
+	MaxMessageBytes int64 `json:"max_message_bytes"`
+	Pings           int   `json:"pings,omitempty"`
+	Pongs           int   `json:"pongs,omitempty"`
+}
+
+type WebSocketClose struct {
+	Code      int    `json:"code,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Initiator string `json:"initiator"`
+}
+
+type WebSocket struct {
+	Subprotocol string          `json:"subprotocol,omitempty"`
+	Extensions  []string        `json:"extensions,omitempty"`
+	Client      WebSocketPeer   `json:"client"`
+	Server      WebSocketPeer   `json:"server"`
+	Close       *WebSocketClose `json:"close,omitempty"`
+	Inspected   int             `json:"inspected,omitempty"`
+	Errors      []string        `json:"errors,omitempty"`
+}
@@ -12,11 +12,14 @@ type Document struct {
 	Source      *Endpoint `json:"source,omitempty"`
 	Destination *Endpoint `json:"destination,omitempty"`
 
+	File *File `json:"file,omitempty"`
 	HTTP *HTTP `json:"http,omitempty"`
 	URL  *URL  `json:"url,omitempty"`
 
 	User      *User      `json:"user,omitempty"`
 	UserAgent *UserAgent `json:"user_agent,omitempty"`
 
+	Related *Related `json:"related,omitempty"`
+
 	Threat *Threat `json:"threat,omitempty"`
 }
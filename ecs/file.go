@@ -0,0 +1,20 @@
+package ecs
+
+type Hash struct {
+	MD5    string `json:"md5,omitempty"`
+	SHA1   string `json:"sha1,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	SHA512 string `json:"sha512,omitempty"`
+}
+
+type File struct {
+	Directory string `json:"directory,omitempty"`
+	Extension string `json:"extension,omitempty"`
+	MimeType  string `json:"mime_type,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Type      string `json:"type,omitempty"`
+
+	Hash *Hash `json:"hash,omitempty"`
+}
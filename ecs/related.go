@@ -0,0 +1,10 @@
+package ecs
+
+import "net"
+
+type Related struct {
+	Hash  []string `json:"hash,omitempty"`
+	Hosts []string `json:"hosts,omitempty"`
+	IP    []net.IP `json:"ip,omitempty"`
+	User  []string `json:"user,omitempty"`
+}
@@ -1,21 +1,52 @@
 package main
 
 import (
+	"crypto/rand"
 	"fmt"
 	"io"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/corazawaf/coraza/v2"
 	"github.com/corazawaf/coraza/v2/seclang"
 	"github.com/oschwald/geoip2-golang"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 type enricher struct {
-	waf   *coraza.Waf
-	geoDB *geoip2.Reader
+	waf      *coraza.Waf
+	geoDB    *geoip2.Reader
+	redactor *redactor
+
+	secretRules    []*secretRule
+	secretKey      []byte
+	signatureRules []*signatureRule
+	openAPI        *openAPIRegistry
+	grpcFiles      *protoregistry.Files
+
+	bodyParseLimit    int
+	limits            bodyLimits
+	ingestLimits      ingestLimits
+	capture           *bodyCapturePolicies
+	graphQLMaxDepth   int
+	graphQLMaxAliases int
+
+	websocketInspectMessages int
 }
 
 func newEnricher(opts ...enricherFunc) (ercr *enricher, err error) {
-	ercr = &enricher{}
+	ercr = &enricher{
+		bodyParseLimit: defaultBodyParseLimit,
+		limits:         bodyLimits{maxSize: defaultBodyMaxSize, maxRatio: defaultDecompressionMaxRatio, timeout: defaultRecordTimeout},
+		ingestLimits:   ingestLimits{zipSpool: defaultZipSpoolLimit, warcRecord: defaultWARCRecordLimit},
+		capture: &bodyCapturePolicies{
+			request:  bodyCapturePolicy{limit: defaultBodyCaptureLimit},
+			response: bodyCapturePolicy{limit: defaultBodyCaptureLimit},
+		},
+		graphQLMaxDepth:   defaultGraphQLMaxDepth,
+		graphQLMaxAliases: defaultGraphQLMaxAliases,
+	}
 	for _, opt := range opts {
 		if err = opt(ercr); err != nil {
 			return nil, err
@@ -54,14 +85,166 @@ func enricherWithCRS(rules ...string) enricherFunc {
 	}
 }
 
-func (ercr *enricher) newEnrichment(record io.Reader) (erc *enrichment) {
+func enricherWithRedaction(mode string, headers, fields, patterns []string) enricherFunc {
+	return func(ercr *enricher) (err error) {
+		ercr.redactor, err = newRedactor(mode, headers, fields, patterns)
+		if err != nil {
+			return fmt.Errorf("error initializing redactor: %w", err)
+		}
+		return
+	}
+}
+
+// enricherWithSecrets enables secrets detection. Findings are fingerprinted with an HMAC keyed by fingerprintKey,
+// a random key being used when empty, in which case fingerprints only correlate findings of the same process.
+func enricherWithSecrets(rulesPath, fingerprintKey string) enricherFunc {
+	return func(ercr *enricher) (err error) {
+		ercr.secretKey = []byte(fingerprintKey)
+		if fingerprintKey == "" {
+			ercr.secretKey = make([]byte, 32)
+			if _, err = rand.Read(ercr.secretKey); err != nil {
+				return fmt.Errorf("error generating secret fingerprint key: %w", err)
+			}
+		}
+
+		rules, err := loadSecretRules(strings.NewReader(builtinSecretRules))
+		if err != nil {
+			return fmt.Errorf("error loading builtin secret rules: %w", err)
+		}
+
+		if rulesPath != "" {
+			f, err := os.Open(rulesPath)
+			if err != nil {
+				return fmt.Errorf("error opening secret rules from %s: %w", rulesPath, err)
+			}
+			defer f.Close()
+
+			extra, err := loadSecretRules(f)
+			if err != nil {
+				return fmt.Errorf("error loading secret rules from %s: %w", rulesPath, err)
+			}
+			rules = append(rules, extra...)
+		}
+
+		ercr.secretRules = rules
+		return
+	}
+}
+
+func enricherWithSignatures(dir string) enricherFunc {
+	if dir == "" {
+		return enricherFuncNOOP
+	}
+	return func(ercr *enricher) (err error) {
+		if ercr.signatureRules, err = loadSignatureRules(dir); err != nil {
+			return fmt.Errorf("error loading signature rules from %s: %w", dir, err)
+		}
+		return
+	}
+}
+
+func enricherWithOpenAPI(dir string) enricherFunc {
+	if dir == "" {
+		return enricherFuncNOOP
+	}
+	return func(ercr *enricher) (err error) {
+		if ercr.openAPI, err = loadOpenAPIRegistry(dir); err != nil {
+			return fmt.Errorf("error loading openapi specs from %s: %w", dir, err)
+		}
+		return
+	}
+}
+
+func enricherWithGRPCDescriptorSet(file string) enricherFunc {
+	if file == "" {
+		return enricherFuncNOOP
+	}
+	return func(ercr *enricher) (err error) {
+		if ercr.grpcFiles, err = loadGRPCDescriptors(file); err != nil {
+			return fmt.Errorf("error loading grpc descriptor set from %s: %w", file, err)
+		}
+		return
+	}
+}
+
+func enricherWithBodyParseLimit(limit int) enricherFunc {
+	return func(ercr *enricher) error {
+		if limit <= 0 {
+			return fmt.Errorf("invalid body parse limit: %d", limit)
+		}
+		ercr.bodyParseLimit = limit
+		return nil
+	}
+}
+
+// enricherWithBodyLimits stops processing bodies beyond the given decoded size or ratio to their compressed size,
+// or once their record has been processed for the given duration. Zero disables a limit.
+func enricherWithBodyLimits(maxSize int64, maxRatio float64, timeout time.Duration) enricherFunc {
+	return func(ercr *enricher) error {
+		if maxSize < 0 || maxRatio < 0 || timeout < 0 {
+			return fmt.Errorf("invalid body limits: size %d, ratio %v, timeout %v", maxSize, maxRatio, timeout)
+		}
+		ercr.limits = bodyLimits{maxSize: maxSize, maxRatio: maxRatio, timeout: timeout}
+		return nil
+	}
+}
+
+// enricherWithIngestLimits sets the size up to which zip archives which are not regular files are spooled into a temporary file, zero rejecting them,
+// and the size beyond which WARC records are reported as failing entries instead of being read, zero disabling the limit.
+func enricherWithIngestLimits(zipSpool, warcRecord int64) enricherFunc {
+	return func(ercr *enricher) error {
+		if zipSpool < 0 || warcRecord < 0 {
+			return fmt.Errorf("invalid ingest limits: zip spool %d, warc record %d", zipSpool, warcRecord)
+		}
+		ercr.ingestLimits = ingestLimits{zipSpool: zipSpool, warcRecord: warcRecord}
+		return nil
+	}
+}
+
+// enricherWithBodyCapture sets how much of bodies is kept in the documents, by default for each direction and by rules for some media types.
+func enricherWithBodyCapture(requestLimit, responseLimit int, headTail bool, rules []string) enricherFunc {
+	return func(ercr *enricher) (err error) {
+		if ercr.capture, err = newBodyCapturePolicies(requestLimit, responseLimit, headTail, rules); err != nil {
+			return fmt.Errorf("error loading body capture policies: %w", err)
+		}
+		return
+	}
+}
+
+func enricherWithGraphQLLimits(maxDepth, maxAliases int) enricherFunc {
+	return func(ercr *enricher) error {
+		if maxDepth <= 0 || maxAliases < 0 {
+			return fmt.Errorf("invalid graphql limits: depth %d, aliases %d", maxDepth, maxAliases)
+		}
+		ercr.graphQLMaxDepth, ercr.graphQLMaxAliases = maxDepth, maxAliases
+		return nil
+	}
+}
+
+// enricherWithWebSocketInspection runs up to the given number of text messages sent by each WebSocket client through CRS, if enabled.
+func enricherWithWebSocketInspection(maxMessages int) enricherFunc {
+	return func(ercr *enricher) error {
+		if maxMessages < 0 {
+			return fmt.Errorf("invalid websocket inspected messages: %d", maxMessages)
+		}
+		ercr.websocketInspectMessages = maxMessages
+		return nil
+	}
+}
+
+func (ercr *enricher) newEnrichment(msg *httpRecordedMessage) (erc *enrichment) {
+	body := newBodyEnricher(ercr.bodyParseLimit)
 	erc = &enrichment{
 		ercr: ercr,
-		msg:  newHTTPRecordedMessage(record),
+		msg:  msg,
 
 		secs: []subEnricher{
+			body,
+			newUploadEnricher(body),
 			&mimeEnricher{req: newWritableMimeReader(), res: newWritableMimeReader()},
 			&uaEnricher{},
+			&urlEnricher{},
+			newGraphQLEnricher(body, ercr.bodyParseLimit, ercr.graphQLMaxDepth, ercr.graphQLMaxAliases),
 		},
 	}
 	if ercr.waf != nil {
@@ -70,18 +253,55 @@ func (ercr *enricher) newEnrichment(record io.Reader) (erc *enrichment) {
 	if ercr.geoDB != nil {
 		erc.secs = append(erc.secs, &geoipEnricher{ercr.geoDB})
 	}
+	if ercr.secretRules != nil {
+		erc.secs = append(erc.secs, newSecretsEnricher(ercr.secretRules, ercr.secretKey, body))
+	}
+	if ercr.signatureRules != nil {
+		erc.secs = append(erc.secs, newSignatureEnricher(ercr.signatureRules))
+	}
+	if ercr.openAPI != nil {
+		erc.secs = append(erc.secs, newOpenAPIEnricher(ercr.openAPI))
+	}
+	erc.secs = append(erc.secs, newWebSocketEnricher(ercr.bodyParseLimit, ercr.waf, ercr.websocketInspectMessages))
+	erc.secs = append(erc.secs, newSSEEnricher())
+	erc.secs = append(erc.secs, newGRPCEnricher(ercr.bodyParseLimit, ercr.grpcFiles))
+	erc.secs = append(erc.secs, &endpointEnricher{})
 	return
 }
 
-func (ercr *enricher) EnrichRecord(record io.Reader) (erc *enrichment, err error) {
-	erc = ercr.newEnrichment(record)
+// EnrichRecord enriches every request/response exchange of the record.
+func (ercr *enricher) EnrichRecord(record io.Reader) (ercs enrichments, err error) {
+	defer func() {
+		if err != nil {
+			ercs.Close()
+			ercs = nil
+		}
+	}()
+
+	var deadline time.Time
+	if ercr.limits.timeout > 0 {
+		deadline = time.Now().Add(ercr.limits.timeout)
+	}
+	for msg := newHTTPRecordedMessage(record); msg != nil; {
+		erc := ercr.newEnrichment(msg)
+		erc.deadline = deadline
+		ercs = append(ercs, erc)
+
+		if err = erc.processRequest(); err != nil {
+			return ercs, fmt.Errorf("error parsing request of exchange %d: %w", msg.sequence, err)
+		}
 
-	if err = erc.processRequest(); err != nil {
-		return nil, fmt.Errorf("error parsing request: %w", err)
+		if err = erc.processResponse(); err != nil {
+			return ercs, fmt.Errorf("error parsing response of exchange %d: %w", msg.sequence, err)
+		}
+
+		if msg, err = msg.Next(); err != nil {
+			return ercs, fmt.Errorf("error reading exchange %d: %w", erc.msg.sequence+1, err)
+		}
 	}
 
-	if err = erc.processResponse(); err != nil {
-		return nil, fmt.Errorf("error parsing response: %w", err)
+	for _, erc := range ercs {
+		erc.exchanges = len(ercs)
 	}
 	return
 }
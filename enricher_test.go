@@ -18,12 +18,17 @@ func TestEnricher(t *testing.T) {
 		{file: "testdata/record2.txt"},
 		{file: "testdata/record3.txt"},
 		{file: "testdata/record4.txt"},
+		{file: "testdata/record5.txt"},
+		{file: "testdata/record6.txt"},
 	}
 
 	for _, tt := range table {
 		sc, err := newEnricher(
 			enricherWithCRS("crs/coraza.conf", "crs/crs-setup.conf", "crs/rules/*.conf"),
 			enricherWithOptionalGeoIP("testdata/GeoLite2-City-Test.mmdb"),
+			enricherWithSecrets("", ""),
+			enricherWithSignatures("signatures"),
+			enricherWithOpenAPI("testdata/openapi"),
 		)
 		require.Nil(t, err, "unexpected error in instantiating scorer")
 
@@ -1,13 +1,13 @@
 package main
 
 import (
-	"compress/flate"
-	"compress/gzip"
 	"fmt"
 	"io"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/andybalholm/brotli"
 	"github.com/telkomindonesia/httpmsg-enricher/ecs"
 	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
 	"go.uber.org/multierr"
@@ -16,27 +16,64 @@ import (
 type enrichment struct {
 	ercr *enricher
 
-	msg     *httpRecordedMessage
-	reqBody *truncatedBuffer
-	resBody *truncatedBuffer
+	msg       *httpRecordedMessage
+	exchanges int
+	deadline  time.Time
+	reqBody   *captureBuffer
+	resBody   *captureBuffer
+
+	reqEncoding *ecsx.ContentEncoding
+	resEncoding *ecsx.ContentEncoding
+	reqLimit    *ecsx.BodyLimit
+	resLimit    *ecsx.BodyLimit
 
 	secs []subEnricher
 }
 
-func decode(r io.Reader, encoding string) (rc io.ReadCloser, decoded bool, err error) {
-	decoded = true
-	switch encoding {
-	case "gzip":
-		rc, err = gzip.NewReader(r)
-		return
-	case "deflate":
-		rc = flate.NewReader(r)
+// enrichments holds the enrichment of each exchange of a record, in the order they were recorded.
+type enrichments []*enrichment
+
+func (ercs enrichments) toECS() (docs []*ecsx.Document, err error) {
+	for _, erc := range ercs {
+		doc, err := erc.toECS()
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return
+}
+
+func (ercs enrichments) Close() (err error) {
+	for _, erc := range ercs {
+		if errt := erc.Close(); errt != nil {
+			err = multierr.Append(err, errt)
+		}
+	}
+	return
+}
+
+// decodeBody decodes the body of a message, updating its Content-Encoding header to the content codings that remain.
+func (etx *enrichment) decodeBody(r io.Reader, h http.Header) (body *decodedBody, err error) {
+	body, remaining, err := decode(r, contentEncodings(h), etx.ercr.limits.maxRatio)
+	if err != nil {
 		return
-	case "br":
-		rc = io.NopCloser(brotli.NewReader(r))
+	}
+	body.deadline = etx.deadline
+	if body.encoding == nil {
 		return
 	}
-	return io.NopCloser(r), false, nil
+	if len(remaining) == 0 {
+		h.Del("Content-Encoding")
+	} else {
+		h.Set("Content-Encoding", strings.Join(remaining, ", "))
+	}
+	return
+}
+
+// expired tells whether the deadline of the record passed, in which case the sub-enrichers no longer process its messages.
+func (etx *enrichment) expired() bool {
+	return !etx.deadline.IsZero() && time.Now().After(etx.deadline)
 }
 
 func (etx *enrichment) processRequest() (err error) {
@@ -46,24 +83,28 @@ func (etx *enrichment) processRequest() (err error) {
 	}
 	defer req.Body.Close()
 
-	body, decoded, err := decode(req.Body, req.Header.Get("Content-Encoding"))
+	body, err := etx.decodeBody(req.Body, req.Header)
 	if err != nil {
 		return
 	}
 	defer body.Close()
-	if decoded {
-		req.Header.Del("Content-Encoding")
-	}
+	etx.reqEncoding = body.encoding
+	limited := newLimitedBody(body, etx.ercr.limits.maxSize, etx.deadline)
 
-	etx.reqBody = newTruncatedBuffer(8 * 1024)
+	etx.reqBody = newCaptureBuffer(etx.ercr.capture.policyOf("request", req.Header))
 	w := []io.WriteCloser{etx.reqBody}
 	for _, sec := range etx.secs {
-		w = append(w, sec.requestBodyWriter())
+		w = append(w, sec.requestBodyWriter(req))
 	}
 
-	if err := MultiCopy(body, w...); err != nil {
+	if err := MultiCopy(limited, w...); err != nil {
 		return err
 	}
+	etx.reqLimit = limited.limit(body.encoding)
+	if etx.expired() {
+		etx.reqLimit = &ecsx.BodyLimit{Reason: "timeout", ProcessedBytes: limited.n}
+		return
+	}
 	for _, sec := range etx.secs {
 		if errt := sec.processRequest(req); err != nil {
 			err = multierr.Append(err, errt)
@@ -80,24 +121,28 @@ func (etx *enrichment) processResponse() (err error) {
 	}
 	defer res.Body.Close()
 
-	body, decoded, err := decode(res.Body, res.Header.Get("Content-Encoding"))
+	body, err := etx.decodeBody(res.Body, res.Header)
 	if err != nil {
 		return
 	}
 	defer body.Close()
-	if decoded {
-		res.Header.Del("Content-Encoding")
-	}
+	etx.resEncoding = body.encoding
+	limited := newLimitedBody(body, etx.ercr.limits.maxSize, etx.deadline)
 
-	etx.resBody = newTruncatedBuffer(8 * 1024)
+	etx.resBody = newCaptureBuffer(etx.ercr.capture.policyOf("response", res.Header))
 	w := []io.WriteCloser{etx.resBody}
 	for _, sec := range etx.secs {
-		w = append(w, sec.responseBodyWriter())
+		w = append(w, sec.responseBodyWriter(res))
 	}
 
-	if err := MultiCopy(body, w...); err != nil {
+	if err := MultiCopy(limited, w...); err != nil {
 		return err
 	}
+	etx.resLimit = limited.limit(body.encoding)
+	if etx.expired() {
+		etx.resLimit = &ecsx.BodyLimit{Reason: "timeout", ProcessedBytes: limited.n}
+		return
+	}
 	for _, sec := range etx.secs {
 		if errt := sec.processResponse(res); err != nil {
 			err = multierr.Append(err, errt)
@@ -107,6 +152,42 @@ func (etx *enrichment) processResponse() (err error) {
 	return
 }
 
+// latencyOf returns the total duration of the exchange, and when the proxy window is recorded, the upstream latency and the overhead added by the proxy.
+func latencyOf(d *httpRecordedMessageContextDurations) *ecsx.Latency {
+	if d.Total.End == nil || d.Total.End.Before(d.Total.Start) {
+		return nil
+	}
+
+	l := &ecsx.Latency{Total: d.Total.End.Sub(d.Total.Start)}
+	if p := d.Proxy; p != nil && p.End != nil && !p.End.Before(p.Start) {
+		upstream := p.End.Sub(p.Start)
+		overhead := l.Total - upstream
+		if overhead < 0 {
+			overhead = 0
+		}
+		l.Upstream, l.Overhead = &upstream, &overhead
+	}
+	return l
+}
+
+func eventOutcome(status int) string {
+	switch {
+	case status >= 100 && status < 400:
+		return "success"
+	case status >= 400 && status < 600:
+		return "failure"
+	}
+	return "unknown"
+}
+
+// httpVersion returns the version as written by ECS, i.e. without minor version since HTTP/2.
+func httpVersion(req *http.Request) string {
+	if req.ProtoMajor >= 2 {
+		return strconv.Itoa(req.ProtoMajor)
+	}
+	return fmt.Sprintf("%d.%d", req.ProtoMajor, req.ProtoMinor)
+}
+
 func (etx *enrichment) toECS() (doc *ecsx.Document, err error) {
 	req, res := etx.msg.req, etx.msg.res
 	if req == nil || res == nil {
@@ -116,6 +197,14 @@ func (etx *enrichment) toECS() (doc *ecsx.Document, err error) {
 	if err != nil {
 		return nil, fmt.Errorf("error geting context: %w", err)
 	}
+	if ctx != nil && ctx.Truncated != nil {
+		if ctx.Truncated.Request && etx.reqLimit == nil {
+			etx.reqLimit = &ecsx.BodyLimit{Reason: "recording", ProcessedBytes: etx.reqBody.Len()}
+		}
+		if ctx.Truncated.Response && etx.resLimit == nil {
+			etx.resLimit = &ecsx.BodyLimit{Reason: "recording", ProcessedBytes: etx.resBody.Len()}
+		}
+	}
 
 	doc = &ecsx.Document{
 		Document: ecs.Document{
@@ -146,7 +235,7 @@ func (etx *enrichment) toECS() (doc *ecsx.Document, err error) {
 
 		HTTP: &ecsx.HTTP{
 			HTTP: ecs.HTTP{
-				Version: fmt.Sprintf("%d.%d", req.ProtoMajor, req.ProtoMinor),
+				Version: httpVersion(req),
 			},
 			Request: &ecsx.HTTPRequest{
 				HTTPRequest: ecs.HTTPRequest{
@@ -154,24 +243,30 @@ func (etx *enrichment) toECS() (doc *ecsx.Document, err error) {
 					Referrer: req.Referer(),
 					HTTPMessage: ecs.HTTPMessage{
 						Body: &ecs.HTTPMessageBody{
-							Bytes:   int64(etx.reqBody.Len()),
+							Bytes:   etx.reqBody.Len(),
 							Content: etx.reqBody.String(),
 						},
 					},
 				},
-				Headers: MapStringsKeyToLower(req.Header),
+				Headers:  MapStringsKeyToLower(req.Header),
+				Encoding: etx.reqEncoding,
+				Limited:  etx.reqLimit,
+				Capture:  etx.reqBody.capture(),
 			},
 			Response: &ecsx.HTTPResponse{
 				HTTPResponse: ecs.HTTPResponse{
 					StatusCode: res.StatusCode,
 					HTTPMessage: ecs.HTTPMessage{
 						Body: &ecs.HTTPMessageBody{
-							Bytes:   int64(etx.resBody.Len()),
+							Bytes:   etx.resBody.Len(),
 							Content: etx.resBody.String(),
 						},
 					},
 				},
-				Headers: MapStringsKeyToLower(res.Header),
+				Headers:  MapStringsKeyToLower(res.Header),
+				Encoding: etx.resEncoding,
+				Limited:  etx.resLimit,
+				Capture:  etx.resBody.capture(),
 			},
 		},
 	}
@@ -181,21 +276,38 @@ func (etx *enrichment) toECS() (doc *ecsx.Document, err error) {
 		id := req.Header.Get("X-Request-Id")
 		if ctx != nil {
 			id = ctx.ID
+			if etx.exchanges > 1 {
+				id = fmt.Sprintf("%s-%d", ctx.ID, etx.msg.sequence)
+			}
+			doc.Connection = &ecsx.Connection{ID: ctx.ID, Exchanges: etx.exchanges}
 		}
 		doc.HTTP.Request.ID = id
 		doc.Event.Id = id
+		doc.Event.Sequence = etx.msg.sequence
 
-		if ctx != nil && ctx.Durations != nil {
+		// durations are recorded per connection, hence only describe the exchange when it is the only one
+		switch {
+		case ctx != nil && ctx.Durations != nil && etx.exchanges > 1:
 			doc.Document.Timestamp = ctx.Durations.Total.Start
 			doc.Event.Created = &ctx.Durations.Total.Start
+			doc.Connection.Start, doc.Connection.End = &ctx.Durations.Total.Start, ctx.Durations.Total.End
+		case ctx != nil && ctx.Durations != nil:
+			doc.Document.Timestamp = ctx.Durations.Total.Start
+			doc.Event.Created = &ctx.Durations.Total.Start
+			doc.Event.Start = &ctx.Durations.Total.Start
 			doc.Event.End = ctx.Durations.Total.End
-		} else {
+			if doc.Latency = latencyOf(ctx.Durations); doc.Latency != nil {
+				doc.Event.Duration = &doc.Latency.Total
+			}
+		default:
 			now := time.Now()
 			doc.Document.Timestamp = now
 			doc.Event.Created = &now
 			doc.Event.End = &now
 		}
 
+		doc.Event.Outcome = eventOutcome(res.StatusCode)
+
 		if ctx != nil && ctx.User != nil {
 			doc.User = &ecs.User{
 				Name: ctx.User.Username,
@@ -209,6 +321,16 @@ func (etx *enrichment) toECS() (doc *ecsx.Document, err error) {
 		}
 	}
 
+	if etx.reqLimit != nil || etx.resLimit != nil {
+		doc.Tags = append(doc.Tags, "truncated")
+	}
+
+	doc.Related = relatedOf(doc)
+
+	if etx.ercr.redactor != nil {
+		etx.ercr.redactor.redact(doc)
+	}
+
 	return
 }
 
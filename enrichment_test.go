@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnrichmentTiming(t *testing.T) {
+	ercr, err := newEnricher()
+	require.NoError(t, err)
+
+	f, err := os.ReadFile("testdata/record1.txt")
+	require.NoError(t, err, "unexpected error in reading test data")
+	erc, err := ercr.EnrichRecord(bytes.NewReader(f))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+	require.Len(t, docs, 1, "should produce a document for the only exchange")
+	doc := docs[0]
+	require.NotNil(t, doc.Event.Duration)
+	assert.Equal(t, 29*time.Millisecond, *doc.Event.Duration)
+	require.NotNil(t, doc.Latency)
+	assert.Equal(t, 15*time.Millisecond, *doc.Latency.Upstream)
+	assert.Equal(t, 14*time.Millisecond, *doc.Latency.Overhead)
+	assert.Equal(t, "success", doc.Event.Outcome)
+}
+
+func TestEventOutcome(t *testing.T) {
+	assert.Equal(t, "success", eventOutcome(204))
+	assert.Equal(t, "success", eventOutcome(302))
+	assert.Equal(t, "failure", eventOutcome(404))
+	assert.Equal(t, "failure", eventOutcome(503))
+	assert.Equal(t, "unknown", eventOutcome(0))
+}
+
+func TestEnrichmentExchanges(t *testing.T) {
+	ercr, err := newEnricher()
+	require.NoError(t, err)
+
+	f, err := os.ReadFile("testdata/record5.txt")
+	require.NoError(t, err, "unexpected error in reading test data")
+	erc, err := ercr.EnrichRecord(bytes.NewReader(f))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+	require.Len(t, docs, 3, "should produce a document for each exchange")
+	for i, doc := range docs {
+		assert.Equal(t, i+1, doc.Event.Sequence)
+		assert.Equal(t, fmt.Sprintf("01GQ9KEEPALIVE0000000000000--e0cfe4b5-%d", i+1), doc.Event.Id)
+		require.NotNil(t, doc.Connection)
+		assert.Equal(t, "01GQ9KEEPALIVE0000000000000--e0cfe4b5", doc.Connection.ID)
+		assert.Equal(t, 3, doc.Connection.Exchanges)
+		assert.Nil(t, doc.Event.Duration, "connection duration does not describe a single exchange")
+		assert.Nil(t, doc.Event.Start, "connection start does not describe a single exchange")
+		assert.Nil(t, doc.Event.End, "connection end does not describe a single exchange")
+		assert.Nil(t, doc.Latency)
+		require.NotNil(t, doc.Connection.Start, "should report connection times under the connection")
+		assert.Equal(t, doc.Document.Timestamp, *doc.Connection.Start)
+		assert.NotNil(t, doc.Connection.End)
+	}
+	assert.Equal(t, "/users/1", docs[0].URL.Path)
+	assert.Equal(t, "https://api.example.com/users", docs[1].URL.Full)
+	assert.Equal(t, "failure", docs[2].Event.Outcome)
+}
+
+func TestEnrichmentPseudoHeaders(t *testing.T) {
+	ercr, err := newEnricher(enricherWithCRS("crs/coraza.conf", "crs/crs-setup.conf", "crs/rules/*.conf"))
+	require.NoError(t, err)
+
+	f, err := os.ReadFile("testdata/record6.txt")
+	require.NoError(t, err, "unexpected error in reading test data")
+	h3 := "EOF-----------------h3\r\n\r\n" +
+		"HTTP/3\r\n:method: POST\r\n:scheme: https\r\n:authority: api.example.com\r\n:path: /users\r\nuser-agent: curl/8.0.1\r\naccept: */*\r\ncontent-type: application/json\r\n\r\n" +
+		"{\"name\":\"bob\"}\r\nEOF-----------------h3\r\n\r\n" +
+		"HTTP/3\r\n:status: 201\r\n\r\n\r\nEOF-----------------h3\r\n\r\n" +
+		"{\"id\":\"h3\"}\r\n"
+
+	for version, record := range map[string][]byte{"2": f, "3": []byte(h3)} {
+		erc, err := ercr.EnrichRecord(bytes.NewReader(record))
+		require.NoError(t, err, "should not return error")
+		defer erc.Close()
+
+		docs, err := erc.toECS()
+		require.NoError(t, err, "should not return error")
+		doc := docs[0]
+		assert.Equal(t, version, doc.HTTP.Version)
+		assert.Equal(t, "api.example.com", doc.URL.Domain)
+		assert.Equal(t, "https", doc.URL.Scheme)
+		for _, e := range doc.Threat.Enrichments {
+			assert.NotContains(t, e.Indicator.Description, `id "920`, "should not violate protocol enforcement")
+		}
+	}
+}
+
+func TestEnrichmentRedactedThreats(t *testing.T) {
+	ercr, err := newEnricher(
+		enricherWithCRS("crs/coraza.conf", "crs/crs-setup.conf", "crs/rules/*.conf"),
+		enricherWithRedaction("mask", nil, []string{"password"}, nil),
+	)
+	require.NoError(t, err)
+
+	body := "user=bob&password=s3cr3t' OR '1'='1' --"
+	record := "EOF-----------------redact\r\n\r\n" +
+		fmt.Sprintf("POST /login HTTP/1.1\r\nHost: api.example.com\r\nUser-Agent: curl/8.0.1\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: %d\r\n\r\n", len(body)) +
+		body + "\r\nEOF-----------------redact\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok\r\nEOF-----------------redact\r\n\r\n" +
+		"{\"id\":\"redact\"}\r\n"
+	erc, err := ercr.EnrichRecord(bytes.NewReader([]byte(record)))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+	require.NotEmpty(t, docs[0].Threat.Enrichments, "should detect the injection")
+	fields := 0
+	for _, e := range docs[0].Threat.Enrichments {
+		assert.NotContains(t, e.Indicator.Description, "s3cr3t")
+		assert.NotContains(t, e.Match.Atomic, "s3cr3t")
+		if e.Match.Field == "ARGS:password" || e.Match.Field == "ARGS_POST:password" {
+			fields++
+		}
+	}
+	assert.NotZero(t, fields, "should name the matched field")
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func harHeader(nvs []harNameValue) http.Header {
+	h := http.Header{}
+	for _, nv := range nvs {
+		h.Add(nv.Name, nv.Value)
+	}
+	return h
+}
+
+type harEntry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"`
+	ServerIPAddress string    `json:"serverIPAddress"`
+
+	Request struct {
+		Method      string         `json:"method"`
+		URL         string         `json:"url"`
+		HTTPVersion string         `json:"httpVersion"`
+		Headers     []harNameValue `json:"headers"`
+		PostData    *struct {
+			MimeType string         `json:"mimeType"`
+			Text     string         `json:"text"`
+			Params   []harNameValue `json:"params"`
+		} `json:"postData"`
+	} `json:"request"`
+
+	Response struct {
+		Status      int            `json:"status"`
+		StatusText  string         `json:"statusText"`
+		HTTPVersion string         `json:"httpVersion"`
+		Headers     []harNameValue `json:"headers"`
+		Content     struct {
+			MimeType string `json:"mimeType"`
+			Text     string `json:"text"`
+			Encoding string `json:"encoding"`
+		} `json:"content"`
+	} `json:"response"`
+
+	Timings struct {
+		Blocked float64 `json:"blocked"`
+		DNS     float64 `json:"dns"`
+		Connect float64 `json:"connect"`
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+	} `json:"timings"`
+}
+
+// harMillis converts HAR timing, where -1 means not applicable.
+func harMillis(ms ...float64) (d time.Duration) {
+	for _, m := range ms {
+		if m > 0 {
+			d += time.Duration(m * float64(time.Millisecond))
+		}
+	}
+	return
+}
+
+func (e *harEntry) requestBody() []byte {
+	pd := e.Request.PostData
+	if pd == nil {
+		return nil
+	}
+	if pd.Text != "" || len(pd.Params) == 0 {
+		return []byte(pd.Text)
+	}
+
+	// keep the order of the params, unlike url.Values
+	pairs := make([]string, 0, len(pd.Params))
+	for _, p := range pd.Params {
+		pairs = append(pairs, url.QueryEscape(p.Name)+"="+url.QueryEscape(p.Value))
+	}
+	return []byte(strings.Join(pairs, "&"))
+}
+
+// record converts the entry into the recorded format.
+// The total duration is the entry time, while the proxy window covers sending the request until receiving the response, excluding connection setup.
+func (e *harEntry) record(id string) (r io.Reader, err error) {
+	rb := newRecordBuilder()
+
+	reqHeader := harHeader(e.Request.Headers)
+	if e.Request.PostData != nil && e.Request.PostData.MimeType != "" && reqHeader.Get("Content-Type") == "" {
+		reqHeader.Set("Content-Type", e.Request.PostData.MimeType)
+	}
+	if err = rb.request(e.Request.Method, e.Request.URL, e.Request.HTTPVersion, reqHeader, e.requestBody()); err != nil {
+		return
+	}
+
+	body := []byte(e.Response.Content.Text)
+	if e.Response.Content.Encoding == "base64" {
+		if body, err = base64.StdEncoding.DecodeString(e.Response.Content.Text); err != nil {
+			return nil, fmt.Errorf("invalid base64 content: %w", err)
+		}
+	}
+	resHeader := harHeader(e.Response.Headers)
+	resHeader.Del("Content-Encoding") // content is recorded decoded
+	if e.Response.Content.MimeType != "" && resHeader.Get("Content-Type") == "" {
+		resHeader.Set("Content-Type", e.Response.Content.MimeType)
+	}
+	rb.response(e.Response.HTTPVersion, e.Response.Status, e.Response.StatusText, resHeader, body)
+
+	ctx := &httpRecordedMessageContext{ID: id}
+	if u, err := url.Parse(e.Request.URL); err == nil {
+		ctx.Connection = &httpRecordedMessageContextConnection{Protocol: u.Scheme}
+		ctx.Host = &httpRecordedMessageContextHost{Name: u.Host}
+		ctx.Host.Target = recordTarget(u, e.ServerIPAddress)
+	}
+	if !e.StartedDateTime.IsZero() {
+		end := e.StartedDateTime.Add(harMillis(e.Time))
+		ctx.Durations = &httpRecordedMessageContextDurations{
+			Total: httpRecordedMessageContextDuration{Start: e.StartedDateTime, End: &end},
+		}
+		if e.Timings.Wait >= 0 {
+			start := e.StartedDateTime.Add(harMillis(e.Timings.Blocked, e.Timings.DNS, e.Timings.Connect))
+			end := start.Add(harMillis(e.Timings.Send, e.Timings.Wait, e.Timings.Receive))
+			ctx.Durations.Proxy = &httpRecordedMessageContextDuration{Start: start, End: &end}
+		}
+	}
+	return rb.build(ctx)
+}
+
+// harEntries iterates over the entries of a HAR file without decoding the whole file at once.
+type harEntries struct {
+	name  string
+	dec   *json.Decoder
+	index int
+}
+
+func newHAREntries(name string, r io.Reader) (he *harEntries, err error) {
+	he = &harEntries{name: name, dec: json.NewDecoder(r)}
+	if err = he.seek("log", "entries"); err != nil {
+		return nil, fmt.Errorf("invalid har: %w", err)
+	}
+	if t, err := he.dec.Token(); err != nil || t != json.Delim('[') {
+		return nil, fmt.Errorf("invalid har: entries is not an array")
+	}
+	return
+}
+
+// seek moves the decoder into the value of the given path of object keys.
+func (he *harEntries) seek(path ...string) error {
+	for _, key := range path {
+		if t, err := he.dec.Token(); err != nil || t != json.Delim('{') {
+			return fmt.Errorf("expecting object containing %s", key)
+		}
+		for {
+			t, err := he.dec.Token()
+			if err != nil {
+				return err
+			}
+			if t == json.Delim('}') {
+				return fmt.Errorf("%s not found", key)
+			}
+			if t == key {
+				break
+			}
+			if err := he.dec.Decode(&json.RawMessage{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// next returns the record of the next entry, or io.EOF when there is none.
+func (he *harEntries) next() (name string, r io.Reader, err error) {
+	if !he.dec.More() {
+		return "", nil, io.EOF
+	}
+
+	he.index++
+	name = fmt.Sprintf("%s#%d", he.name, he.index)
+	e := harEntry{}
+	if err = he.dec.Decode(&e); err != nil {
+		return "", nil, fmt.Errorf("error decoding %s: %w", name, err)
+	}
+	if r, err = e.record(name); err != nil {
+		return "", nil, &recordEntryError{entry: name, err: fmt.Errorf("error converting har entry: %w", err)}
+	}
+	return
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHAREntries(t *testing.T) {
+	ercr, err := newEnricher()
+	require.NoError(t, err)
+
+	f, err := os.Open("testdata/sample.har")
+	require.NoError(t, err, "unexpected error in reading test data")
+	defer f.Close()
+
+	he, err := newHAREntries("sample.har", f)
+	require.NoError(t, err, "should accept har")
+
+	name, r, err := he.next()
+	require.NoError(t, err, "should convert the first entry")
+	assert.Equal(t, "sample.har#1", name)
+
+	erc, err := ercr.EnrichRecord(r)
+	require.NoError(t, err, "should enrich the converted entry")
+	defer erc.Close()
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+	require.Len(t, docs, 1)
+	doc := docs[0]
+
+	assert.Equal(t, "sample.har#1", doc.Event.Id)
+	assert.Equal(t, "https://api.example.com/users/1?verbose=true", doc.URL.Full)
+	assert.Equal(t, "2", doc.HTTP.Version)
+	assert.Equal(t, 200, doc.HTTP.Response.StatusCode)
+	assert.Equal(t, `{"id":1,"name":"alice"}`, doc.HTTP.Response.Body.Content, "should decode base64 content")
+	assert.Equal(t, "application/json", doc.HTTP.Response.MimeType)
+	assert.Equal(t, "Chrome", doc.UserAgent.Name)
+
+	require.NotNil(t, doc.Event.Duration)
+	assert.Equal(t, 120500*time.Microsecond, *doc.Event.Duration)
+	require.NotNil(t, doc.Latency.Upstream)
+	assert.Equal(t, 120*time.Millisecond, *doc.Latency.Upstream)
+	assert.Equal(t, 500*time.Microsecond, *doc.Latency.Overhead)
+
+	require.NotNil(t, doc.Destination)
+	assert.Equal(t, "93.184.216.34", doc.Destination.Address)
+	assert.Equal(t, uint16(443), doc.Destination.Port)
+
+	name, r, err = he.next()
+	require.NoError(t, err, "should convert the second entry")
+	erc2, err := ercr.EnrichRecord(r)
+	require.NoError(t, err, "should enrich the converted entry")
+	defer erc2.Close()
+	docs, err = erc2.toECS()
+	require.NoError(t, err, "should not return error")
+	doc = docs[0]
+
+	assert.Equal(t, "sample.har#2", name)
+	assert.Equal(t, "POST", doc.HTTP.Request.Method)
+	assert.Equal(t, "username=alice&password=%27+OR+1%3D1+--", doc.HTTP.Request.Body.Content, "should encode params")
+	assert.Equal(t, "failure", doc.Event.Outcome)
+	assert.Equal(t, 30*time.Millisecond, *doc.Latency.Overhead)
+
+	_, _, err = he.next()
+	var entryErr *recordEntryError
+	require.ErrorAs(t, err, &entryErr, "should report invalid entry on its own")
+	assert.Equal(t, "sample.har#3", entryErr.entry)
+
+	_, _, err = he.next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestEnrichHARRecords(t *testing.T) {
+	har := readTestRecords(t, "testdata/sample.har")[0]
+	lines := enrichTestRecords(t, "sample.har", har)
+	assert.Equal(t, []string{"sample.har#1", "sample.har#2", "sample.har#3"}, entriesOf(lines))
+	assert.Empty(t, lines[0].Ingest.Error)
+	assert.Contains(t, lines[2].Ingest.Error, "invalid base64 content")
+
+	_, err := newHAREntries("invalid.har", strings.NewReader(`{"log":{"entries":{}}}`))
+	assert.Error(t, err, "should reject har without entries array")
+}
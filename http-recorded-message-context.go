@@ -13,6 +13,7 @@ type httpRecordedMessageContext struct {
 	Durations  *httpRecordedMessageContextDurations  `json:"durations,omitempty"`
 	User       *httpRecordedMessageContextUser       `json:"user,omitempty"`
 	Host       *httpRecordedMessageContextHost       `json:"host,omitempty"`
+	Truncated  *httpRecordedMessageContextTruncated  `json:"truncated,omitempty"`
 }
 
 type httpRecordedMessageContextConnection struct {
@@ -41,5 +42,12 @@ type httpRecordedMessageContextHost struct {
 }
 
 type httpRecordedMessageContextConnectionClient struct {
-	IP net.IP `json:"ip,omitempty"`
+	IP   net.IP `json:"ip,omitempty"`
+	Port uint16 `json:"port,omitempty"`
+}
+
+// httpRecordedMessageContextTruncated tells which bodies were recorded partially.
+type httpRecordedMessageContextTruncated struct {
+	Request  bool `json:"request,omitempty"`
+	Response bool `json:"response,omitempty"`
 }
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	pseudoHeaderVersionRegexp = regexp.MustCompile(`^HTTP/[23](\.0)?\r\n$`)
+	pseudoHeaderLineRegexp    = regexp.MustCompile(`^:[a-z]+:`)
+)
+
+// isPseudoHeaderBlock tells whether the line starts a message recorded as an HTTP/2 or HTTP/3 header block,
+// i.e. an optional version line followed by pseudo-headers.
+func isPseudoHeaderBlock(line []byte) bool {
+	return pseudoHeaderVersionRegexp.Match(line) || pseudoHeaderLineRegexp.Match(line)
+}
+
+// pseudoHeaderMessage is a message whose request line or status is carried by pseudo-headers (:method, :path, :authority, :scheme, :status).
+// Such message has no framing of its own: its body spans until the delimiter line, followed by its trailers.
+type pseudoHeaderMessage struct {
+	proto   string
+	pseudo  map[string]string
+	header  [][]byte
+	trailer [][]byte
+}
+
+func newPseudoHeaderMessage(versionLine []byte) *pseudoHeaderMessage {
+	m := &pseudoHeaderMessage{proto: "HTTP/2.0", pseudo: map[string]string{}}
+	if pseudoHeaderVersionRegexp.Match(versionLine) {
+		m.proto = normalizeHTTPVersion(string(bytes.TrimSuffix(versionLine, crlf)))
+	}
+	return m
+}
+
+func (m *pseudoHeaderMessage) addHeaderLine(line []byte) error {
+	if !pseudoHeaderLineRegexp.Match(line) {
+		m.header = append(m.header, line)
+		return nil
+	}
+
+	s := strings.TrimSuffix(string(line), "\r\n")
+	if len(m.header) > 0 {
+		return fmt.Errorf("pseudo-header %q follows regular headers", s)
+	}
+	name, value, _ := strings.Cut(s[1:], ":")
+	if _, ok := m.pseudo[name]; ok {
+		return fmt.Errorf("duplicated pseudo-header %q", ":"+name)
+	}
+	m.pseudo[name] = strings.TrimSpace(value)
+	return nil
+}
+
+func (m *pseudoHeaderMessage) isResponse() bool {
+	_, ok := m.pseudo["status"]
+	return ok
+}
+
+// startLine returns the HTTP/1.1 start line of the message. The request target is written in absolute form when :scheme and :authority are known,
+// so that the request URL keeps its scheme.
+func (m *pseudoHeaderMessage) startLine() (string, error) {
+	if m.isResponse() {
+		code, err := strconv.Atoi(m.pseudo["status"])
+		if err != nil || code < 100 || code > 999 {
+			return "", fmt.Errorf("invalid :status pseudo-header %q", m.pseudo["status"])
+		}
+		return strings.TrimSpace(fmt.Sprintf("%s %03d %s", m.proto, code, http.StatusText(code))), nil
+	}
+
+	method, path, scheme, authority := m.pseudo["method"], m.pseudo["path"], m.pseudo["scheme"], m.pseudo["authority"]
+	if method == "" {
+		return "", fmt.Errorf("missing :method or :status pseudo-header")
+	}
+	if method == http.MethodConnect && path == "" {
+		path = authority
+	}
+	if path == "" {
+		return "", fmt.Errorf("missing :path pseudo-header")
+	}
+	if scheme != "" && authority != "" && strings.HasPrefix(path, "/") {
+		path = scheme + "://" + authority + path
+	}
+	return fmt.Sprintf("%s %s %s", method, path, m.proto), nil
+}
+
+func headerLineName(line []byte) string {
+	name, _, _ := strings.Cut(string(line), ":")
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// head returns the start line and header of the message framed as HTTP/1.1: :authority becomes the Host header, and the body is chunked
+// as it is read. The recorded Content-Length is only kept for responses without body by definition.
+func (m *pseudoHeaderMessage) head(chunked, bodiless bool) ([]byte, error) {
+	startLine, err := m.startLine()
+	if err != nil {
+		return nil, err
+	}
+
+	b := bytes.Buffer{}
+	b.WriteString(startLine + "\r\n")
+	var host bool
+	var contentLength []byte
+	for _, l := range m.header {
+		switch headerLineName(l) {
+		case "content-length":
+			contentLength = l
+			continue
+		case "transfer-encoding":
+			continue
+		case "host":
+			host = true
+		}
+		b.Write(l)
+	}
+	if authority := m.pseudo["authority"]; authority != "" && !host {
+		b.WriteString("Host: " + authority + "\r\n")
+	}
+
+	switch {
+	case chunked:
+		b.WriteString("Transfer-Encoding: chunked\r\n")
+	case bodiless && contentLength != nil:
+		b.Write(contentLength)
+	default:
+		b.WriteString("Content-Length: 0\r\n")
+	}
+	b.WriteString("\r\n")
+	return b.Bytes(), nil
+}
+
+// isBodilessStatus tells whether responses of the status have no body by definition.
+func isBodilessStatus(code int) bool {
+	return code/100 == 1 || code == http.StatusNoContent || code == http.StatusNotModified
+}
+
+func (hrm *httpRecordedStream) scan() ([]byte, bool) {
+	if !hrm.scanner.Scan() {
+		return nil, false
+	}
+	return append([]byte(nil), hrm.scanner.Bytes()...), true
+}
+
+// scanPseudoHeaderBlock reads the header block of a message starting at the given line.
+func (hrm *httpRecordedStream) scanPseudoHeaderBlock(line []byte) (m *pseudoHeaderMessage, err error) {
+	m = newPseudoHeaderMessage(line)
+	if pseudoHeaderVersionRegexp.Match(line) {
+		line = nil
+	}
+
+	var ok bool
+	for ; ; line = nil {
+		if line == nil {
+			if line, ok = hrm.scan(); !ok {
+				return nil, fmt.Errorf("error reading header block: %w", io.ErrUnexpectedEOF)
+			}
+		}
+		if isCRLF(line) {
+			break
+		}
+		if err = m.addHeaderLine(line); err != nil {
+			return nil, err
+		}
+	}
+	_, err = m.startLine()
+	return m, err
+}
+
+// feedPseudoHeaderBody streams the body of the message, which spans until eofLine, as chunks following the message head,
+// then its trailers. The head is only written once the body is known to be non-empty, so that empty bodies keep a Content-Length.
+func (hrm *httpRecordedStream) feedPseudoHeaderBody(m *pseudoHeaderMessage, bodiless bool, eofLine []byte) error {
+	var headed bool
+	writeHead := func(chunked bool) error {
+		b, err := m.head(chunked, bodiless)
+		if err != nil {
+			return err
+		}
+		headed = true
+		_, err = hrm.scannerWritter.Write(b)
+		return err
+	}
+
+	var body []byte
+	for {
+		data, ok := hrm.scan()
+		if !ok {
+			return fmt.Errorf("error reading body: %w", io.ErrUnexpectedEOF)
+		}
+		if bytes.Equal(data, eofLine) && bytes.HasSuffix(body, crlf) {
+			body = body[:len(body)-2] // remove added '\r\n' before eofLine
+			break
+		}
+		if body != nil {
+			if !headed {
+				if err := writeHead(true); err != nil {
+					return err
+				}
+			}
+			if _, err := hrm.feedBody(body, true); err != nil {
+				return err
+			}
+		}
+		body = data
+	}
+	if len(body) > 0 {
+		if !headed {
+			if err := writeHead(true); err != nil {
+				return err
+			}
+		}
+		if _, err := hrm.feedBody(body, true); err != nil {
+			return err
+		}
+	}
+
+	for {
+		data, ok := hrm.scan()
+		if !ok || isCRLF(data) {
+			break
+		}
+		m.trailer = append(m.trailer, data)
+	}
+	if !headed {
+		if len(m.trailer) == 0 {
+			return writeHead(false)
+		}
+		if err := writeHead(true); err != nil {
+			return err
+		}
+	}
+
+	hrm.scannerWritter.Write([]byte("0\r\n"))
+	for _, l := range m.trailer {
+		hrm.scannerWritter.Write(l)
+	}
+	_, err := hrm.scannerWritter.Write(crlf)
+	return err
+}
+
+// feedPseudoHeaderMessages converts the messages recorded as header blocks starting at the given line into HTTP/1.1 messages.
+// It returns the first line following them, and whether there was any.
+func (hrm *httpRecordedStream) feedPseudoHeaderMessages(line, eofLine []byte) (next []byte, fed bool, err error) {
+	for isPseudoHeaderBlock(line) {
+		m, err := hrm.scanPseudoHeaderBlock(line)
+		if err != nil {
+			return nil, fed, err
+		}
+		bodiless := m.isResponse() && hrm.headRequest
+		if code, err := strconv.Atoi(m.pseudo["status"]); err == nil && isBodilessStatus(code) {
+			bodiless = true
+		}
+		if !m.isResponse() {
+			hrm.headRequest = m.pseudo["method"] == http.MethodHead
+		}
+
+		if err = hrm.feedPseudoHeaderBody(m, bodiless, eofLine); err != nil {
+			return nil, fed, err
+		}
+		line, fed = hrm.discardEmpty(), true
+	}
+	return line, fed, nil
+}
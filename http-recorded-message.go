@@ -7,6 +7,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httputil"
+	"net/textproto"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -40,14 +43,26 @@ func splitCRLF(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	return 0, nil, nil
 }
 
-type httpRecordedMessage struct {
+var httpStartLineRegexp = regexp.MustCompile(`^(HTTP/\d(\.\d)? \d{3}|[!#$%&'*+.^_|~0-9A-Za-z-]+ \S+ HTTP/\d(\.\d)?\r\n)`)
+
+// httpRecordedStream normalizes a record into a stream of HTTP messages followed by the context.
+// A record may contain several request/response exchanges recorded from a single keep-alive connection.
+type httpRecordedStream struct {
 	scanner        bufio.Scanner
 	scannerWritter *io.PipeWriter
 	record         *bufio.Reader
+	headRequest    bool // whether the last request recorded as a header block is a HEAD one
+
+	ctx *httpRecordedMessageContext
+}
+
+// httpRecordedMessage is a single request/response exchange of a record. Exchanges of the same record share their stream.
+type httpRecordedMessage struct {
+	*httpRecordedStream
+	sequence int
 
 	req *http.Request
 	res *http.Response
-	ctx *httpRecordedMessageContext
 
 	closed bool
 }
@@ -55,31 +70,39 @@ type httpRecordedMessage struct {
 func newHTTPRecordedMessage(r io.Reader) *httpRecordedMessage {
 	sc := *bufio.NewScanner(r)
 	r, w := io.Pipe()
-	hrm := &httpRecordedMessage{
+	hrs := &httpRecordedStream{
 		scanner:        sc,
 		scannerWritter: w,
 		record:         bufio.NewReader(r),
 	}
-	go hrm.feed()
+	go hrs.feed()
 
-	return hrm
+	return &httpRecordedMessage{httpRecordedStream: hrs, sequence: 1}
 }
 
-func (hrm *httpRecordedMessage) feed() {
+func (hrm *httpRecordedStream) feed() {
 	defer hrm.scannerWritter.Close()
 
 	var body, eofLine []byte
-	var bodyWritten, httpMsgEncountered int
-	var bodyReading, trailerReading, bodyChunked bool
+	var bodyWritten int
+	var err error
+	var bodyReading, trailerReading, bodyChunked, passthrough bool
 	hrm.scanner.Split(splitCRLF)
 	for hrm.scanner.Scan() {
 		data := append([]byte(nil), hrm.scanner.Bytes()...)
 		if eofLine == nil {
 			eofLine = data // the first line is always the eofline
 			data = hrm.discardEmpty()
+
+			var fed bool
+			if data, fed, err = hrm.feedPseudoHeaderMessages(data, eofLine); err != nil {
+				hrm.scannerWritter.CloseWithError(err)
+				return
+			}
+			passthrough = fed && !httpStartLineRegexp.Match(data)
 		}
 
-		if httpMsgEncountered == 2 { // expect only 2 HTTP message, request and response. Then just copy as-is.
+		if passthrough { // no more HTTP message, the rest is the context. Then just copy as-is.
 			hrm.scannerWritter.Write(data)
 			continue
 		}
@@ -94,8 +117,13 @@ func (hrm *httpRecordedMessage) feed() {
 				hrm.scannerWritter.Write(crlf)
 			}
 			body, bodyReading, bodyChunked, bodyWritten, trailerReading = nil, false, false, 0, false
-			httpMsgEncountered++
-			hrm.scannerWritter.Write(hrm.discardEmpty())
+			next, _, err := hrm.feedPseudoHeaderMessages(hrm.discardEmpty(), eofLine)
+			if err != nil {
+				hrm.scannerWritter.CloseWithError(err)
+				return
+			}
+			passthrough = !httpStartLineRegexp.Match(next)
+			hrm.scannerWritter.Write(next)
 			continue
 		}
 
@@ -131,7 +159,7 @@ func (hrm *httpRecordedMessage) feed() {
 	}
 }
 
-func (hrm *httpRecordedMessage) discardEmpty() []byte {
+func (hrm *httpRecordedStream) discardEmpty() []byte {
 	for hrm.scanner.Scan() {
 		data := hrm.scanner.Bytes()
 		if isCRLF(data) {
@@ -142,7 +170,7 @@ func (hrm *httpRecordedMessage) discardEmpty() []byte {
 	return nil
 }
 
-func (hrm *httpRecordedMessage) feedBody(body []byte, chunked bool) (n int, err error) {
+func (hrm *httpRecordedStream) feedBody(body []byte, chunked bool) (n int, err error) {
 	if body == nil || len(body) == 0 {
 		return
 	}
@@ -199,9 +227,75 @@ func (hrm *httpRecordedMessage) Response() (_ *http.Response, err error) {
 	}
 
 	hrm.res, err = http.ReadResponse(hrm.record, hrm.req)
+	if err == nil && hrm.res.StatusCode == http.StatusSwitchingProtocols {
+		hrm.res.Body = newSwitchedProtocolBody(hrm.record, hrm.res)
+	}
 	return hrm.res, err
 }
 
+// switchedProtocolBody is the data recorded after a protocol switch, e.g. WebSocket frames, which net/http leaves unread
+// since such response has no body by definition. It is framed as any other recorded body, and may thus be followed by trailers.
+type switchedProtocolBody struct {
+	io.Reader
+	record       *bufio.Reader
+	res          *http.Response
+	chunked, eof bool
+}
+
+func newSwitchedProtocolBody(record *bufio.Reader, res *http.Response) io.ReadCloser {
+	if isChunked(res.TransferEncoding, nil) {
+		return &switchedProtocolBody{Reader: httputil.NewChunkedReader(record), record: record, res: res, chunked: true}
+	}
+	cl, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+	if err != nil || cl <= 0 {
+		return http.NoBody
+	}
+	res.ContentLength = cl
+	return &switchedProtocolBody{Reader: io.LimitReader(record, cl), record: record, res: res}
+}
+
+func (b *switchedProtocolBody) Read(p []byte) (n int, err error) {
+	if b.eof {
+		return 0, io.EOF
+	}
+	n, err = b.Reader.Read(p)
+	if err == io.EOF && b.chunked {
+		h, errt := textproto.NewReader(b.record).ReadMIMEHeader()
+		if errt != nil && errt != io.EOF {
+			return n, errt
+		}
+		b.res.Trailer = http.Header(h)
+	}
+	b.eof = err == io.EOF
+	return
+}
+
+// Close drains the rest of the body.
+func (b *switchedProtocolBody) Close() (err error) {
+	_, err = io.Copy(io.Discard, b)
+	return
+}
+
+// Next returns the following exchange of the record, or nil when there is none. The rest of the current response body is discarded.
+func (hrm *httpRecordedMessage) Next() (_ *httpRecordedMessage, err error) {
+	if hrm.res == nil {
+		return nil, fmt.Errorf("consume the response first")
+	}
+	if err = hrm.res.Body.Close(); err != nil { // closing drains the rest of the body
+		return nil, err
+	}
+
+	b, err := hrm.record.Peek(1)
+	if err == io.EOF || (err == nil && b[0] == '{') {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &httpRecordedMessage{httpRecordedStream: hrm.httpRecordedStream, sequence: hrm.sequence + 1}, nil
+}
+
+// Context returns the context shared by all exchanges of the record.
 func (hrm *httpRecordedMessage) Context() (ctx *httpRecordedMessageContext, err error) {
 	if hrm.ctx != nil {
 		return hrm.ctx, nil
@@ -217,6 +311,9 @@ func (hrm *httpRecordedMessage) Context() (ctx *httpRecordedMessageContext, err
 
 func (hrm *httpRecordedMessage) Close() (err error) {
 	io.Copy(io.Discard, hrm.record)
+	if hrm.req == nil || hrm.res == nil {
+		return
+	}
 	if errt := hrm.req.Body.Close(); errt != nil {
 		err = multierror.Append(errt)
 	}
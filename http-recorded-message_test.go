@@ -93,3 +93,118 @@ func TestRecordedMessage(t *testing.T) {
 	}
 
 }
+
+func TestRecordedMessageExchanges(t *testing.T) {
+	f, err := os.Open("testdata/record5.txt")
+	require.Nil(t, err, "unexpected error in reading test data")
+	defer f.Close()
+
+	expected := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{method: "GET", path: "/users/1", status: 200, body: "{\"id\":1,\"name\":\"alice\"}\n"},
+		{method: "POST", path: "/users", status: 201, body: "{\"id\":2,\"name\":\"bob\"}"},
+		{method: "DELETE", path: "/users/2", status: 403},
+	}
+
+	h := newHTTPRecordedMessage(f)
+	for i, exp := range expected {
+		require.NotNilf(t, h, "should have exchange %d", i+1)
+		assert.Equal(t, i+1, h.sequence)
+
+		req, err := h.Request()
+		require.Nil(t, err, "should not return error")
+		assert.Equal(t, exp.method, req.Method)
+		assert.Equal(t, exp.path, req.URL.Path)
+		_, err = io.Copy(io.Discard, req.Body)
+		require.Nil(t, err, "req body should be readable")
+
+		res, err := h.Response()
+		require.Nil(t, err, "should not return error")
+		assert.Equal(t, exp.status, res.StatusCode)
+		b, err := ioutil.ReadAll(res.Body)
+		require.Nil(t, err, "res body should be readable")
+		assert.Equal(t, exp.body, string(b))
+
+		last := h
+		h, err = h.Next()
+		require.Nil(t, err, "should not return error")
+		if h == nil {
+			ctx, err := last.Context()
+			require.Nil(t, err, "should not return error")
+			require.NotNil(t, ctx, "should not return nil context")
+			assert.Equal(t, "01GQ9KEEPALIVE0000000000000--e0cfe4b5", ctx.ID)
+		}
+	}
+	assert.Nil(t, h, "should not have more exchange")
+}
+
+func TestRecordedMessagePseudoHeaders(t *testing.T) {
+	f, err := os.Open("testdata/record6.txt")
+	require.Nil(t, err, "unexpected error in reading test data")
+	defer f.Close()
+
+	h := newHTTPRecordedMessage(f)
+	req, err := h.Request()
+	require.Nil(t, err, "should not return error")
+	assert.Equal(t, "GET", req.Method)
+	assert.Equal(t, "HTTP/2.0", req.Proto)
+	assert.Equal(t, "https", req.URL.Scheme, "should map :scheme")
+	assert.Equal(t, "api.example.com", req.Host, "should map :authority")
+	assert.Equal(t, "/users/1", req.URL.Path, "should map :path")
+	assert.Equal(t, "curl/8.0.1", req.UserAgent())
+	_, err = io.Copy(io.Discard, req.Body)
+	require.Nil(t, err, "req body should be readable")
+
+	res, err := h.Response()
+	require.Nil(t, err, "should not return error")
+	assert.Equal(t, 200, res.StatusCode, "should map :status")
+	b, err := ioutil.ReadAll(res.Body)
+	require.Nil(t, err, "res body should be readable")
+	assert.Equal(t, `{"id":1,"name":"alice"}`, string(b), "should frame the body by the delimiter line")
+	assert.Equal(t, "5d41402a", res.Trailer.Get("X-Checksum"), "should keep the trailer")
+
+	h, err = h.Next()
+	require.Nil(t, err, "should not return error")
+	require.NotNil(t, h, "should have a second exchange")
+	req, err = h.Request()
+	require.Nil(t, err, "should not return error")
+	assert.Equal(t, "HEAD", req.Method)
+	res, err = h.Response()
+	require.Nil(t, err, "should not return error")
+	assert.Equal(t, int64(23), res.ContentLength, "should keep the length of a response to HEAD")
+
+	last := h
+	h, err = h.Next()
+	require.Nil(t, err, "should not return error")
+	assert.Nil(t, h, "should not have more exchange")
+	ctx, err := last.Context()
+	require.Nil(t, err, "should not return error")
+	assert.Equal(t, "01GQ9HTTP20000000000000000--e0cfe4b5", ctx.ID)
+}
+
+func TestRecordedMessagePseudoHeadersStreamedBody(t *testing.T) {
+	eof := "EOF-----------------01GQ9HTTP20000000000000000--e0cfe4b5\r\n"
+	body := "line one\r\nline two\r\n\r\nline four"
+	record := eof + "\r\n" +
+		":method: POST\r\n:scheme: https\r\n:authority: api.example.com\r\n:path: /notes\r\ncontent-length: 999\r\n\r\n" +
+		body + "\r\n" + eof + "\r\n" +
+		":status: 204\r\n\r\n\r\n" + eof + "\r\n" +
+		"{\"id\": \"01GQ9HTTP20000000000000000--e0cfe4b5\"}\r\n" + eof
+
+	h := newHTTPRecordedMessage(strings.NewReader(record))
+	req, err := h.Request()
+	require.Nil(t, err, "should not return error")
+	assert.Equal(t, []string{"chunked"}, req.TransferEncoding, "should stream the body as chunks")
+	b, err := ioutil.ReadAll(req.Body)
+	require.Nil(t, err, "req body should be readable")
+	assert.Equal(t, body, string(b), "should keep every line of the body")
+
+	res, err := h.Response()
+	require.Nil(t, err, "should not return error")
+	assert.Equal(t, 204, res.StatusCode)
+	assert.Equal(t, int64(0), res.ContentLength, "should frame an empty body by its length")
+}
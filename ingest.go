@@ -0,0 +1,396 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+	"go.uber.org/multierr"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+	tarMagic  = []byte("ustar")
+
+	recordDelimiterRegexp = regexp.MustCompile(`^EOF-+\S+\r\n$`)
+	utf8BOM               = []byte("\xef\xbb\xbf")
+)
+
+// recordEntryError reports an entry that can not be read, without preventing the following entries from being read.
+type recordEntryError struct {
+	entry string
+	err   error
+}
+
+func (e *recordEntryError) Error() string { return fmt.Sprintf("%s: %v", e.entry, e.err) }
+func (e *recordEntryError) Unwrap() error { return e.err }
+
+const (
+	tarMagicOffset = 257
+
+	defaultZipSpoolLimit = 1 << 30
+)
+
+// ingestLimits bounds what is held while reading records out of a stream.
+type ingestLimits struct {
+	zipSpool   int64 // size up to which zip archives which are not regular files are spooled into a temporary file, zero rejecting them
+	warcRecord int64 // size of WARC record blocks, which are held until paired, zero for none
+}
+
+// concatenatedRecords splits a stream of concatenated records on their delimiter line,
+// i.e. a line other than the delimiter of the current record that looks like one.
+type concatenatedRecords struct {
+	name  string
+	br    *bufio.Reader
+	index int
+
+	delimiter   []byte // delimiter of the current record
+	next        []byte // delimiter of the next record, which has been read while reading the current one
+	pending     []byte
+	atLineStart bool
+	ended       bool
+}
+
+func newConcatenatedRecords(name string, r io.Reader) *concatenatedRecords {
+	return &concatenatedRecords{name: name, br: bufio.NewReaderSize(r, maxHeaderLine)}
+}
+
+// nextRecord returns the next record, whose reader must be consumed before the following call.
+// The first record is named after the stream, while the following ones have their index appended.
+func (cr *concatenatedRecords) nextRecord() (name string, r io.Reader, err error) {
+	if cr.next == nil && cr.index > 0 {
+		return "", nil, io.EOF
+	}
+	if cr.index == 0 {
+		if _, err := cr.br.Peek(1); err != nil {
+			return "", nil, err
+		}
+	}
+
+	cr.index++
+	cr.delimiter, cr.pending, cr.next = cr.next, cr.next, nil
+	cr.atLineStart, cr.ended = true, false
+
+	name = cr.name
+	if cr.index > 1 {
+		name = fmt.Sprintf("%s#%d", cr.name, cr.index)
+	}
+	return name, cr, nil
+}
+
+func (cr *concatenatedRecords) Read(p []byte) (n int, err error) {
+	for len(cr.pending) == 0 {
+		if cr.ended {
+			return 0, io.EOF
+		}
+
+		line, err := cr.br.ReadSlice('\n')
+		if len(line) == 0 && err != nil {
+			cr.ended = true
+			return 0, err
+		}
+
+		lineStart := cr.atLineStart
+		cr.atLineStart = err == nil
+		if lineStart && recordDelimiterRegexp.Match(line) {
+			switch {
+			case cr.delimiter == nil:
+				cr.delimiter = append([]byte(nil), line...)
+			case !bytes.Equal(line, cr.delimiter):
+				cr.next, cr.ended = append([]byte(nil), line...), true
+				return 0, io.EOF
+			}
+		}
+		cr.pending = line
+	}
+
+	n = copy(p, cr.pending)
+	cr.pending = cr.pending[n:]
+	return
+}
+
+// recordEntries iterates over the records contained in a stream, which may be a single record, concatenated records,
+// a HAR, WARC, pcap or pcapng file, or a tar or zip archive of them, any of which may be gzipped.
+// Records are read lazily, hence each of them must be consumed before moving to the next one.
+type recordEntries struct {
+	files   func() (name string, r io.Reader, err error)
+	records *concatenatedRecords
+	limits  ingestLimits
+	closers []io.Closer
+}
+
+func openRecordEntries(name string, r io.Reader, limits ingestLimits) (re *recordEntries, err error) {
+	re = &recordEntries{limits: limits}
+	if re.files, err = re.open(name, r, &re.closers); err != nil {
+		re.Close()
+		return nil, err
+	}
+	return
+}
+
+// open detects the format of r, the closers of what is opened being appended to closers.
+func (re *recordEntries) open(name string, r io.Reader, closers *[]io.Closer) (files func() (string, io.Reader, error), err error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(tarMagicOffset + len(tarMagic))
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("error opening gzip: %w", err)
+		}
+		*closers = append(*closers, gz)
+		return re.open(strings.TrimSuffix(name, ".gz"), gz, closers)
+
+	case bytes.HasPrefix(head, zipMagic):
+		ra, size, err := re.readerAt(r, br, closers)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(ra, size)
+		if err != nil {
+			return nil, fmt.Errorf("error opening zip: %w", err)
+		}
+		ae, i := &archiveEntries{re: re}, 0
+		ae.entries = func() (string, io.Reader, error) {
+			for ; i < len(zr.File); i++ {
+				f := zr.File[i]
+				if f.FileInfo().IsDir() {
+					continue
+				}
+				i++
+				rc, err := f.Open()
+				if err != nil {
+					return "", nil, &recordEntryError{entry: f.Name, err: fmt.Errorf("error opening zip entry: %w", err)}
+				}
+				ae.current = append(ae.current, rc)
+				return f.Name, rc, nil
+			}
+			return "", nil, io.EOF
+		}
+		*closers = append(*closers, ae)
+		return ae.next, nil
+
+	case bytes.HasPrefix(bytes.TrimLeft(head, " \t\r\n\ufeff"), []byte("{")):
+		if bytes.HasPrefix(head, utf8BOM) {
+			br.Discard(len(utf8BOM))
+		}
+		har, err := newHAREntries(name, br)
+		if err != nil {
+			return nil, err
+		}
+		return har.next, nil
+
+	case isPcap(head), bytes.HasPrefix(head, pcapngMagic):
+		pe, err := newPcapEntries(name, br, bytes.HasPrefix(head, pcapngMagic))
+		if err != nil {
+			return nil, err
+		}
+		return pe.next, nil
+
+	case bytes.HasPrefix(head, warcMagic):
+		return newWARCEntries(name, br, re.limits.warcRecord).next, nil
+
+	case len(head) == tarMagicOffset+len(tarMagic) && bytes.Equal(head[tarMagicOffset:], tarMagic):
+		tr := tar.NewReader(br)
+		ae := &archiveEntries{re: re, entries: func() (string, io.Reader, error) {
+			for {
+				h, err := tr.Next()
+				if err != nil {
+					return "", nil, err
+				}
+				if h.Typeflag == tar.TypeReg {
+					return h.Name, tr, nil
+				}
+			}
+		}}
+		*closers = append(*closers, ae)
+		return ae.next, nil
+	}
+
+	done := false
+	return func() (string, io.Reader, error) {
+		if done {
+			return "", nil, io.EOF
+		}
+		done = true
+		return name, br, nil
+	}, nil
+}
+
+// readerAt returns r as io.ReaderAt when it is a file, otherwise spools the stream into a temporary file up to the spool limit, since zip can only be read randomly.
+func (re *recordEntries) readerAt(r io.Reader, br *bufio.Reader, closers *[]io.Closer) (io.ReaderAt, int64, error) {
+	if f, ok := r.(*os.File); ok {
+		if st, err := f.Stat(); err == nil && st.Mode().IsRegular() {
+			return f, st.Size(), nil
+		}
+	}
+	if re.limits.zipSpool <= 0 {
+		return nil, 0, fmt.Errorf("zip can only be read from a file")
+	}
+
+	tmp, err := os.CreateTemp("", "httpmsg-enricher-*.zip")
+	if err != nil {
+		return nil, 0, fmt.Errorf("error spooling zip: %w", err)
+	}
+	*closers = append(*closers, removeFile(tmp.Name()), tmp)
+	size, err := io.Copy(tmp, io.LimitReader(br, re.limits.zipSpool+1))
+	if err != nil {
+		return nil, 0, fmt.Errorf("error spooling zip: %w", err)
+	}
+	if size > re.limits.zipSpool {
+		return nil, 0, fmt.Errorf("zip larger than %d bytes can only be read from a file", re.limits.zipSpool)
+	}
+	return tmp, size, nil
+}
+
+// archiveEntries iterates over the files of a tar or zip archive, each of which is opened as any other stream,
+// so that it may be of any of the formats, gzipped or not.
+type archiveEntries struct {
+	re      *recordEntries
+	entries func() (name string, r io.Reader, err error)
+	entry   string
+	files   func() (name string, r io.Reader, err error) // of the current entry
+	current []io.Closer                                  // of the current entry, closed when moving to the next one
+}
+
+func (ae *archiveEntries) next() (name string, r io.Reader, err error) {
+	for {
+		if ae.files != nil {
+			name, r, err = ae.files()
+			var entryErr *recordEntryError
+			switch {
+			case err == nil, errors.As(err, &entryErr):
+				return
+			case err != io.EOF:
+				ae.files = nil
+				return "", nil, &recordEntryError{entry: ae.entry, err: err}
+			}
+			ae.files = nil
+		}
+
+		ae.closeCurrent()
+		if name, r, err = ae.entries(); err != nil {
+			return "", nil, err
+		}
+		ae.entry = name
+		if ae.files, err = ae.re.open(name, r, &ae.current); err != nil {
+			return "", nil, &recordEntryError{entry: name, err: err}
+		}
+	}
+}
+
+func (ae *archiveEntries) closeCurrent() (err error) {
+	for i := len(ae.current) - 1; i >= 0; i-- {
+		if errt := ae.current[i].Close(); errt != nil {
+			err = multierr.Append(err, errt)
+		}
+	}
+	ae.current = nil
+	return
+}
+
+func (ae *archiveEntries) Close() error { return ae.closeCurrent() }
+
+type removeFile string
+
+func (rf removeFile) Close() error { return os.Remove(string(rf)) }
+
+// Next returns the next record, or io.EOF when there is none.
+func (re *recordEntries) Next() (name string, r io.Reader, err error) {
+	for {
+		if re.records != nil {
+			if name, r, err = re.records.nextRecord(); err != io.EOF {
+				return
+			}
+			re.records = nil
+		}
+
+		name, r, err := re.files()
+		if err != nil {
+			return "", nil, err
+		}
+		re.records = newConcatenatedRecords(name, r)
+	}
+}
+
+func (re *recordEntries) Close() (err error) {
+	for i := len(re.closers) - 1; i >= 0; i-- {
+		if errt := re.closers[i].Close(); errt != nil {
+			err = multierr.Append(err, errt)
+		}
+	}
+	return
+}
+
+// enrichRecords enriches every record contained in r and writes their documents as NDJSON.
+// A record failing to be enriched is written as a line reporting its entry name and error instead of aborting the rest.
+func (ercr *enricher) enrichRecords(name string, r io.Reader, w io.Writer) (err error) {
+	entries, err := openRecordEntries(name, r, ercr.ingestLimits)
+	if err != nil {
+		return
+	}
+	defer entries.Close()
+
+	enc := json.NewEncoder(w)
+	for {
+		name, record, err := entries.Next()
+		var entryErr *recordEntryError
+		switch {
+		case err == io.EOF:
+			return nil
+		case errors.As(err, &entryErr):
+			name, record = entryErr.entry, nil
+			err = entryErr.err
+		case err != nil:
+			return err
+		default:
+			var docs []*ecsx.Document
+			if docs, err = ercr.enrichEntry(name, record); err == nil {
+				for _, doc := range docs {
+					if err := enc.Encode(doc); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+		}
+
+		line := struct {
+			Ingest *ecsx.Ingest `json:"_ingest"`
+		}{&ecsx.Ingest{Entry: name, Error: err.Error()}}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+		if record != nil {
+			io.Copy(io.Discard, record)
+		}
+	}
+}
+
+func (ercr *enricher) enrichEntry(name string, record io.Reader) (docs []*ecsx.Document, err error) {
+	erc, err := ercr.EnrichRecord(record)
+	if err != nil {
+		return
+	}
+	defer erc.Close()
+
+	if docs, err = erc.toECS(); err != nil {
+		return
+	}
+	for _, doc := range docs {
+		doc.Ingest = &ecsx.Ingest{Entry: name}
+	}
+	return
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readTestRecords(t *testing.T, files ...string) (records [][]byte) {
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		require.NoError(t, err, "unexpected error in reading test data")
+		records = append(records, b)
+	}
+	return
+}
+
+type ingestedLine struct {
+	Ingest struct {
+		Entry string `json:"entry"`
+		Error string `json:"error"`
+	} `json:"_ingest"`
+	Event struct {
+		Sequence int `json:"sequence"`
+	} `json:"event"`
+}
+
+func enrichTestRecords(t *testing.T, name string, b []byte) (lines []ingestedLine) {
+	ercr, err := newEnricher()
+	require.NoError(t, err)
+
+	out := bytes.Buffer{}
+	require.NoError(t, ercr.enrichRecords(name, bytes.NewReader(b), &out))
+
+	sc := bufio.NewScanner(&out)
+	sc.Buffer(nil, 1024*1024)
+	for sc.Scan() {
+		l := ingestedLine{}
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &l), "should produce ndjson")
+		lines = append(lines, l)
+	}
+	return
+}
+
+func entriesOf(lines []ingestedLine) (entries []string) {
+	for _, l := range lines {
+		entries = append(entries, l.Ingest.Entry)
+	}
+	return
+}
+
+func TestEnrichConcatenatedRecords(t *testing.T) {
+	records := readTestRecords(t, "testdata/record.txt", "testdata/record5.txt", "testdata/record1.txt")
+	lines := enrichTestRecords(t, "batch.txt", bytes.Join(records, []byte("\r\n")))
+	assert.Equal(t, []string{"batch.txt", "batch.txt#2", "batch.txt#2", "batch.txt#2", "batch.txt#3"}, entriesOf(lines))
+	assert.Equal(t, 3, lines[3].Event.Sequence)
+
+	single := enrichTestRecords(t, "record.txt", records[0])
+	assert.Equal(t, []string{"record.txt"}, entriesOf(single))
+}
+
+func TestEnrichArchivedRecords(t *testing.T) {
+	records := readTestRecords(t, "testdata/record.txt", "testdata/record1.txt")
+	files := []struct {
+		name string
+		body []byte
+	}{
+		{"a/record.txt", records[0]},
+		{"a/broken.txt", []byte("EOF-----------------broken\r\n\r\nnot http\r\n")},
+		{"b/record1.txt", records[1]},
+	}
+
+	tarball := bytes.Buffer{}
+	gz := gzip.NewWriter(&tarball)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "a/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for _, f := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.body))}))
+		_, err := tw.Write(f.body)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	zipped := bytes.Buffer{}
+	zw := zip.NewWriter(&zipped)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		require.NoError(t, err)
+		_, err = w.Write(f.body)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	for name, b := range map[string][]byte{"records.tar.gz": tarball.Bytes(), "records.zip": zipped.Bytes()} {
+		lines := enrichTestRecords(t, name, b)
+		assert.Equal(t, []string{"a/record.txt", "a/broken.txt", "b/record1.txt"}, entriesOf(lines), name)
+		assert.Empty(t, lines[0].Ingest.Error, name)
+		assert.NotEmpty(t, lines[1].Ingest.Error, "should report error of broken entry in %s", name)
+		assert.Empty(t, lines[2].Ingest.Error, name)
+	}
+}
+
+func TestEnrichArchivedFormats(t *testing.T) {
+	records := readTestRecords(t, "testdata/sample.har", "testdata/sample.warc", "testdata/record.txt", "testdata/record1.txt")
+	gzipped := bytes.Buffer{}
+	gz := gzip.NewWriter(&gzipped)
+	_, err := gz.Write(records[2])
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	files := []struct {
+		name string
+		body []byte
+	}{
+		{"logs/sample.har", records[0]},
+		{"logs/sample.warc", records[1]},
+		{"records/record.txt.gz", gzipped.Bytes()},
+		{"records/corrupt.txt.gz", []byte("\x1f\x8bnot gzip")},
+		{"records/record1.txt", records[3]},
+	}
+	expected := []string{
+		"logs/sample.har#1", "logs/sample.har#2", "logs/sample.har#3",
+		"logs/sample.warc#1", "logs/sample.warc#2", "logs/sample.warc#3",
+		"records/record.txt", "records/corrupt.txt.gz", "records/record1.txt",
+	}
+
+	tarball := bytes.Buffer{}
+	tw := tar.NewWriter(&tarball)
+	for _, f := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.body))}))
+		_, err := tw.Write(f.body)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	zipped := bytes.Buffer{}
+	zw := zip.NewWriter(&zipped)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		require.NoError(t, err)
+		_, err = w.Write(f.body)
+		require.NoError(t, err)
+	}
+	_, err = zw.CreateRaw(&zip.FileHeader{Name: "records/unsupported.txt", Method: 99})
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	for name, b := range map[string][]byte{"records.tar": tarball.Bytes(), "records.zip": zipped.Bytes()} {
+		lines := enrichTestRecords(t, name, b)
+		errored := map[string]bool{}
+		for _, l := range lines {
+			errored[l.Ingest.Entry] = l.Ingest.Error != ""
+		}
+
+		if name == "records.zip" {
+			require.Equal(t, append(expected, "records/unsupported.txt"), entriesOf(lines), name)
+			assert.True(t, errored["records/unsupported.txt"], "should report zip entry which can not be opened")
+		} else {
+			require.Equal(t, expected, entriesOf(lines), name)
+		}
+		assert.False(t, errored["logs/sample.har#1"], "should convert archived har in %s", name)
+		assert.False(t, errored["logs/sample.warc#1"], "should convert archived warc in %s", name)
+		assert.False(t, errored["records/record.txt"], "should decompress gzipped entry in %s", name)
+		assert.True(t, errored["records/corrupt.txt.gz"], "should report corrupt gzipped entry in %s", name)
+		assert.False(t, errored["records/record1.txt"], "should continue after corrupt entry in %s", name)
+	}
+}
+
+func TestRecordEntriesZipSpoolLimit(t *testing.T) {
+	zipped := bytes.Buffer{}
+	zw := zip.NewWriter(&zipped)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte("EOF-----------------x\r\n\r\nnot http\r\n"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+
+	_, err := openRecordEntries("records.zip", bytes.NewReader(zipped.Bytes()), ingestLimits{zipSpool: int64(zipped.Len() - 1)})
+	assert.Error(t, err, "should reject zip larger than the spool limit")
+	_, err = openRecordEntries("records.zip", bytes.NewReader(zipped.Bytes()), ingestLimits{})
+	assert.Error(t, err, "should reject zip not read from a file")
+
+	entries, err := openRecordEntries("records.zip", bytes.NewReader(zipped.Bytes()), ingestLimits{zipSpool: int64(zipped.Len())})
+	require.NoError(t, err)
+	defer entries.Close()
+	archive := entries.closers[len(entries.closers)-1].(*archiveEntries)
+	for _, expected := range []string{"a.txt", "b.txt"} {
+		name, r, err := entries.Next()
+		require.NoError(t, err)
+		assert.Equal(t, expected, name)
+		assert.Len(t, archive.current, 1, "should close the reader of the previous entry")
+		_, err = io.Copy(io.Discard, r)
+		require.NoError(t, err)
+	}
+	_, _, err = entries.Next()
+	assert.Equal(t, io.EOF, err)
+	assert.Empty(t, archive.current)
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"compress/gzip"
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strings"
@@ -15,6 +18,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
 )
 
 func newS3Client(cfg *config) (*s3.Client, error) {
@@ -35,20 +39,128 @@ func newS3Client(cfg *config) (*s3.Client, error) {
 	return s3.NewFromConfig(s3Cfg), err
 }
 
+// renderDocuments responds with the document of a single exchange record as JSON, or with the documents of a multi exchanges record as NDJSON.
+func renderDocuments(c *gin.Context, docs []*ecsx.Document) {
+	if len(docs) == 1 {
+		c.JSON(http.StatusOK, docs[0])
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	for _, doc := range docs {
+		if err := enc.Encode(doc); err != nil {
+			log.Printf("error writing document: %v", err)
+			return
+		}
+	}
+}
+
+func discoverFile(d *endpointDiscovery, ercr *enricher, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return d.addRecords(ercr, name, f)
+}
+
+func discoverS3Object(ctx context.Context, d *endpointDiscovery, ercr *enricher, s3Client *s3.Client, bucket, key string) error {
+	resp, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return d.addRecords(ercr, key, resp.Body)
+}
+
+func validateFile(name string) ([]recordDiagnostic, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return validateRecord(f)
+}
+
 func main() {
 	cfg, err := newConfig()
 	if err != nil {
 		log.Fatalf("error loading config: %v", err)
 	}
 
+	// `record` starts a reverse proxy to the upstream recording every exchange instead of starting the server
+	if len(os.Args) > 1 && os.Args[1] == "record" {
+		upstream, err := url.Parse(cfg.Recorder.Upstream)
+		if err != nil || upstream.Host == "" {
+			log.Fatalf("invalid recorder upstream: %q", cfg.Recorder.Upstream)
+		}
+		sink, err := newRecordSink(cfg)
+		if err != nil {
+			log.Fatalf("error initializing recorder: %v", err)
+		}
+		log.Fatal(http.ListenAndServe(cfg.Recorder.Listen, newRecordingProxy(upstream, sink, cfg.Recorder.BodyLimit)))
+	}
+
+	// `validate <record>...` checks the given records against the record format instead of starting the server
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		invalid := false
+		for _, name := range os.Args[2:] {
+			diags, err := validateFile(name)
+			if err != nil {
+				log.Fatalf("error validating %s: %v", name, err)
+			}
+			for _, d := range diags {
+				fmt.Printf("%s:%d: %s\n", name, d.Line, d.Message)
+			}
+			invalid = invalid || len(diags) > 0
+		}
+		if invalid {
+			os.Exit(1)
+		}
+		return
+	}
+
 	ercr, err := newEnricher(
 		enricherWithCRS("crs/coraza.conf", "crs/crs-setup.conf", "crs/rules/*.conf"),
 		enricherWithOptionalGeoIP(cfg.GeoIP.CityDBPath),
+		enricherWithBodyParseLimit(cfg.BodyParseLimit),
+		enricherWithBodyLimits(cfg.Limits.BodySize, cfg.Limits.DecompressionRatio, cfg.Limits.RecordTime),
+		enricherWithIngestLimits(cfg.Limits.ZipSpoolSize, cfg.Limits.WARCRecordSize),
+		enricherWithBodyCapture(cfg.Capture.RequestLimit, cfg.Capture.ResponseLimit, cfg.Capture.HeadTail, cfg.Capture.Rules),
+		enricherWithSecrets(cfg.Secrets.RulesPath, cfg.Secrets.FingerprintKey),
+		enricherWithSignatures(cfg.Signatures.RulesDir),
+		enricherWithOpenAPI(cfg.OpenAPI.SpecsDir),
+		enricherWithGraphQLLimits(cfg.GraphQL.MaxDepth, cfg.GraphQL.MaxAliases),
+		enricherWithWebSocketInspection(cfg.WebSocket.InspectMessages),
+		enricherWithGRPCDescriptorSet(cfg.GRPC.DescriptorSet),
+		enricherWithRedaction(cfg.Redaction.Mode, cfg.Redaction.Headers, cfg.Redaction.Fields, cfg.Redaction.Patterns),
 	)
 	if err != nil {
 		log.Fatalf("error initializing enricher: %v", err)
 	}
 
+	// `discover <record>...` prints the endpoint discovery report of the given records instead of starting the server
+	if len(os.Args) > 1 && os.Args[1] == "discover" {
+		d := newEndpointDiscovery(ercr.openAPI)
+		for _, name := range os.Args[2:] {
+			if err := discoverFile(d, ercr, name); err != nil {
+				log.Printf("error processing %s: %v", name, err)
+			}
+		}
+		if err := d.report().writeJSON(os.Stdout); err != nil {
+			log.Fatalf("error writing report: %v", err)
+		}
+		return
+	}
+
 	s3Client, err := newS3Client(cfg)
 	if err != nil {
 		log.Fatalf("error initializing s3 client: %v", err)
@@ -86,12 +198,12 @@ func main() {
 		}
 		defer erc.Close()
 
-		ecs, err := erc.toECS()
+		docs, err := erc.toECS()
 		if err != nil {
 			c.String(500, err.Error())
 			return
 		}
-		c.JSON(http.StatusOK, ecs)
+		renderDocuments(c, docs)
 	})
 
 	r.GET("/ecs/files/:filename", func(c *gin.Context) {
@@ -119,12 +231,73 @@ func main() {
 		}
 		defer er.Close()
 
-		ecs, err := er.toECS()
+		docs, err := er.toECS()
+		if err != nil {
+			c.String(500, err.Error())
+			return
+		}
+		renderDocuments(c, docs)
+	})
+
+	r.GET("/ndjson/s3/*object_key", func(c *gin.Context) {
+		key := strings.TrimPrefix(c.Param("object_key"), "/")
+		resp, err := s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
+			Bucket: aws.String(cfg.S3.Bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			c.String(500, err.Error())
+			return
+		}
+		defer resp.Body.Close()
+
+		c.Header("Content-Type", "application/x-ndjson")
+		if err := ercr.enrichRecords(key, resp.Body, c.Writer); err != nil {
+			if !c.Writer.Written() {
+				c.String(500, err.Error())
+				return
+			}
+			log.Printf("error enriching %s: %v", key, err)
+		}
+	})
+
+	r.GET("/ndjson/files/:filename", func(c *gin.Context) {
+		f, err := os.Open("./" + path.Clean(c.Param("filename")))
 		if err != nil {
 			c.String(500, err.Error())
 			return
 		}
-		c.JSON(http.StatusOK, ecs)
+		defer f.Close()
+
+		c.Header("Content-Type", "application/x-ndjson")
+		if err := ercr.enrichRecords(c.Param("filename"), f, c.Writer); err != nil {
+			if !c.Writer.Written() {
+				c.String(500, err.Error())
+				return
+			}
+			log.Printf("error enriching %s: %v", c.Param("filename"), err)
+		}
+	})
+
+	r.GET("/discovery/s3/*prefix", func(c *gin.Context) {
+		d := newEndpointDiscovery(ercr.openAPI)
+		pages := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(cfg.S3.Bucket),
+			Prefix: aws.String(strings.TrimPrefix(c.Param("prefix"), "/")),
+		})
+		for pages.HasMorePages() {
+			page, err := pages.NextPage(c.Request.Context())
+			if err != nil {
+				c.String(500, err.Error())
+				return
+			}
+			for _, obj := range page.Contents {
+				if err := discoverS3Object(c.Request.Context(), d, ercr, s3Client, cfg.S3.Bucket, *obj.Key); err != nil {
+					log.Printf("error processing %s: %v", *obj.Key, err)
+				}
+			}
+		}
+		c.JSON(http.StatusOK, d.report())
 	})
 
 	r.Run()
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+func TestRenderDocuments(t *testing.T) {
+	for n := 1; n <= 2; n++ {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		docs := []*ecsx.Document{}
+		for i := 0; i < n; i++ {
+			docs = append(docs, &ecsx.Document{})
+		}
+		renderDocuments(c, docs)
+
+		if n == 1 {
+			assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"), "should respond with a single document as json")
+			assert.True(t, json.Valid(w.Body.Bytes()), "should respond with a json object")
+			continue
+		}
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"), "should respond with multiple documents as ndjson")
+		assert.Len(t, strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n"), n)
+	}
+}
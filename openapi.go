@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+var serverOriginRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://[^/]*`)
+
+type openAPISpec struct {
+	file   string
+	hosts  []string
+	doc    *openapi3.T
+	router routers.Router
+}
+
+// openAPIRegistry holds OpenAPI specs loaded from a directory, indexed by host.
+// The host of a spec is taken from its file name without extension (e.g. api.example.com.yaml) and from the host of its absolute server URLs.
+type openAPIRegistry struct {
+	specs  []*openAPISpec
+	byHost map[string]*openAPISpec
+}
+
+func loadOpenAPISpec(file string) (spec *openAPISpec, err error) {
+	loader := openapi3.NewLoader()
+	loader.IsExternalRefsAllowed = true
+	doc, err := loader.LoadFromFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error loading openapi spec: %w", err)
+	}
+	if err = doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid openapi spec: %w", err)
+	}
+
+	spec = &openAPISpec{file: file, doc: doc}
+	stem := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	if strings.Contains(stem, ".") || stem == "localhost" {
+		spec.hosts = append(spec.hosts, strings.ToLower(stem))
+	}
+
+	// recorded requests are matched by their path only, since the host has been used to select the spec
+	servers := openapi3.Servers{}
+	for _, s := range doc.Servers {
+		if u, err := url.Parse(s.URL); err == nil && u.Host != "" && !strings.Contains(u.Host, "{") {
+			spec.hosts = append(spec.hosts, strings.ToLower(u.Hostname()))
+		}
+		rel := *s
+		if rel.URL = serverOriginRegexp.ReplaceAllString(s.URL, ""); rel.URL == "" {
+			rel.URL = "/"
+		}
+		servers = append(servers, &rel)
+	}
+	doc.Servers = servers
+	for _, p := range doc.Paths {
+		p.Servers = nil
+	}
+
+	if spec.router, err = gorillamux.NewRouter(doc); err != nil {
+		return nil, fmt.Errorf("error creating router: %w", err)
+	}
+	return
+}
+
+func loadOpenAPIRegistry(dir string) (reg *openAPIRegistry, err error) {
+	files := []string{}
+	for _, ext := range []string{"*.yaml", "*.yml", "*.json"} {
+		m, err := filepath.Glob(filepath.Join(dir, ext))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, m...)
+	}
+
+	reg = &openAPIRegistry{byHost: map[string]*openAPISpec{}}
+	for _, f := range files {
+		spec, err := loadOpenAPISpec(f)
+		if err != nil {
+			return nil, fmt.Errorf("error loading %s: %w", f, err)
+		}
+		if len(spec.hosts) == 0 {
+			return nil, fmt.Errorf("can not determine host of %s, name the file after the host or use absolute server url", f)
+		}
+		for _, h := range spec.hosts {
+			if prev, ok := reg.byHost[h]; ok && prev != spec {
+				return nil, fmt.Errorf("host %s of %s is already served by %s", h, f, prev.file)
+			}
+			reg.byHost[h] = spec
+		}
+		reg.specs = append(reg.specs, spec)
+	}
+	return
+}
+
+func (reg *openAPIRegistry) lookup(host string) *openAPISpec {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return reg.byHost[strings.ToLower(host)]
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+var (
+	pcapMagics = [][]byte{
+		{0xd4, 0xc3, 0xb2, 0xa1}, {0xa1, 0xb2, 0xc3, 0xd4}, // microseconds
+		{0x4d, 0x3c, 0xb2, 0xa1}, {0xa1, 0xb2, 0x3c, 0x4d}, // nanoseconds
+	}
+	pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+)
+
+func isPcap(head []byte) bool {
+	for _, m := range pcapMagics {
+		if bytes.HasPrefix(head, m) {
+			return true
+		}
+	}
+	return false
+}
+
+type pcapSegment struct {
+	offset int
+	seen   time.Time
+}
+
+// pcapStream holds the reassembled payload of one direction of a TCP connection, along with when each part of it was seen.
+type pcapStream struct {
+	net, transport gopacket.Flow
+	data           []byte
+	segments       []pcapSegment
+	paired         bool
+}
+
+func (s *pcapStream) Reassembled(rs []tcpassembly.Reassembly) {
+	for _, r := range rs {
+		if len(r.Bytes) == 0 {
+			continue
+		}
+		s.segments = append(s.segments, pcapSegment{offset: len(s.data), seen: r.Seen})
+		s.data = append(s.data, r.Bytes...)
+	}
+}
+
+func (s *pcapStream) ReassemblyComplete() {}
+
+// seenAt returns when the byte at the given offset was seen.
+func (s *pcapStream) seenAt(offset int) time.Time {
+	i := sort.Search(len(s.segments), func(i int) bool { return s.segments[i].offset > offset })
+	if i == 0 {
+		return time.Time{}
+	}
+	return s.segments[i-1].seen
+}
+
+func (s *pcapStream) endpoint() (ip net.IP, port uint16) {
+	src, _ := s.net.Endpoints()
+	tsrc, _ := s.transport.Endpoints()
+	return net.IP(src.Raw()), binary.BigEndian.Uint16(tsrc.Raw())
+}
+
+func (s *pcapStream) String() string {
+	ip, port := s.endpoint()
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+}
+
+// pcapStreams collects the streams created by the assembler, keyed by their flows so that both directions of a connection can be paired.
+type pcapStreams struct {
+	list  []*pcapStream
+	flows map[[2]gopacket.Flow][]*pcapStream
+}
+
+func (ps *pcapStreams) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
+	s := &pcapStream{net: netFlow, transport: tcpFlow}
+	ps.list = append(ps.list, s)
+	key := [2]gopacket.Flow{netFlow, tcpFlow}
+	ps.flows[key] = append(ps.flows[key], s)
+	return s
+}
+
+// reverse returns the first unpaired stream flowing in the opposite direction of s.
+func (ps *pcapStreams) reverse(s *pcapStream) *pcapStream {
+	for _, r := range ps.flows[[2]gopacket.Flow{s.net.Reverse(), s.transport.Reverse()}] {
+		if !r.paired {
+			return r
+		}
+	}
+	return nil
+}
+
+// pcapExchange is a request and its response read out of both directions of a connection, along with the offsets delimiting them.
+type pcapExchange struct {
+	client, server   *pcapStream
+	req              *http.Request
+	reqBody          []byte
+	res              *http.Response
+	resBody          []byte
+	reqStart, reqEnd int
+	resStart, resEnd int
+}
+
+// record converts the exchange into the recorded format.
+// The total duration spans from the first byte of the request until the last byte of the response,
+// while the proxy window covers the wait between the last byte of the request and the first byte of the response.
+func (x *pcapExchange) record(id string) (io.Reader, error) {
+	rb := newRecordBuilder()
+	if err := rb.exchange(x.req, x.reqBody, x.res, x.resBody); err != nil {
+		return nil, err
+	}
+
+	clientIP, clientPort := x.client.endpoint()
+	serverIP, serverPort := x.server.endpoint()
+	target := (&url.URL{Scheme: "http", Host: net.JoinHostPort(serverIP.String(), strconv.Itoa(int(serverPort)))}).String()
+	ctx := &httpRecordedMessageContext{
+		ID: id,
+		Connection: &httpRecordedMessageContextConnection{
+			Protocol: "http",
+			Client:   httpRecordedMessageContextConnectionClient{IP: clientIP, Port: clientPort},
+		},
+		Host: &httpRecordedMessageContextHost{Name: x.req.Host, Target: &target},
+	}
+
+	start, end := x.client.seenAt(x.reqStart), x.server.seenAt(x.resEnd-1)
+	if !start.IsZero() && !end.IsZero() {
+		ctx.Durations = &httpRecordedMessageContextDurations{
+			Total: httpRecordedMessageContextDuration{Start: start, End: &end},
+		}
+		waitStart, waitEnd := x.client.seenAt(x.reqEnd-1), x.server.seenAt(x.resStart)
+		if !waitEnd.Before(waitStart) {
+			ctx.Durations.Proxy = &httpRecordedMessageContextDuration{Start: waitStart, End: &waitEnd}
+		}
+	}
+	return rb.build(ctx)
+}
+
+// offsetReader tells the offset of the next byte to be parsed out of a stream.
+type offsetReader struct {
+	br   *bufio.Reader
+	data []byte
+	r    *bytes.Reader
+}
+
+func newOffsetReader(data []byte) *offsetReader {
+	r := bytes.NewReader(data)
+	return &offsetReader{br: bufio.NewReader(r), data: data, r: r}
+}
+
+func (or *offsetReader) offset() int { return len(or.data) - or.r.Len() - or.br.Buffered() }
+func (or *offsetReader) more() bool {
+	_, err := or.br.Peek(1)
+	return err == nil
+}
+
+// pcapExchanges parses the HTTP/1.x exchanges of a connection, stopping at the first message that can not be parsed, e.g. due to a capture gap.
+func pcapExchanges(client, server *pcapStream) (xs []*pcapExchange, err error) {
+	reqs, ress := newOffsetReader(client.data), newOffsetReader(server.data)
+	for reqs.more() {
+		x := &pcapExchange{client: client, server: server, reqStart: reqs.offset()}
+		if x.req, err = http.ReadRequest(reqs.br); err != nil {
+			return xs, fmt.Errorf("error parsing request at offset %d: %w", x.reqStart, err)
+		}
+		if x.reqBody, err = readPayload(x.req.Body); err != nil {
+			return xs, fmt.Errorf("error reading request body at offset %d: %w", x.reqStart, err)
+		}
+		x.reqEnd = reqs.offset()
+
+		// skip interim responses, except for protocol switches after which the connection no longer carries HTTP/1.x
+		for {
+			if !ress.more() {
+				return xs, fmt.Errorf("no response to request at offset %d", x.reqStart)
+			}
+			x.resStart = ress.offset()
+			if x.res, err = http.ReadResponse(ress.br, x.req); err != nil {
+				return xs, fmt.Errorf("error parsing response at offset %d: %w", x.resStart, err)
+			}
+			if x.res.StatusCode >= 200 || x.res.StatusCode == http.StatusSwitchingProtocols {
+				break
+			}
+		}
+		if x.resBody, err = readPayload(x.res.Body); err != nil {
+			return xs, fmt.Errorf("error reading response body at offset %d: %w", x.resStart, err)
+		}
+		x.resEnd = ress.offset()
+
+		xs = append(xs, x)
+		if x.res.StatusCode == http.StatusSwitchingProtocols {
+			return
+		}
+	}
+	return
+}
+
+type pcapPacketReader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+}
+
+// pcapEntries iterates over the HTTP/1.x exchanges reassembled out of the TCP connections of a pcap or pcapng capture.
+// The whole capture is reassembled before the first exchange is returned, and connections are ordered by their first packet.
+// Connections whose exchanges can not be parsed completely are reported as failing entries following their parsed exchanges.
+type pcapEntries struct {
+	name    string
+	entries []pcapEntry
+	index   int
+}
+
+type pcapEntry struct {
+	x   *pcapExchange
+	err error
+}
+
+func newPcapEntries(name string, r io.Reader, ng bool) (pe *pcapEntries, err error) {
+	var pr pcapPacketReader
+	if ng {
+		pr, err = pcapgo.NewNgReader(r, pcapgo.DefaultNgReaderOptions)
+	} else {
+		pr, err = pcapgo.NewReader(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid capture: %w", err)
+	}
+
+	streams := &pcapStreams{flows: map[[2]gopacket.Flow][]*pcapStream{}}
+	assembler := tcpassembly.NewAssembler(tcpassembly.NewStreamPool(streams))
+	for {
+		data, ci, err := pr.ReadPacketData()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break // captures are often cut abruptly
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading capture: %w", err)
+		}
+
+		packet := gopacket.NewPacket(data, pr.LinkType(), gopacket.DecodeOptions{Lazy: true})
+		tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if !ok || packet.NetworkLayer() == nil {
+			continue
+		}
+		assembler.AssembleWithTimestamp(packet.NetworkLayer().NetworkFlow(), tcp, ci.Timestamp)
+	}
+	assembler.FlushAll()
+
+	pe = &pcapEntries{name: name}
+	for _, s := range streams.list {
+		if s.paired || len(s.data) == 0 || bytes.HasPrefix(s.data, []byte("HTTP/")) {
+			continue
+		}
+		server := streams.reverse(s)
+		if server == nil {
+			server = &pcapStream{net: s.net.Reverse(), transport: s.transport.Reverse()}
+		}
+		s.paired, server.paired = true, true
+
+		xs, err := pcapExchanges(s, server)
+		for _, x := range xs {
+			pe.add(x, nil)
+		}
+		if err != nil {
+			pe.add(nil, fmt.Errorf("error reassembling connection from %s to %s: %w", s, server, err))
+		}
+	}
+	return
+}
+
+func (pe *pcapEntries) add(x *pcapExchange, err error) {
+	pe.entries = append(pe.entries, pcapEntry{x: x, err: err})
+}
+
+// next returns the record of the next exchange, or io.EOF when there is none.
+func (pe *pcapEntries) next() (name string, r io.Reader, err error) {
+	if pe.index >= len(pe.entries) {
+		return "", nil, io.EOF
+	}
+	e := pe.entries[pe.index]
+	pe.entries[pe.index] = pcapEntry{} // release the exchange
+	pe.index++
+
+	name = fmt.Sprintf("%s#%d", pe.name, pe.index)
+	if e.err == nil {
+		r, e.err = e.x.record(name)
+	}
+	if e.err != nil {
+		return "", nil, &recordEntryError{entry: name, err: e.err}
+	}
+	return
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCapturedPacket struct {
+	ci   gopacket.CaptureInfo
+	data []byte
+}
+
+// testTCPConn captures both directions of a TCP connection as ethernet frames.
+type testTCPConn struct {
+	t                   *testing.T
+	client, server      net.IP
+	clientPort, srvPort uint16
+	clientSeq, srvSeq   uint32
+	packets             *[]testCapturedPacket
+}
+
+func (c *testTCPConn) send(fromClient bool, at time.Time, syn, fin bool, payload string) {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: c.client, DstIP: c.server}
+	tcp := &layers.TCP{SrcPort: layers.TCPPort(c.clientPort), DstPort: layers.TCPPort(c.srvPort), Seq: c.clientSeq, Ack: c.srvSeq, SYN: syn, FIN: fin, ACK: !syn || !fromClient, Window: 65535}
+	seq := &c.clientSeq
+	if !fromClient {
+		ip.SrcIP, ip.DstIP = c.server, c.client
+		tcp.SrcPort, tcp.DstPort = tcp.DstPort, tcp.SrcPort
+		tcp.Seq, tcp.Ack = c.srvSeq, c.clientSeq
+		seq = &c.srvSeq
+	}
+	require.NoError(c.t, tcp.SetNetworkLayerForChecksum(ip))
+
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, DstMAC: net.HardwareAddr{0, 1, 2, 3, 4, 6}, EthernetType: layers.EthernetTypeIPv4}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(c.t, gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload(payload)))
+
+	*seq += uint32(len(payload))
+	if syn || fin {
+		*seq++
+	}
+	data := buf.Bytes()
+	*c.packets = append(*c.packets, testCapturedPacket{gopacket.CaptureInfo{Timestamp: at, CaptureLength: len(data), Length: len(data)}, data})
+}
+
+func testCapture(t *testing.T, t0 time.Time) (packets []testCapturedPacket) {
+	ms := func(n int) time.Time { return t0.Add(time.Duration(n) * time.Millisecond) }
+
+	c := &testTCPConn{t: t, client: net.IPv4(10, 1, 2, 3).To4(), server: net.IPv4(93, 184, 216, 34).To4(), clientPort: 50000, srvPort: 8080, clientSeq: 1000, srvSeq: 5000, packets: &packets}
+	c.send(true, ms(0), true, false, "")
+	c.send(false, ms(1), true, false, "")
+	c.send(true, ms(2), false, false, "")
+
+	// the request is split into two segments captured out of order
+	req := "GET /users/1 HTTP/1.1\r\nHost: api.example.com:8080\r\nUser-Agent: curl/7.81.0\r\n\r\n"
+	seq := c.clientSeq
+	c.clientSeq = seq + 20
+	c.send(true, ms(11), false, false, req[20:])
+	c.clientSeq = seq
+	c.send(true, ms(10), false, false, req[:20])
+	c.clientSeq = seq + uint32(len(req))
+
+	res := "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 23\r\n\r\n"
+	c.send(false, ms(50), false, false, res+`{"id":1,`)
+	c.send(false, ms(70), false, false, `"name":"alice"}`)
+
+	c.send(true, ms(100), false, false, "POST /users HTTP/1.1\r\nHost: api.example.com:8080\r\nContent-Length: 8\r\nExpect: 100-continue\r\n\r\n")
+	c.send(false, ms(101), false, false, "HTTP/1.1 100 Continue\r\n\r\n")
+	c.send(true, ms(102), false, false, "name=bob")
+	c.send(false, ms(120), false, false, "HTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n")
+	c.send(true, ms(130), false, true, "")
+	c.send(false, ms(131), false, true, "")
+
+	// the capture ends before the response of the other connection
+	c2 := &testTCPConn{t: t, client: net.IPv4(10, 1, 2, 4).To4(), server: net.IPv4(93, 184, 216, 34).To4(), clientPort: 50001, srvPort: 8080, clientSeq: 2000, srvSeq: 7000, packets: &packets}
+	c2.send(true, ms(200), true, false, "")
+	c2.send(false, ms(201), true, false, "")
+	c2.send(true, ms(202), false, false, "GET /slow HTTP/1.1\r\nHost: api.example.com:8080\r\n\r\n")
+	return
+}
+
+func TestEnrichPcapRecords(t *testing.T) {
+	t0 := time.Date(2023, 1, 20, 10, 0, 0, 0, time.UTC)
+	packets := testCapture(t, t0)
+
+	pcap := bytes.Buffer{}
+	w := pcapgo.NewWriterNanos(&pcap)
+	require.NoError(t, w.WriteFileHeader(65536, layers.LinkTypeEthernet))
+	for _, p := range packets {
+		require.NoError(t, w.WritePacket(p.ci, p.data))
+	}
+
+	pcapng := bytes.Buffer{}
+	ngw, err := pcapgo.NewNgWriter(&pcapng, layers.LinkTypeEthernet)
+	require.NoError(t, err)
+	for _, p := range packets {
+		require.NoError(t, ngw.WritePacket(p.ci, p.data))
+	}
+	require.NoError(t, ngw.Flush())
+
+	ercr, err := newEnricher()
+	require.NoError(t, err)
+	for name, b := range map[string][]byte{"capture.pcap": pcap.Bytes(), "capture.pcapng": pcapng.Bytes()} {
+		lines := enrichTestRecords(t, name, b)
+		assert.Equal(t, []string{name + "#1", name + "#2", name + "#3"}, entriesOf(lines), name)
+		assert.Empty(t, lines[0].Ingest.Error, name)
+		assert.Empty(t, lines[1].Ingest.Error, name)
+		assert.Contains(t, lines[2].Ingest.Error, "no response", name)
+
+		entries, err := openRecordEntries(name, bytes.NewReader(b), ingestLimits{zipSpool: defaultZipSpoolLimit})
+		require.NoError(t, err, name)
+		_, r, err := entries.Next()
+		require.NoError(t, err, name)
+		erc, err := ercr.EnrichRecord(r)
+		require.NoError(t, err, name)
+		docs, err := erc.toECS()
+		require.NoError(t, err, name)
+		doc := docs[0]
+
+		assert.Equal(t, "http://api.example.com:8080/users/1", doc.URL.Full, name)
+		assert.Equal(t, `{"id":1,"name":"alice"}`, doc.HTTP.Response.Body.Content, name)
+		assert.Equal(t, "10.1.2.3", doc.Source.Address, name)
+		assert.Equal(t, uint16(50000), doc.Source.Port, name)
+		assert.Equal(t, "93.184.216.34", doc.Destination.Address, name)
+		assert.Equal(t, uint16(8080), doc.Destination.Port, name)
+		assert.Equal(t, t0.Add(10*time.Millisecond), doc.Timestamp, name)
+		require.NotNil(t, doc.Event.Duration, name)
+		assert.Equal(t, 60*time.Millisecond, *doc.Event.Duration, name)
+		assert.Equal(t, 39*time.Millisecond, *doc.Latency.Upstream, name)
+
+		erc.Close()
+		entries.Close()
+	}
+}
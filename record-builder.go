@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// recordBuilder builds a record in the recorded format out of HTTP messages coming from other sources, so that they can go through the same pipeline.
+// Bodies are written as is, hence the headers describing their original framing are replaced by Content-Length.
+type recordBuilder struct {
+	buf bytes.Buffer
+	rw  *recordWriter
+}
+
+func newRecordBuilder() *recordBuilder {
+	rb := &recordBuilder{}
+	rb.rw, _ = newRecordWriter(&rb.buf) // writing to a buffer never fails
+	return rb
+}
+
+// normalizeHTTPVersion returns the HTTP version in the form expected in a start line.
+func normalizeHTTPVersion(v string) string {
+	switch u := strings.ToUpper(strings.TrimSpace(v)); u {
+	case "H2", "HTTP/2", "HTTP/2.0":
+		return "HTTP/2.0"
+	case "H3", "HTTP/3", "HTTP/3.0":
+		return "HTTP/3.0"
+	case "HTTP/1.0":
+		return u
+	}
+	return "HTTP/1.1"
+}
+
+func (rb *recordBuilder) message(startLine string, header http.Header, body []byte) {
+	h := http.Header{}
+	for k, v := range header {
+		if strings.HasPrefix(k, ":") {
+			continue
+		}
+		h[k] = v
+	}
+	rb.rw.message(startLine, h, false, int64(len(body)), false, body, nil)
+}
+
+// request appends a request. The URL may be absolute, in which case it is written in origin form along with the Host header.
+func (rb *recordBuilder) request(method, rawURL, proto string, header http.Header, body []byte) error {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return fmt.Errorf("invalid request: %w", err)
+	}
+	if req.URL.Host != "" && header.Get("Host") == "" {
+		header = header.Clone()
+		header.Set("Host", req.URL.Host)
+	}
+	rb.message(fmt.Sprintf("%s %s %s", method, req.URL.RequestURI(), normalizeHTTPVersion(proto)), header, body)
+	return nil
+}
+
+func (rb *recordBuilder) response(proto string, status int, statusText string, header http.Header, body []byte) {
+	if statusText == "" {
+		statusText = http.StatusText(status)
+	}
+	rb.message(fmt.Sprintf("%s %03d %s", normalizeHTTPVersion(proto), status, statusText), header, body)
+}
+
+// exchange appends a request and a response parsed out of their wire format, whose bodies have been read separately.
+func (rb *recordBuilder) exchange(req *http.Request, reqBody []byte, res *http.Response, resBody []byte) error {
+	reqHeader := req.Header.Clone()
+	if req.Host != "" {
+		reqHeader.Set("Host", req.Host)
+	}
+	if err := rb.request(req.Method, req.RequestURI, req.Proto, reqHeader, reqBody); err != nil {
+		return err
+	}
+	statusText := strings.TrimSpace(strings.TrimPrefix(res.Status, strconv.Itoa(res.StatusCode)))
+	rb.response(res.Proto, res.StatusCode, statusText, res.Header, resBody)
+	return nil
+}
+
+// readPayload reads the body of a parsed message, which may have been truncated by the capturing tool, hence a short read is not an error.
+func readPayload(body io.ReadCloser) ([]byte, error) {
+	defer body.Close()
+	b, err := io.ReadAll(body)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return b, err
+}
+
+// build appends the context and returns the record.
+func (rb *recordBuilder) build(ctx *httpRecordedMessageContext) (r io.Reader, err error) {
+	if err = rb.rw.writeContext(ctx); err != nil {
+		return
+	}
+	return &rb.buf, nil
+}
+
+// recordTarget returns the upstream target of the context for the given server IP address, keeping the scheme and port of the requested URL
+// so that the destination port can be resolved, or nil when the IP address is unknown.
+func recordTarget(u *url.URL, ip string) *string {
+	ip = strings.Trim(ip, "[]")
+	if ip == "" {
+		return nil
+	}
+
+	host := ip
+	switch {
+	case u.Port() != "":
+		host = net.JoinHostPort(ip, u.Port())
+	case strings.Contains(ip, ":"):
+		host = "[" + ip + "]"
+	}
+	target := (&url.URL{Scheme: u.Scheme, Host: host}).String()
+	return &target
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const recordDelimiterPrefix = "EOF-----------------"
+
+// recordWriter writes a record in the format parsed by httpRecordedMessage:
+// a delimiter line, then each message followed by the delimiter line, then the context.
+// Bodies are written decoded from their chunked framing, which is kept announced in the header, and followed by their trailers.
+type recordWriter struct {
+	w         io.Writer
+	delimiter string
+}
+
+func newRecordWriter(w io.Writer) (*recordWriter, error) {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	rw := &recordWriter{w: w, delimiter: recordDelimiterPrefix + hex.EncodeToString(b)}
+	if _, err := io.WriteString(w, rw.delimiter+"\r\n\r\n"); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+func isChunked(te []string, header http.Header) bool {
+	for _, v := range append(te, header.Values("Transfer-Encoding")...) {
+		for _, v := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), "chunked") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// message writes a message whose header is written as is, except for the framing of its body:
+// a chunked body is announced through Transfer-Encoding, otherwise Content-Length is set to the length of the body,
+// unless the body is absent by definition, e.g. in response to a HEAD request, in which case the given length is kept.
+func (rw *recordWriter) message(startLine string, header http.Header, chunked bool, contentLength int64, bodiless bool, body []byte, trailer http.Header) (err error) {
+	chunked = chunked && len(body) > 0 // the parser expects at least a chunk
+
+	h := header.Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	h.Del("Transfer-Encoding")
+	h.Del("Trailer")
+	switch {
+	case chunked:
+		h.Del("Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+		if len(trailer) > 0 {
+			keys := make([]string, 0, len(trailer))
+			for k := range trailer {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			h.Set("Trailer", strings.Join(keys, ", "))
+		}
+	case bodiless && len(body) == 0:
+		if contentLength > 0 {
+			h.Set("Content-Length", strconv.FormatInt(contentLength, 10))
+		}
+	default:
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString(startLine + "\r\n")
+	h.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	buf.WriteString("\r\n" + rw.delimiter + "\r\n")
+	if chunked {
+		trailer.Write(&buf)
+	}
+	buf.WriteString("\r\n")
+
+	_, err = buf.WriteTo(rw.w)
+	return
+}
+
+// readBody reads and closes the body, after which the trailer is available.
+func readBody(body io.ReadCloser) (b []byte, err error) {
+	if body == nil || body == http.NoBody {
+		return
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// writeRequest writes the request, consuming its body. The URL is written in origin form, along with the Host header.
+func (rw *recordWriter) writeRequest(req *http.Request) error {
+	body, err := readBody(req.Body)
+	if err != nil {
+		return fmt.Errorf("error reading request body: %w", err)
+	}
+
+	header := req.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	host := req.Host
+	if host == "" && req.URL != nil {
+		host = req.URL.Host
+	}
+	if host != "" {
+		header.Set("Host", host)
+	}
+
+	uri := req.RequestURI
+	if uri == "" || strings.Contains(uri, "://") {
+		uri = req.URL.RequestURI()
+	}
+	startLine := fmt.Sprintf("%s %s %s", req.Method, uri, normalizeHTTPVersion(req.Proto))
+	return rw.message(startLine, header, isChunked(req.TransferEncoding, req.Header), req.ContentLength, false, body, req.Trailer)
+}
+
+// writeResponse writes the response, consuming its body.
+func (rw *recordWriter) writeResponse(res *http.Response) error {
+	body, err := readBody(res.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %w", err)
+	}
+
+	statusText := strings.TrimSpace(strings.TrimPrefix(res.Status, strconv.Itoa(res.StatusCode)))
+	if statusText == "" {
+		statusText = http.StatusText(res.StatusCode)
+	}
+	bodiless := res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotModified ||
+		(res.Request != nil && res.Request.Method == http.MethodHead)
+	startLine := fmt.Sprintf("%s %03d %s", normalizeHTTPVersion(res.Proto), res.StatusCode, statusText)
+	return rw.message(startLine, res.Header, isChunked(res.TransferEncoding, res.Header), res.ContentLength, bodiless, body, res.Trailer)
+}
+
+// writeContext writes the context, which ends the record.
+func (rw *recordWriter) writeContext(ctx *httpRecordedMessageContext) error {
+	if err := json.NewEncoder(rw.w).Encode(ctx); err != nil {
+		return fmt.Errorf("error encoding context: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testResponse returns a response to the request whose body is framed by its length.
+func testResponse(req *http.Request, code int, header http.Header, body []byte) *http.Response {
+	return &http.Response{
+		StatusCode: code, Proto: "HTTP/1.1",
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// testRecord writes a record of the exchange and of the exchanges of the following responses, then the context.
+func testRecord(t *testing.T, req *http.Request, res *http.Response, ctx *httpRecordedMessageContext, more ...*http.Response) *bytes.Buffer {
+	buf := bytes.Buffer{}
+	rw, err := newRecordWriter(&buf)
+	require.NoError(t, err)
+	require.NoError(t, rw.writeRequest(req))
+	require.NoError(t, rw.writeResponse(res))
+	for _, res := range more {
+		require.NoError(t, rw.writeRequest(res.Request))
+		require.NoError(t, rw.writeResponse(res))
+	}
+	require.NoError(t, rw.writeContext(ctx))
+	return &buf
+}
+
+func TestRecordWriter(t *testing.T) {
+	rawReq := "POST /upload?x=1 HTTP/1.1\r\nHost: api.example.com\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n" +
+		"7\r\nhello\r\n\r\n6\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n"
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(rawReq)))
+	require.NoError(t, err)
+	res := &http.Response{
+		Status: "201 Created", StatusCode: 201, Proto: "HTTP/1.1",
+		Header:        http.Header{"Content-Type": {"text/plain"}, "Content-Length": {"999"}},
+		Body:          io.NopCloser(strings.NewReader("created")),
+		ContentLength: 999,
+	}
+	head := &http.Response{
+		StatusCode: 200, Proto: "HTTP/1.1",
+		Header:        http.Header{"Content-Length": {"42"}},
+		Body:          http.NoBody,
+		ContentLength: 42,
+		Request:       httptest.NewRequest(http.MethodHead, "http://api.example.com/upload", nil),
+	}
+
+	msg := newHTTPRecordedMessage(testRecord(t, req, res, &httpRecordedMessageContext{ID: "written"}, head))
+	defer msg.Close()
+	parsedReq, err := msg.Request()
+	require.NoError(t, err, "should parse written request")
+	assert.Equal(t, "/upload?x=1", parsedReq.RequestURI)
+	assert.Equal(t, "api.example.com", parsedReq.Host)
+	body, err := io.ReadAll(parsedReq.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello\r\n world", string(body), "should keep the chunked body")
+	assert.Equal(t, "abc", parsedReq.Trailer.Get("X-Checksum"), "should keep the trailer")
+
+	parsedRes, err := msg.Response()
+	require.NoError(t, err, "should parse written response")
+	assert.Equal(t, 201, parsedRes.StatusCode)
+	body, err = io.ReadAll(parsedRes.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "created", string(body), "should frame the body by its actual length")
+
+	msg, err = msg.Next()
+	require.NoError(t, err)
+	require.NotNil(t, msg, "should parse the following exchange")
+	parsedReq, err = msg.Request()
+	require.NoError(t, err)
+	assert.Equal(t, http.MethodHead, parsedReq.Method)
+	parsedRes, err = msg.Response()
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), parsedRes.ContentLength, "should keep the length of bodiless response")
+
+	msg, err = msg.Next()
+	require.NoError(t, err)
+	assert.Nil(t, msg)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// recordSink creates the destination of each record.
+type recordSink interface {
+	create(ctx context.Context, id string) (io.WriteCloser, error)
+}
+
+// recordDirSink writes each record into its own file within a directory.
+type recordDirSink string
+
+func (d recordDirSink) create(ctx context.Context, id string) (io.WriteCloser, error) {
+	return os.Create(filepath.Join(string(d), id+".txt"))
+}
+
+// recordS3Sink uploads each record as an object once it is complete.
+type recordS3Sink struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+type recordS3Object struct {
+	bytes.Buffer
+	ctx  context.Context
+	sink *recordS3Sink
+	key  string
+}
+
+func (s *recordS3Sink) create(ctx context.Context, id string) (io.WriteCloser, error) {
+	return &recordS3Object{ctx: ctx, sink: s, key: s.prefix + id + ".txt"}, nil
+}
+
+func (o *recordS3Object) Close() error {
+	_, err := o.sink.client.PutObject(o.ctx, &s3.PutObjectInput{
+		Bucket: aws.String(o.sink.bucket),
+		Key:    aws.String(o.key),
+		Body:   bytes.NewReader(o.Bytes()),
+	})
+	return err
+}
+
+func newRecordID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// recordedExchange accumulates what is known about an exchange while it is served.
+type recordedExchange struct {
+	target        string
+	upstreamStart time.Time
+	upstreamEnd   time.Time
+}
+
+type recordedExchangeKey struct{}
+
+// recordingTransport notes the upstream target and how long it took to respond into the exchange being recorded, if any.
+type recordingTransport struct {
+	http.RoundTripper
+}
+
+func (t recordingTransport) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	x, _ := req.Context().Value(recordedExchangeKey{}).(*recordedExchange)
+	start := time.Now()
+	res, err = t.RoundTripper.RoundTrip(req)
+	if x != nil {
+		x.target, x.upstreamStart, x.upstreamEnd = req.URL.Host, start, time.Now()
+	}
+	return
+}
+
+// recordingBuffer keeps a copy of a body up to limit bytes, zero for no limit, noting whether the rest was left out.
+type recordingBuffer struct {
+	bytes.Buffer
+	limit     int64
+	truncated bool
+}
+
+func (b *recordingBuffer) keep(p []byte) {
+	if b.limit > 0 && int64(b.Len()+len(p)) > b.limit {
+		p, b.truncated = p[:b.limit-int64(b.Len())], true
+	}
+	b.Write(p)
+}
+
+// recordingBody keeps a copy of the request body as it is read by the handler.
+type recordingBody struct {
+	io.ReadCloser
+	buf recordingBuffer
+}
+
+func (b *recordingBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	b.buf.keep(p[:n])
+	return
+}
+
+// recordingResponseWriter keeps a copy of the response as it is written by the handler.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	buf      recordingBuffer
+	hijacked bool
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status, w.header = status, w.ResponseWriter.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.buf.keep(b[:n])
+	return n, err
+}
+
+func (w *recordingResponseWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack supports protocol upgrades, recorded as a switching protocols response without body.
+func (w *recordingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("hijacking is not supported")
+	}
+	if w.status == 0 {
+		w.status, w.header = http.StatusSwitchingProtocols, w.ResponseWriter.Header().Clone()
+	}
+	w.hijacked = true
+	return h.Hijack()
+}
+
+// trailer returns the trailers set by the handler, either declared beforehand or prefixed by http.TrailerPrefix.
+func (w *recordingResponseWriter) trailer() http.Header {
+	t := http.Header{}
+	final := w.ResponseWriter.Header()
+	for _, declared := range w.header.Values("Trailer") {
+		for _, k := range strings.Split(declared, ",") {
+			if k = http.CanonicalHeaderKey(strings.TrimSpace(k)); k != "" && len(final.Values(k)) > 0 {
+				t[k] = final.Values(k)
+			}
+		}
+	}
+	for k, v := range final {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			t[http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))] = v
+		}
+	}
+	return t
+}
+
+// recordingMiddleware records every exchange served by next into its own record created by the sink.
+// Bodies are kept in memory up to bodyLimit bytes each, zero for no limit, until the handler returns,
+// after which the record is written in the background so as not to delay the response.
+func recordingMiddleware(next http.Handler, sink recordSink, bodyLimit int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id, x := newRecordID(), &recordedExchange{}
+		req = req.WithContext(context.WithValue(req.Context(), recordedExchangeKey{}, x))
+		reqBody := &recordingBody{ReadCloser: req.Body, buf: recordingBuffer{limit: bodyLimit}}
+		if req.Body != nil && req.Body != http.NoBody {
+			req.Body = reqBody
+		}
+		rw := &recordingResponseWriter{ResponseWriter: w, buf: recordingBuffer{limit: bodyLimit}}
+
+		start := time.Now()
+		next.ServeHTTP(rw, req)
+		end := time.Now()
+		if rw.status == 0 && !rw.hijacked {
+			rw.WriteHeader(http.StatusOK)
+		}
+
+		recReq, res, ctx := recordedExchangeOf(id, req, reqBody, rw, x, start, end)
+		go func() {
+			if err := writeRecord(sink, id, recReq, res, ctx); err != nil {
+				log.Printf("error recording %s: %v", id, err)
+			}
+		}()
+	})
+}
+
+func recordedExchangeOf(id string, req *http.Request, reqBody *recordingBody, rw *recordingResponseWriter, x *recordedExchange, start, end time.Time) (*http.Request, *http.Response, *httpRecordedMessageContext) {
+	ctx := &httpRecordedMessageContext{
+		ID:         id,
+		Connection: &httpRecordedMessageContextConnection{Protocol: "http"},
+		Durations: &httpRecordedMessageContextDurations{
+			Total: httpRecordedMessageContextDuration{Start: start, End: &end},
+		},
+		Host: &httpRecordedMessageContextHost{Name: req.Host},
+	}
+	if req.TLS != nil {
+		ctx.Connection.Protocol = "https"
+	}
+	if host, port, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		ctx.Connection.Client.IP = net.ParseIP(host)
+		if p, err := strconv.ParseUint(port, 10, 16); err == nil {
+			ctx.Connection.Client.Port = uint16(p)
+		}
+	}
+	if username, _, ok := req.BasicAuth(); ok {
+		ctx.User = &httpRecordedMessageContextUser{Username: username}
+	}
+	if reqBody.buf.truncated || rw.buf.truncated {
+		ctx.Truncated = &httpRecordedMessageContextTruncated{Request: reqBody.buf.truncated, Response: rw.buf.truncated}
+	}
+	if x.target != "" {
+		ctx.Host.Target = &x.target
+		ctx.Durations.Proxy = &httpRecordedMessageContextDuration{Start: x.upstreamStart, End: &x.upstreamEnd}
+	}
+
+	recReq := req.Clone(context.Background())
+	recReq.Body = io.NopCloser(&reqBody.buf)
+	res := &http.Response{
+		Status:        strconv.Itoa(rw.status) + " " + http.StatusText(rw.status),
+		StatusCode:    rw.status,
+		Proto:         req.Proto,
+		Header:        rw.header,
+		Body:          io.NopCloser(&rw.buf),
+		ContentLength: -1,
+		Trailer:       rw.trailer(),
+		Request:       recReq,
+	}
+	if len(res.Trailer) > 0 {
+		res.TransferEncoding = []string{"chunked"}
+	}
+	if cl, err := strconv.ParseInt(rw.header.Get("Content-Length"), 10, 64); err == nil && !rw.buf.truncated {
+		res.ContentLength = cl
+	}
+	return recReq, res, ctx
+}
+
+// writeRecord writes a single exchange record into the sink.
+func writeRecord(sink recordSink, id string, req *http.Request, res *http.Response, ctx *httpRecordedMessageContext) (err error) {
+	f, err := sink.create(context.Background(), id)
+	if err != nil {
+		return fmt.Errorf("error creating record: %w", err)
+	}
+	defer func() {
+		if errt := f.Close(); errt != nil && err == nil {
+			err = errt
+		}
+	}()
+
+	w, err := newRecordWriter(f)
+	if err != nil {
+		return
+	}
+	if err = w.writeRequest(req); err != nil {
+		return
+	}
+	if err = w.writeResponse(res); err != nil {
+		return
+	}
+	return w.writeContext(ctx)
+}
+
+// newRecordingProxy returns a reverse proxy to the upstream recording every exchange it serves, see recordingMiddleware.
+func newRecordingProxy(upstream *url.URL, sink recordSink, bodyLimit int64) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	proxy.Transport = recordingTransport{http.DefaultTransport}
+	return recordingMiddleware(proxy, sink, bodyLimit)
+}
+
+// newRecordSink returns the sink configured for the recorder, i.e. a directory or otherwise the S3 bucket.
+func newRecordSink(cfg *config) (recordSink, error) {
+	if cfg.Recorder.Dir != "" {
+		if err := os.MkdirAll(cfg.Recorder.Dir, 0755); err != nil {
+			return nil, fmt.Errorf("error creating record directory: %w", err)
+		}
+		return recordDirSink(cfg.Recorder.Dir), nil
+	}
+
+	s3Client, err := newS3Client(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing s3 client: %w", err)
+	}
+	return &recordS3Sink{client: s3Client, bucket: cfg.S3.Bucket, prefix: cfg.Recorder.S3Prefix}, nil
+}
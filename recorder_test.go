@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+type testRecordSink chan []byte
+
+type testRecordFile struct {
+	bytes.Buffer
+	sink testRecordSink
+}
+
+func (s testRecordSink) create(ctx context.Context, id string) (io.WriteCloser, error) {
+	return &testRecordFile{sink: s}, nil
+}
+
+func (r *testRecordFile) Close() error {
+	r.sink <- r.Bytes()
+	return nil
+}
+
+func TestRecordingProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Trailer", "X-Elapsed")
+		w.WriteHeader(http.StatusCreated)
+		w.(http.Flusher).Flush()
+		w.Write([]byte(`{"echo":`))
+		w.Write(body)
+		w.Write([]byte(`}`))
+		w.Header().Set("X-Elapsed", "1ms")
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	sink := make(testRecordSink, 1)
+	proxy := httptest.NewServer(newRecordingProxy(upstreamURL, sink, 0))
+	defer proxy.Close()
+
+	req, err := http.NewRequest(http.MethodPost, proxy.URL+"/users?id=1", strings.NewReader(`"alice"`))
+	require.NoError(t, err)
+	req.SetBasicAuth("bob", "secret")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, `{"echo":"alice"}`, string(body), "should proxy the response")
+
+	var record []byte
+	select {
+	case record = <-sink:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "should write the record")
+	}
+
+	assert.Contains(t, string(record), "X-Elapsed: 1ms", "should record the trailer")
+
+	ercr, err := newEnricher()
+	require.NoError(t, err)
+	erc, err := ercr.EnrichRecord(bytes.NewReader(record))
+	require.NoError(t, err, "should enrich the written record")
+	defer erc.Close()
+	docs, err := erc.toECS()
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	doc := docs[0]
+
+	assert.Equal(t, "/users", doc.URL.Path)
+	assert.Equal(t, `"alice"`, doc.HTTP.Request.Body.Content)
+	assert.Equal(t, 201, doc.HTTP.Response.StatusCode)
+	assert.Equal(t, `{"echo":"alice"}`, doc.HTTP.Response.Body.Content)
+	assert.Equal(t, "bob", doc.User.Name)
+	assert.Equal(t, "127.0.0.1", doc.Source.Address)
+	assert.NotZero(t, doc.Source.Port)
+	assert.Equal(t, upstreamURL.Port(), strconv.Itoa(int(doc.Destination.Port)))
+	require.NotNil(t, doc.Latency)
+	assert.NotNil(t, doc.Latency.Upstream, "should measure the upstream")
+}
+
+func TestRecordingMiddlewareBodyLimit(t *testing.T) {
+	sink := make(testRecordSink, 1)
+	server := httptest.NewServer(recordingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		w.Header().Set("Content-Length", strconv.Itoa(2*len(body)))
+		w.Write(body)
+		w.Write(body)
+	}), sink, 4))
+	defer server.Close()
+
+	res, err := http.Post(server.URL+"/upload", "text/plain", strings.NewReader("0123456789"))
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "01234567890123456789", string(body), "should proxy the whole response")
+
+	var record []byte
+	select {
+	case record = <-sink:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "should write the record")
+	}
+
+	ercr, err := newEnricher()
+	require.NoError(t, err)
+	erc, err := ercr.EnrichRecord(bytes.NewReader(record))
+	require.NoError(t, err, "should enrich the truncated record")
+	defer erc.Close()
+	docs, err := erc.toECS()
+	require.NoError(t, err)
+	doc := docs[0]
+
+	assert.Equal(t, "0123", doc.HTTP.Request.Body.Content)
+	assert.Equal(t, "0123", doc.HTTP.Response.Body.Content)
+	for _, l := range []*ecsx.BodyLimit{doc.HTTP.Request.Limited, doc.HTTP.Response.Limited} {
+		require.NotNil(t, l)
+		assert.Equal(t, &ecsx.BodyLimit{Reason: "recording", ProcessedBytes: 4}, l)
+	}
+	assert.Contains(t, doc.Tags, "truncated")
+}
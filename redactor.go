@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/telkomindonesia/httpmsg-enricher/ecs"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+const (
+	redactedValue = "[REDACTED]"
+
+	// redactedValueMinLen is the length below which a redacted value is not looked for in threats, where it would mostly match unrelated text.
+	redactedValueMinLen = 4
+)
+
+// errorLogValue matches the values of an error log which may hold data of the message, i.e. the data matched by a rule and the URI.
+var errorLogValue = regexp.MustCompile(`\[(data|uri) ("(?:[^"\\]|\\.)*")\]`)
+
+type redactMode string
+
+const (
+	redactModeMask redactMode = "mask"
+	redactModeHash redactMode = "hash"
+)
+
+type redactor struct {
+	mode     redactMode
+	headers  map[string]struct{}
+	fields   []string
+	patterns []*regexp.Regexp
+
+	jsonFieldPatterns []*regexp.Regexp
+
+	redacted map[string]struct{} // values redacted from the document being redacted, which are then looked for in threats
+}
+
+func newRedactor(mode string, headers, fields, patterns []string) (rdc *redactor, err error) {
+	rdc = &redactor{
+		mode:    redactMode(strings.ToLower(mode)),
+		headers: map[string]struct{}{},
+	}
+	switch rdc.mode {
+	case "":
+		rdc.mode = redactModeMask
+	case redactModeMask, redactModeHash:
+	default:
+		return nil, fmt.Errorf("unknown redaction mode: %s", mode)
+	}
+
+	for _, h := range headers {
+		if h = strings.TrimSpace(h); h != "" {
+			rdc.headers[strings.ToLower(h)] = struct{}{}
+		}
+	}
+	for _, f := range fields {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		f = strings.ToLower(f)
+		rdc.fields = append(rdc.fields, f)
+
+		if i := strings.LastIndex(f, "."); i >= 0 {
+			f = f[i+1:]
+		}
+		re := regexp.MustCompile(`(?i)("` + regexp.QuoteMeta(f) + `"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`)
+		rdc.jsonFieldPatterns = append(rdc.jsonFieldPatterns, re)
+	}
+	for _, p := range patterns {
+		if p == "" {
+			continue
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling redaction pattern %q: %w", p, err)
+		}
+		rdc.patterns = append(rdc.patterns, re)
+	}
+	return
+}
+
+func (rdc *redactor) value(v string) string {
+	if rdc.redacted != nil && len(v) >= redactedValueMinLen {
+		rdc.redacted[v] = struct{}{}
+	}
+	if rdc.mode != redactModeHash {
+		return redactedValue
+	}
+	return ShortHash(v)
+}
+
+func (rdc *redactor) redactPatterns(s string) string {
+	for _, re := range rdc.patterns {
+		s = re.ReplaceAllStringFunc(s, rdc.value)
+	}
+	return s
+}
+
+// matchField reports whether the given path matches one of the configured fields.
+// A field containing a dot is matched against the whole path, otherwise against the last element only.
+func (rdc *redactor) matchField(path []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	full := strings.ToLower(strings.Join(path, "."))
+	last := strings.ToLower(path[len(path)-1])
+	for _, f := range rdc.fields {
+		if f == full || (!strings.Contains(f, ".") && f == last) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rdc *redactor) redactHeaders(h map[string][]string) {
+	for k, vs := range h {
+		_, sensitive := rdc.headers[strings.ToLower(k)]
+		nvs := make([]string, len(vs)) // values might still be shared with the original http.Header
+		for i, v := range vs {
+			if sensitive {
+				nvs[i] = rdc.value(v)
+				continue
+			}
+			nvs[i] = rdc.redactPatterns(v)
+		}
+		h[k] = nvs
+	}
+}
+
+func (rdc *redactor) redactValues(vs url.Values) {
+	for k, v := range vs {
+		for i := range v {
+			if rdc.matchField([]string{k}) {
+				v[i] = rdc.value(v[i])
+				continue
+			}
+			v[i] = rdc.redactPatterns(v[i])
+		}
+	}
+}
+
+func (rdc *redactor) redactQuery(q string) string {
+	if q == "" {
+		return q
+	}
+	vs, err := url.ParseQuery(q)
+	if err != nil {
+		return rdc.redactPatterns(q)
+	}
+	rdc.redactValues(vs)
+	return vs.Encode()
+}
+
+func (rdc *redactor) redactURL(s string) string {
+	if s == "" {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return rdc.redactPatterns(s)
+	}
+	if u.User != nil {
+		if p, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), rdc.value(p))
+		}
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = rdc.redactQuery(u.RawQuery)
+	}
+	return rdc.redactPatterns(u.String())
+}
+
+func (rdc *redactor) redactJSON(v interface{}, path []string) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			p := append(append([]string(nil), path...), k)
+			if rdc.matchField(p) {
+				t[k] = rdc.value(fmt.Sprint(e))
+				continue
+			}
+			t[k] = rdc.redactJSON(e, p)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = rdc.redactJSON(e, path)
+		}
+	case string:
+		return rdc.redactPatterns(t)
+	}
+	return v
+}
+
+// redactTruncatedJSON is used for JSON body which can not be decoded, e.g. due to truncation.
+// Since path is unknown, only the last element of the configured fields is matched.
+func (rdc *redactor) redactTruncatedJSON(s string) string {
+	for _, re := range rdc.jsonFieldPatterns {
+		s = re.ReplaceAllStringFunc(s, func(m string) string {
+			sm := re.FindStringSubmatch(m)
+			return sm[1] + `"` + rdc.value(strings.Trim(sm[2], `"`)) + `"`
+		})
+	}
+	return s
+}
+
+func (rdc *redactor) redactBody(content string, contentType string) string {
+	if content == "" {
+		return content
+	}
+
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mt == "application/x-www-form-urlencoded":
+		content = rdc.redactQuery(content)
+
+	case mt == "application/json" || strings.HasSuffix(mt, "+json"):
+		var v interface{}
+		d := json.NewDecoder(strings.NewReader(content))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			content = rdc.redactTruncatedJSON(content)
+			break
+		}
+		var b bytes.Buffer
+		e := json.NewEncoder(&b)
+		e.SetEscapeHTML(false)
+		if err := e.Encode(rdc.redactJSON(v, nil)); err != nil {
+			content = rdc.redactTruncatedJSON(content)
+			break
+		}
+		content = strings.TrimSuffix(b.String(), "\n")
+	}
+	return rdc.redactPatterns(content)
+}
+
+// redactCapturedBody redacts the content as captured, i.e. decoding it beforehand when captured in base64.
+func (rdc *redactor) redactCapturedBody(content string, contentType string, capture *ecsx.BodyCapture) string {
+	if capture == nil || capture.Encoding != "base64" {
+		return rdc.redactBody(content, contentType)
+	}
+	b, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return content
+	}
+	return base64.StdEncoding.EncodeToString([]byte(rdc.redactBody(string(b), contentType)))
+}
+
+func (rdc *redactor) redactParsedBody(b *ecsx.Body) {
+	if b == nil {
+		return
+	}
+	if b.JSON != nil {
+		b.JSON = rdc.redactJSON(b.JSON, nil)
+	}
+	rdc.redactValues(b.Form)
+	for i, p := range b.Parts {
+		if rdc.matchField([]string{p.Name}) && p.Value != "" {
+			b.Parts[i].Value = rdc.value(p.Value)
+			continue
+		}
+		b.Parts[i].Value = rdc.redactPatterns(p.Value)
+	}
+}
+
+// redactGRPCMessages redacts the fields of the messages decoded from a gRPC call as JSON objects.
+func (rdc *redactor) redactGRPCMessages(m *ecsx.GRPCMessages) {
+	for i, raw := range m.Decoded {
+		var v interface{}
+		d := json.NewDecoder(bytes.NewReader(raw))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			continue
+		}
+		var b bytes.Buffer
+		e := json.NewEncoder(&b)
+		e.SetEscapeHTML(false)
+		if err := e.Encode(rdc.redactJSON(v, nil)); err != nil {
+			continue
+		}
+		m.Decoded[i] = bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+	}
+}
+
+// sensitiveMatchField reports whether the field of a threat match, named like ARGS:password or REQUEST_HEADERS:Authorization, holds a sensitive value.
+func (rdc *redactor) sensitiveMatchField(field string) bool {
+	variable, key, ok := strings.Cut(field, ":")
+	if !ok || key == "" {
+		return false
+	}
+	switch {
+	case strings.HasSuffix(variable, "HEADERS"):
+		_, ok = rdc.headers[strings.ToLower(key)]
+		return ok
+	case strings.HasSuffix(variable, "COOKIES"):
+		if _, ok = rdc.headers["cookie"]; ok {
+			return true
+		}
+	}
+	return rdc.matchField(strings.Split(strings.TrimPrefix(key, "json."), "."))
+}
+
+// redactErrorLog redacts the data and URI of an error log, the data being redacted entirely when matched within a sensitive field.
+func (rdc *redactor) redactErrorLog(s string, sensitive bool) string {
+	s = errorLogValue.ReplaceAllStringFunc(s, func(m string) string {
+		sm := errorLogValue.FindStringSubmatch(m)
+		v, err := strconv.Unquote(sm[2])
+		if err != nil {
+			return fmt.Sprintf("[%s %q]", sm[1], rdc.value(sm[2]))
+		}
+		v = rdc.redactRedacted(v)
+		switch {
+		case sm[1] == "uri":
+			v = rdc.redactURL(v)
+		case sensitive:
+			v = rdc.value(v)
+		}
+		return fmt.Sprintf("[%s %q]", sm[1], v)
+	})
+	return rdc.redactPatterns(rdc.redactRedacted(s))
+}
+
+// redactRedacted redacts the values which were redacted elsewhere in the document, longest first.
+func (rdc *redactor) redactRedacted(s string) string {
+	vs := make([]string, 0, len(rdc.redacted))
+	for v := range rdc.redacted {
+		vs = append(vs, v)
+	}
+	sort.Slice(vs, func(i, j int) bool { return len(vs[i]) > len(vs[j]) })
+	for _, v := range vs {
+		s = strings.ReplaceAll(s, v, rdc.value(v))
+	}
+	return s
+}
+
+func (rdc *redactor) redactThreat(t *ecs.Threat) {
+	if t == nil {
+		return
+	}
+	for i := range t.Enrichments {
+		e := &t.Enrichments[i]
+		sensitive := e.Match != nil && rdc.sensitiveMatchField(e.Match.Field)
+		e.Indicator.Description = rdc.redactErrorLog(e.Indicator.Description, sensitive)
+		if e.Match == nil {
+			continue
+		}
+		if sensitive && e.Match.Atomic != "" {
+			e.Match.Atomic = rdc.value(e.Match.Atomic)
+			continue
+		}
+		e.Match.Atomic = rdc.redactPatterns(rdc.redactRedacted(e.Match.Atomic))
+	}
+}
+
+func firstHeader(h map[string][]string, key string) string {
+	if v := h[strings.ToLower(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (rdc *redactor) redactECSURL(u *ecs.URL) {
+	if u == nil {
+		return
+	}
+	u.Full = rdc.redactURL(u.Full)
+	u.Original = rdc.redactURL(u.Original)
+	u.Query = rdc.redactQuery(u.Query)
+	if u.Password != "" {
+		u.Password = rdc.value(u.Password)
+	}
+}
+
+func (rdc *redactor) redact(doc *ecsx.Document) {
+	r := *rdc
+	r.redacted = map[string]struct{}{}
+	rdc = &r
+
+	rdc.redactECSURL(doc.URL)
+
+	if doc.HTTP != nil && doc.HTTP.Request != nil {
+		req := doc.HTTP.Request
+		ct := firstHeader(req.Headers, "Content-Type")
+		rdc.redactHeaders(req.Headers)
+		req.Referrer = rdc.redactURL(req.Referrer)
+		rdc.redactECSURL(req.ReferrerURL)
+		if req.Body != nil {
+			req.Body.Content = rdc.redactCapturedBody(req.Body.Content, ct, req.Capture)
+		}
+		rdc.redactParsedBody(req.ParsedBody)
+	}
+	if doc.HTTP != nil && doc.HTTP.Response != nil {
+		res := doc.HTTP.Response
+		ct := firstHeader(res.Headers, "Content-Type")
+		rdc.redactHeaders(res.Headers)
+		if res.Body != nil {
+			res.Body.Content = rdc.redactCapturedBody(res.Body.Content, ct, res.Capture)
+		}
+		rdc.redactParsedBody(res.ParsedBody)
+	}
+	if doc.GRPC != nil {
+		rdc.redactGRPCMessages(&doc.GRPC.Request)
+		rdc.redactGRPCMessages(&doc.GRPC.Response)
+	}
+
+	if doc.OpenAPI != nil {
+		for i := range doc.OpenAPI.Violations {
+			v := &doc.OpenAPI.Violations[i]
+			v.Message = rdc.redactPatterns(rdc.redactRedacted(v.Message))
+		}
+	}
+
+	rdc.redactThreat(doc.Threat)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net"
+
+	"github.com/telkomindonesia/httpmsg-enricher/ecs"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+type relatedBuilder struct {
+	related ecs.Related
+	seen    map[string]bool
+}
+
+func (rb *relatedBuilder) add(l *[]string, kind string, values ...string) {
+	for _, v := range values {
+		if v == "" || rb.seen[kind+v] {
+			continue
+		}
+		rb.seen[kind+v] = true
+		*l = append(*l, v)
+	}
+}
+
+func (rb *relatedBuilder) addIP(ips ...net.IP) {
+	for _, ip := range ips {
+		if ip == nil || rb.seen["ip"+ip.String()] {
+			continue
+		}
+		rb.seen["ip"+ip.String()] = true
+		rb.related.IP = append(rb.related.IP, ip)
+	}
+}
+
+func (rb *relatedBuilder) addEndpoint(e *ecs.Endpoint) {
+	if e == nil {
+		return
+	}
+	rb.addIP(e.IP)
+	if e.NAT != nil {
+		rb.addIP(e.NAT.IP)
+	}
+	rb.add(&rb.related.Hosts, "host", e.Domain)
+	rb.add(&rb.related.User, "user", e.User.Name)
+}
+
+// relatedOf aggregates the IPs, users, hosts and hashes found throughout the document into related.* fields.
+func relatedOf(doc *ecsx.Document) *ecs.Related {
+	rb := &relatedBuilder{seen: map[string]bool{}}
+
+	for _, e := range []*ecs.Endpoint{doc.Client, doc.Source, doc.Server, doc.Destination} {
+		rb.addEndpoint(e)
+	}
+	if doc.URL != nil {
+		rb.add(&rb.related.Hosts, "host", doc.URL.Domain)
+		rb.add(&rb.related.User, "user", doc.URL.Username)
+	}
+	if doc.HTTP != nil && doc.HTTP.Request != nil && doc.HTTP.Request.ReferrerURL != nil {
+		rb.add(&rb.related.Hosts, "host", doc.HTTP.Request.ReferrerURL.Domain)
+	}
+	if doc.User != nil {
+		rb.add(&rb.related.User, "user", doc.User.Name)
+	}
+	for _, f := range doc.Files {
+		if f.Hash != nil {
+			rb.add(&rb.related.Hash, "hash", f.Hash.MD5, f.Hash.SHA1, f.Hash.SHA256, f.Hash.SHA512)
+		}
+	}
+
+	if len(rb.related.IP)+len(rb.related.Hosts)+len(rb.related.User)+len(rb.related.Hash) == 0 {
+		return nil
+	}
+	return &rb.related
+}
@@ -0,0 +1,893 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file implements a subset of YARA rule language, sufficient for content signatures:
+//
+//	rule php_webshell : webshell php
+//	{
+//	    meta:
+//	        description = "PHP web shell"
+//	    strings:
+//	        $a = "eval(" nocase
+//	        $b = { 3C 3F 70 68 70 [0-16] 28 ?? }
+//	        $c = /base64_decode\s*\(/i
+//	    condition:
+//	        $a and ($b or #c > 2) or 2 of ($a, $b*)
+//	}
+//
+// Supported string modifiers are nocase, fullword, ascii and wide. Modules, external variables, and offset based
+// conditions are not supported.
+
+type signatureRule struct {
+	Name string
+	Tags []string
+	Meta map[string]string
+
+	strings   []*signatureString
+	condition signatureExpr
+}
+
+type signatureString struct {
+	id string
+	m  signatureMatcher
+}
+
+type signatureMatcher interface {
+	// findAll returns the start and end offsets of all non-overlapping matches in data
+	findAll(data []byte) [][2]int
+}
+
+func loadSignatureRules(dir string) (rules []*signatureRule, err error) {
+	files := []string{}
+	for _, ext := range []string{"*.yar", "*.yara"} {
+		m, err := filepath.Glob(filepath.Join(dir, ext))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, m...)
+	}
+
+	names := map[string]string{}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("error reading signature file %s: %w", f, err)
+		}
+		rs, err := parseSignatureRules(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing signature file %s: %w", f, err)
+		}
+		for _, r := range rs {
+			if prev, ok := names[r.Name]; ok {
+				return nil, fmt.Errorf("duplicate signature rule %s in %s, previously defined in %s", r.Name, f, prev)
+			}
+			names[r.Name] = f
+		}
+		rules = append(rules, rs...)
+	}
+	return
+}
+
+// matcher
+
+type textMatcher struct {
+	pattern  []byte
+	nocase   bool
+	fullword bool
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+// asciiLower folds the case of ASCII letters only, unlike bytes.ToLower which changes the length of invalid UTF-8, hence the offsets of matches.
+func asciiLower(b []byte) []byte {
+	l := make([]byte, len(b))
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		l[i] = c
+	}
+	return l
+}
+
+func (m textMatcher) findAll(data []byte) (res [][2]int) {
+	if len(m.pattern) == 0 {
+		return
+	}
+	if m.nocase {
+		data = asciiLower(data)
+	}
+	for i := 0; i <= len(data)-len(m.pattern); {
+		j := bytes.Index(data[i:], m.pattern)
+		if j < 0 {
+			break
+		}
+		start, end := i+j, i+j+len(m.pattern)
+		if m.fullword && ((start > 0 && isWordByte(data[start-1])) || (end < len(data) && isWordByte(data[end]))) {
+			i = start + 1
+			continue
+		}
+		res = append(res, [2]int{start, end})
+		i = end
+	}
+	return
+}
+
+type regexMatcher struct {
+	re *regexp.Regexp
+}
+
+func (m regexMatcher) findAll(data []byte) (res [][2]int) {
+	for _, idx := range m.re.FindAllIndex(data, -1) {
+		res = append(res, [2]int{idx[0], idx[1]})
+	}
+	return
+}
+
+// hexToken is either a byte with mask, or a jump of min to max (-1 for unbounded) arbitrary bytes.
+type hexToken struct {
+	value, mask byte
+	jump        bool
+	min, max    int
+}
+
+type hexMatcher struct {
+	alternatives [][]hexToken
+}
+
+// hexMatch matches a sequence of tokens against data, remembering the positions at which the rest of the sequence does not match,
+// which hold regardless of where the match started. Jumps are bounded by the window of the scanner, beyond which matches are not looked for.
+type hexMatch struct {
+	seq    []hexToken
+	data   []byte
+	failed []uint64 // bit set by token index and position
+}
+
+func newHexMatch(seq []hexToken, data []byte) *hexMatch {
+	return &hexMatch{seq: seq, data: data, failed: make([]uint64, (len(seq)*(len(data)+1)+63)/64)}
+}
+
+// at returns the end of the match of seq[k:] starting at pos, or -1.
+func (hm *hexMatch) at(k, pos int) (end int) {
+	if k == len(hm.seq) {
+		return pos
+	}
+	bit := k*(len(hm.data)+1) + pos
+	if hm.failed[bit/64]&(1<<(bit%64)) != 0 {
+		return -1
+	}
+
+	end = -1
+	t := hm.seq[k]
+	if !t.jump {
+		if pos < len(hm.data) && hm.data[pos]&t.mask == t.value {
+			end = hm.at(k+1, pos+1)
+		}
+	} else {
+		max := t.max
+		if max < 0 || max > signatureScannerWindow {
+			max = signatureScannerWindow
+		}
+		if pos+max > len(hm.data) {
+			max = len(hm.data) - pos
+		}
+		for n := t.min; n <= max && end < 0; n++ {
+			end = hm.at(k+1, pos+n)
+		}
+	}
+	if end < 0 {
+		hm.failed[bit/64] |= 1 << (bit % 64)
+	}
+	return
+}
+
+func (m hexMatcher) findAll(data []byte) (res [][2]int) {
+	matches := make([]*hexMatch, len(m.alternatives))
+	for i, seq := range m.alternatives {
+		matches[i] = newHexMatch(seq, data)
+	}
+	for i := 0; i < len(data); {
+		end := -1
+		for _, hm := range matches {
+			if end = hm.at(0, i); end >= 0 {
+				break
+			}
+		}
+		if end < 0 {
+			i++
+			continue
+		}
+		res = append(res, [2]int{i, end})
+		if end == i {
+			end++
+		}
+		i = end
+	}
+	return
+}
+
+func parseHexString(s string) (m hexMatcher, err error) {
+	alts, rest, err := parseHexAlternatives(strings.TrimSpace(s), false)
+	if err != nil {
+		return m, err
+	}
+	if rest != "" {
+		return m, fmt.Errorf("unexpected %q in hex string", rest)
+	}
+	for _, alt := range alts {
+		if len(alt) == 0 || alt[0].jump || alt[len(alt)-1].jump {
+			return m, fmt.Errorf("hex string can not be empty, start or end with a jump")
+		}
+	}
+	return hexMatcher{alternatives: alts}, nil
+}
+
+// parseHexAlternatives parses hex tokens until the end of s, or until the closing parenthesis when nested,
+// expanding alternations into separate token sequences.
+func parseHexAlternatives(s string, nested bool) (alts [][]hexToken, rest string, err error) {
+	alts = [][]hexToken{{}}
+	cur := []int{0} // indexes of alternatives currently being built
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		switch {
+		case s == "":
+			if nested {
+				return nil, s, fmt.Errorf("unclosed alternation in hex string")
+			}
+			return alts, s, nil
+
+		case s[0] == ')':
+			if !nested {
+				return nil, s, fmt.Errorf("unexpected ')' in hex string")
+			}
+			return alts, s[1:], nil
+
+		case s[0] == '|':
+			if !nested {
+				return nil, s, fmt.Errorf("unexpected '|' outside alternation in hex string")
+			}
+			alts = append(alts, []hexToken{})
+			cur = []int{len(alts) - 1}
+			s = s[1:]
+
+		case s[0] == '(':
+			var sub [][]hexToken
+			if sub, s, err = parseHexAlternatives(s[1:], true); err != nil {
+				return nil, s, err
+			}
+			next := []int{}
+			for _, c := range cur {
+				base := alts[c]
+				for i, sa := range sub {
+					seq := append(append([]hexToken{}, base...), sa...)
+					if i == 0 {
+						alts[c] = seq
+						next = append(next, c)
+						continue
+					}
+					alts = append(alts, seq)
+					next = append(next, len(alts)-1)
+				}
+			}
+			cur = next
+
+		case s[0] == '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, s, fmt.Errorf("unclosed jump in hex string")
+			}
+			t := hexToken{jump: true, max: -1}
+			spec := strings.TrimSpace(s[1:end])
+			lo, hi, isRange := strings.Cut(spec, "-")
+			if t.min, err = atoiDefault(strings.TrimSpace(lo), 0); err != nil {
+				return nil, s, fmt.Errorf("invalid jump %q in hex string", spec)
+			}
+			switch {
+			case !isRange:
+				t.max = t.min
+			case strings.TrimSpace(hi) != "":
+				if t.max, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil || t.max < t.min {
+					return nil, s, fmt.Errorf("invalid jump %q in hex string", spec)
+				}
+			}
+			for _, c := range cur {
+				alts[c] = append(alts[c], t)
+			}
+			s = s[end+1:]
+
+		default:
+			if len(s) < 2 {
+				return nil, s, fmt.Errorf("incomplete byte %q in hex string", s)
+			}
+			t := hexToken{}
+			for i, shift := range []uint{4, 0} {
+				c := s[i]
+				if c == '?' {
+					continue
+				}
+				v, err := strconv.ParseUint(string(c), 16, 8)
+				if err != nil {
+					return nil, s, fmt.Errorf("invalid byte %q in hex string", s[:2])
+				}
+				t.value |= byte(v) << shift
+				t.mask |= 0xF << shift
+			}
+			if t.mask == 0 {
+				t = hexToken{jump: true, min: 1, max: 1}
+			}
+			for _, c := range cur {
+				alts[c] = append(alts[c], t)
+			}
+			s = s[2:]
+		}
+	}
+}
+
+func atoiDefault(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// condition
+
+// signatureMatches contains the number of matches of each string
+type signatureMatches map[string]int
+
+type signatureExpr interface {
+	eval(m signatureMatches) bool
+}
+
+type sigBool bool
+
+func (e sigBool) eval(signatureMatches) bool { return bool(e) }
+
+type sigAnd struct{ l, r signatureExpr }
+
+func (e sigAnd) eval(m signatureMatches) bool { return e.l.eval(m) && e.r.eval(m) }
+
+type sigOr struct{ l, r signatureExpr }
+
+func (e sigOr) eval(m signatureMatches) bool { return e.l.eval(m) || e.r.eval(m) }
+
+type sigNot struct{ e signatureExpr }
+
+func (e sigNot) eval(m signatureMatches) bool { return !e.e.eval(m) }
+
+type sigString struct{ id string }
+
+func (e sigString) eval(m signatureMatches) bool { return m[e.id] > 0 }
+
+type sigCount struct {
+	id string
+	op string
+	n  int
+}
+
+func (e sigCount) eval(m signatureMatches) bool {
+	c := m[e.id]
+	switch e.op {
+	case "==":
+		return c == e.n
+	case "!=":
+		return c != e.n
+	case "<":
+		return c < e.n
+	case "<=":
+		return c <= e.n
+	case ">":
+		return c > e.n
+	case ">=":
+		return c >= e.n
+	}
+	return false
+}
+
+// sigOf evaluates "<n> of (<ids>)", where n of -1 means all.
+type sigOf struct {
+	n   int
+	ids []string
+}
+
+func (e sigOf) eval(m signatureMatches) bool {
+	c := 0
+	for _, id := range e.ids {
+		if m[id] > 0 {
+			c++
+		}
+	}
+	if e.n < 0 {
+		return c == len(e.ids)
+	}
+	return c >= e.n
+}
+
+// lexer
+
+var sigPuncts = map[string]bool{
+	"{": true, "}": true, "(": true, ")": true, ":": true, "=": true, ",": true,
+	"<": true, ">": true, "<=": true, ">=": true, "==": true, "!=": true,
+}
+
+var sigComparisons = map[string]bool{"<": true, ">": true, "<=": true, ">=": true, "==": true, "!=": true}
+
+type sigToken struct {
+	kind string // ident, string, variable, count, number, hex, regex, punct
+	text string
+	line int
+}
+
+func lexSignature(src string) (tokens []sigToken, err error) {
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case unicode.IsSpace(rune(c)):
+			i++
+
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unclosed comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+
+		case c == '"':
+			j := i + 1
+			for ; j < len(src) && src[j] != '"'; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+				if j < len(src) && src[j] == '\n' {
+					return nil, fmt.Errorf("line %d: unclosed string", line)
+				}
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("line %d: unclosed string", line)
+			}
+			tokens = append(tokens, sigToken{"string", src[i+1 : j], line})
+			i = j + 1
+
+		case c == '{' && len(tokens) > 0 && tokens[len(tokens)-1].text == "=":
+			end := strings.IndexByte(src[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unclosed hex string", line)
+			}
+			tokens = append(tokens, sigToken{"hex", src[i+1 : i+end], line})
+			line += strings.Count(src[i:i+end], "\n")
+			i += end + 1
+
+		case c == '/' && len(tokens) > 0 && tokens[len(tokens)-1].text == "=":
+			j := i + 1
+			for ; j < len(src) && src[j] != '/'; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+				if j < len(src) && src[j] == '\n' {
+					return nil, fmt.Errorf("line %d: unclosed regular expression", line)
+				}
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("line %d: unclosed regular expression", line)
+			}
+			k := j + 1
+			for k < len(src) && (src[k] == 'i' || src[k] == 's') {
+				k++
+			}
+			tokens = append(tokens, sigToken{"regex", src[i+1:j] + "/" + src[j+1:k], line})
+			i = k
+
+		case c == '$' || c == '#':
+			j := i + 1
+			for j < len(src) && (isWordByte(src[j]) || src[j] == '*') {
+				j++
+			}
+			kind := "variable"
+			if c == '#' {
+				kind = "count"
+			}
+			tokens = append(tokens, sigToken{kind, src[i+1 : j], line})
+			i = j
+
+		case '0' <= c && c <= '9':
+			j := i
+			for j < len(src) && '0' <= src[j] && src[j] <= '9' {
+				j++
+			}
+			tokens = append(tokens, sigToken{"number", src[i:j], line})
+			i = j
+
+		case isWordByte(c):
+			j := i
+			for j < len(src) && (isWordByte(src[j]) || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, sigToken{"ident", src[i:j], line})
+			i = j
+
+		default:
+			p := string(c)
+			if i+1 < len(src) && src[i+1] == '=' && strings.IndexByte("<>!=", c) >= 0 {
+				p = src[i : i+2]
+			}
+			if !sigPuncts[p] {
+				return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+			}
+			tokens = append(tokens, sigToken{"punct", p, line})
+			i += len(p)
+		}
+	}
+	return
+}
+
+// parser
+
+type signatureParser struct {
+	tokens []sigToken
+	pos    int
+	rule   *signatureRule
+}
+
+func parseSignatureRules(src string) (rules []*signatureRule, err error) {
+	tokens, err := lexSignature(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &signatureParser{tokens: tokens}
+	for !p.eof() {
+		r, err := p.parseRule()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return
+}
+
+func (p *signatureParser) eof() bool { return p.pos >= len(p.tokens) }
+
+func (p *signatureParser) peek() sigToken {
+	if p.eof() {
+		return sigToken{kind: "eof"}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *signatureParser) next() sigToken {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *signatureParser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	if t.kind == "eof" && len(p.tokens) > 0 {
+		t = p.tokens[len(p.tokens)-1]
+	}
+	return fmt.Errorf("line %d: %s", t.line, fmt.Sprintf(format, args...))
+}
+
+func (p *signatureParser) expect(text string) error {
+	if t := p.next(); t.text != text || (t.kind != "punct" && t.kind != "ident") {
+		p.pos--
+		return p.errorf("expected %q, found %q", text, t.text)
+	}
+	return nil
+}
+
+func (p *signatureParser) parseRule() (r *signatureRule, err error) {
+	for p.peek().kind == "ident" && (p.peek().text == "private" || p.peek().text == "global") {
+		p.next()
+	}
+	if err = p.expect("rule"); err != nil {
+		return
+	}
+	name := p.next()
+	if name.kind != "ident" {
+		p.pos--
+		return nil, p.errorf("expected rule name")
+	}
+
+	r = &signatureRule{Name: name.text, Meta: map[string]string{}}
+	p.rule = r
+	if p.peek().text == ":" {
+		p.next()
+		for p.peek().kind == "ident" {
+			r.Tags = append(r.Tags, p.next().text)
+		}
+	}
+	if err = p.expect("{"); err != nil {
+		return
+	}
+
+	for p.peek().text != "}" {
+		section := p.next()
+		if err = p.expect(":"); err != nil {
+			return
+		}
+		switch section.text {
+		case "meta":
+			err = p.parseMeta()
+		case "strings":
+			err = p.parseStrings()
+		case "condition":
+			r.condition, err = p.parseOr()
+		default:
+			p.pos -= 2
+			return nil, p.errorf("unknown section %q in rule %s", section.text, r.Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.next()
+
+	if r.condition == nil {
+		return nil, p.errorf("rule %s has no condition", r.Name)
+	}
+	return
+}
+
+func (p *signatureParser) isSectionStart() bool {
+	return p.peek().kind == "ident" && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].text == ":"
+}
+
+func (p *signatureParser) parseMeta() error {
+	for p.peek().text != "}" && !p.isSectionStart() {
+		key := p.next()
+		if key.kind != "ident" {
+			p.pos--
+			return p.errorf("expected meta identifier")
+		}
+		if err := p.expect("="); err != nil {
+			return err
+		}
+		v := p.next()
+		if v.kind != "string" && v.kind != "number" && v.kind != "ident" {
+			p.pos--
+			return p.errorf("invalid value for meta %s", key.text)
+		}
+		p.rule.Meta[key.text] = v.text
+	}
+	return nil
+}
+
+func (p *signatureParser) parseStrings() (err error) {
+	for p.peek().kind == "variable" {
+		id := p.next()
+		if err = p.expect("="); err != nil {
+			return
+		}
+		for _, s := range p.rule.strings {
+			if s.id == id.text {
+				p.pos--
+				return p.errorf("duplicate string $%s in rule %s", id.text, p.rule.Name)
+			}
+		}
+
+		v := p.next()
+		mods := map[string]bool{}
+		for p.peek().kind == "ident" && !p.isSectionStart() {
+			mods[p.next().text] = true
+		}
+
+		ss := &signatureString{id: id.text}
+		switch v.kind {
+		case "string":
+			ss.m, err = newTextSignatureMatcher(v.text, mods)
+		case "hex":
+			ss.m, err = parseHexString(v.text)
+		case "regex":
+			i := strings.LastIndexByte(v.text, '/')
+			flags := "(?s)"
+			if strings.Contains(v.text[i+1:], "i") || mods["nocase"] {
+				flags = "(?is)"
+			}
+			var re *regexp.Regexp
+			re, err = regexp.Compile(flags + v.text[:i])
+			ss.m = regexMatcher{re}
+		default:
+			p.pos--
+			return p.errorf("invalid value for string $%s", id.text)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: invalid string $%s: %w", v.line, id.text, err)
+		}
+		p.rule.strings = append(p.rule.strings, ss)
+	}
+	return
+}
+
+func newTextSignatureMatcher(s string, mods map[string]bool) (m signatureMatcher, err error) {
+	u, err := strconv.Unquote(`"` + s + `"`)
+	if err != nil {
+		return nil, err
+	}
+	pattern := []byte(u)
+	if mods["nocase"] {
+		pattern = asciiLower(pattern)
+	}
+
+	text := textMatcher{pattern: pattern, nocase: mods["nocase"], fullword: mods["fullword"]}
+	if !mods["wide"] {
+		return text, nil
+	}
+
+	wide := textMatcher{nocase: text.nocase, fullword: text.fullword}
+	for _, b := range pattern {
+		wide.pattern = append(wide.pattern, b, 0)
+	}
+	if !mods["ascii"] {
+		return wide, nil
+	}
+	return multiMatcher{text, wide}, nil
+}
+
+type multiMatcher []signatureMatcher
+
+func (mm multiMatcher) findAll(data []byte) (res [][2]int) {
+	for _, m := range mm {
+		res = append(res, m.findAll(data)...)
+	}
+	return
+}
+
+func (p *signatureParser) parseOr() (e signatureExpr, err error) {
+	if e, err = p.parseAnd(); err != nil {
+		return
+	}
+	for p.peek().text == "or" {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		e = sigOr{e, r}
+	}
+	return
+}
+
+func (p *signatureParser) parseAnd() (e signatureExpr, err error) {
+	if e, err = p.parseNot(); err != nil {
+		return
+	}
+	for p.peek().text == "and" {
+		p.next()
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		e = sigAnd{e, r}
+	}
+	return
+}
+
+func (p *signatureParser) parseNot() (e signatureExpr, err error) {
+	if p.peek().text == "not" {
+		p.next()
+		if e, err = p.parseNot(); err != nil {
+			return
+		}
+		return sigNot{e}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *signatureParser) stringIDs(pattern string) (ids []string, err error) {
+	prefix := strings.TrimSuffix(pattern, "*")
+	for _, s := range p.rule.strings {
+		if s.id == pattern || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(s.id, prefix)) {
+			ids = append(ids, s.id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, p.errorf("undefined string $%s in rule %s", pattern, p.rule.Name)
+	}
+	return
+}
+
+func (p *signatureParser) parsePrimary() (e signatureExpr, err error) {
+	t := p.next()
+	switch {
+	case t.text == "(" && t.kind == "punct":
+		if e, err = p.parseOr(); err != nil {
+			return
+		}
+		return e, p.expect(")")
+
+	case t.kind == "ident" && (t.text == "true" || t.text == "false"):
+		return sigBool(t.text == "true"), nil
+
+	case t.kind == "variable":
+		p.pos--
+		if strings.HasSuffix(t.text, "*") {
+			return nil, p.errorf("wildcard $%s is only allowed in string set", t.text)
+		}
+		if _, err = p.stringIDs(t.text); err != nil {
+			return
+		}
+		p.pos++
+		return sigString{t.text}, nil
+
+	case t.kind == "count":
+		op := p.next()
+		if !sigComparisons[op.text] || op.kind != "punct" {
+			p.pos--
+			return nil, p.errorf("expected comparison operator after #%s", t.text)
+		}
+		n := p.next()
+		if n.kind != "number" {
+			p.pos--
+			return nil, p.errorf("expected number after #%s %s", t.text, op.text)
+		}
+		c, _ := strconv.Atoi(n.text)
+		return sigCount{t.text, op.text, c}, nil
+
+	case t.kind == "number" || (t.kind == "ident" && (t.text == "any" || t.text == "all")):
+		of := sigOf{n: 1}
+		switch t.text {
+		case "all":
+			of.n = -1
+		case "any":
+		default:
+			of.n, _ = strconv.Atoi(t.text)
+		}
+		if err = p.expect("of"); err != nil {
+			return
+		}
+
+		if p.peek().text == "them" {
+			p.next()
+			for _, s := range p.rule.strings {
+				of.ids = append(of.ids, s.id)
+			}
+			return of, nil
+		}
+		if err = p.expect("("); err != nil {
+			return
+		}
+		for {
+			v := p.next()
+			if v.kind != "variable" {
+				p.pos--
+				return nil, p.errorf("expected string identifier")
+			}
+			p.pos--
+			ids, err := p.stringIDs(v.text)
+			if err != nil {
+				return nil, err
+			}
+			p.pos++
+			of.ids = append(of.ids, ids...)
+			if p.peek().text != "," {
+				break
+			}
+			p.next()
+		}
+		return of, p.expect(")")
+	}
+
+	p.pos--
+	return nil, p.errorf("unexpected %q in condition of rule %s", t.text, p.rule.Name)
+}
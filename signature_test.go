@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignatureRules(t *testing.T) {
+	rules, err := parseSignatureRules(`
+		rule test : tag1 tag2 {
+			meta:
+				description = "test rule"
+				score = 10
+			strings:
+				$a = "Eval(" nocase
+				$b1 = { 3C 3F ( 70 68 70 | 3D ) [0-4] 28 }
+				$b2 = /pass(word)?=/i
+				$w = "cmd" wide ascii fullword
+			condition:
+				($a and #b2 >= 2) or (2 of ($b*) and not $w) // comment
+		}
+
+		/* second rule */
+		rule all_of_them { strings: $x = "x" $y = { 7? ?9 } condition: all of them }
+	`)
+	require.NoError(t, err, "should parse rules")
+	require.Len(t, rules, 2)
+	assert.Equal(t, []string{"tag1", "tag2"}, rules[0].Tags)
+	assert.Equal(t, "test rule", rules[0].Meta["description"])
+
+	table := []struct {
+		data string
+		exp  []bool
+	}{
+		{data: "x = eval(pass=1&PASSWORD=2)", exp: []bool{true, false}},
+		{data: "<?php (pass=", exp: []bool{true, false}},
+		{data: "<?=  (pass= cmd", exp: []bool{false, false}},
+		{data: "xy", exp: []bool{false, true}},
+		{data: "c\x00m\x00d\x00", exp: []bool{false, false}},
+	}
+	for i, tt := range table {
+		sc := newSignatureScanner(rules, "http.request.body")
+		sc.Write([]byte(tt.data))
+		sc.Close()
+
+		got := make([]bool, len(rules))
+		for _, m := range sc.matches() {
+			for j, r := range rules {
+				got[j] = got[j] || r.Name == m.Rule
+			}
+		}
+		assert.Equalf(t, tt.exp, got, "unexpected result. index %d", i)
+	}
+}
+
+func TestSignatureRulesInvalid(t *testing.T) {
+	for _, src := range []string{
+		`rule a { condition: $x }`,
+		`rule a { strings: $x = "a" }`,
+		`rule a { strings: $x = { 4G } condition: $x }`,
+		`rule a { strings: $x = { [1-2] 41 } condition: $x }`,
+		`rule a { strings: $x = "a" $x = "b" condition: $x }`,
+		`rule a { strings: $x = "a" condition: $x and }`,
+	} {
+		_, err := parseSignatureRules(src)
+		assert.Errorf(t, err, "should reject %s", src)
+	}
+}
+
+func TestSignatureScannerStreaming(t *testing.T) {
+	rules, err := loadSignatureRules("signatures")
+	require.NoError(t, err, "should load sample rules")
+
+	body := strings.Repeat("a", 3*signatureScannerWindow) + `<?php eval(base64_decode($_POST["x"])); ?>`
+	sc := newSignatureScanner(rules, "http.request.body")
+	for i := 0; i < len(body); i += 100 {
+		end := i + 100
+		if end > len(body) {
+			end = len(body)
+		}
+		sc.Write([]byte(body[i:end]))
+	}
+	sc.Close()
+
+	m := sc.matches()
+	require.Len(t, m, 1, "should match exactly one rule")
+	assert.Equal(t, "php_eval_obfuscated", m[0].Rule)
+	assert.Equal(t, int64(3*signatureScannerWindow), m[0].Strings[0].Offsets[0], "should report absolute offset")
+}
+
+func TestSignatureOffsets(t *testing.T) {
+	rules, err := parseSignatureRules(`
+		rule nocase { strings: $a = "EVAL(" nocase condition: $a }
+		rule jumps { strings: $h = { 41 [-] 42 [-] 43 [-] 44 } condition: $h }
+	`)
+	require.NoError(t, err, "should parse rules")
+
+	sc := newSignatureScanner(rules, "http.request.body")
+	sc.Write([]byte("\xff\xfe\xc3 eval("))
+	sc.Close()
+	m := sc.matches()
+	require.Len(t, m, 1)
+	assert.Equal(t, []int64{4}, m[0].Strings[0].Offsets, "should report the offset within binary data")
+
+	// many partial matches of the jumps would take ages without remembering where the rest of the string does not match
+	data := []byte(strings.Repeat("ABC", 2*signatureScannerWindow/3))
+	got := rules[1].strings[0].m.findAll(data)
+	assert.Empty(t, got)
+	assert.Equal(t, [][2]int{{0, 6}}, rules[1].strings[0].m.findAll([]byte("AxBCyD")))
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+	"go.uber.org/multierr"
+)
+
+const (
+	defaultBodyParseLimit = 64 * 1024
+
+	bodyPartsLimit      = 100
+	bodyPartSniffLen    = 3072
+	bodyPartValueLength = 256
+)
+
+const (
+	bodyTypeJSON      = "json"
+	bodyTypeForm      = "form"
+	bodyTypeMultipart = "multipart"
+)
+
+// bodyPartHook is invoked for each multipart part. The returned writer, if not nil, receives the whole content of the part and is closed afterward.
+type bodyPartHook func(part *multipart.Part) io.WriteCloser
+
+// bodyParser parses a body as it is being written, based on its content type.
+// JSON and form bodies are buffered up to limit bytes before parsed, while multipart bodies are parsed in streaming fashion.
+type bodyParser struct {
+	kind   string
+	buff   *truncatedBuffer
+	pw     *io.PipeWriter
+	done   chan struct{}
+	closed bool
+	hooks  []bodyPartHook
+
+	result *ecsx.Body
+}
+
+func newBodyParser(contentType string, limit int, hooks ...bodyPartHook) (bp *bodyParser) {
+	bp = &bodyParser{hooks: hooks}
+
+	mt, params, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mt == "application/json" || strings.HasSuffix(mt, "+json"):
+		bp.kind = bodyTypeJSON
+	case mt == "application/x-www-form-urlencoded":
+		bp.kind = bodyTypeForm
+	case strings.HasPrefix(mt, "multipart/") && params["boundary"] != "":
+		bp.kind = bodyTypeMultipart
+	default:
+		return
+	}
+
+	bp.result = &ecsx.Body{Type: bp.kind}
+	if bp.kind != bodyTypeMultipart {
+		bp.buff = newTruncatedBuffer(limit)
+		return
+	}
+
+	r, w := io.Pipe()
+	bp.pw, bp.done = w, make(chan struct{})
+	go bp.parseMultipart(r, params["boundary"])
+	return
+}
+
+func (bp *bodyParser) Write(p []byte) (n int, err error) {
+	switch {
+	case bp.pw != nil:
+		return bp.pw.Write(p)
+	case bp.buff != nil:
+		return bp.buff.Write(p)
+	}
+	return len(p), nil
+}
+
+func (bp *bodyParser) Close() (err error) {
+	if bp.closed {
+		return
+	}
+	bp.closed = true
+
+	switch bp.kind {
+	case bodyTypeMultipart:
+		err = bp.pw.Close()
+		<-bp.done
+	case bodyTypeJSON:
+		bp.parseJSON()
+	case bodyTypeForm:
+		bp.parseForm()
+	}
+	return
+}
+
+func (bp *bodyParser) truncated() bool {
+	return bp.buff.Len() > len(bp.buff.String())
+}
+
+func (bp *bodyParser) parseJSON() {
+	bp.result.Truncated = bp.truncated()
+
+	d := json.NewDecoder(strings.NewReader(bp.buff.String()))
+	d.UseNumber()
+	if err := d.Decode(&bp.result.JSON); err != nil {
+		bp.result.Error = err.Error()
+	}
+}
+
+func (bp *bodyParser) parseForm() {
+	bp.result.Truncated = bp.truncated()
+
+	form, err := url.ParseQuery(bp.buff.String())
+	if err != nil {
+		bp.result.Error = err.Error()
+	}
+	bp.result.Form = form
+}
+
+func (bp *bodyParser) parseMultipart(r *io.PipeReader, boundary string) {
+	defer close(bp.done)
+	defer io.Copy(io.Discard, r)
+
+	mr := multipart.NewReader(r, boundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			bp.result.Error = err.Error()
+			return
+		}
+		if len(bp.result.Parts) >= bodyPartsLimit {
+			bp.result.Truncated = true
+			continue
+		}
+
+		p := ecsx.BodyPart{
+			Name:        part.FormName(),
+			Filename:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+		}
+		head := newTruncatedBuffer(bodyPartSniffLen)
+		w := []io.Writer{head}
+		hw := []io.WriteCloser{}
+		for _, hook := range bp.hooks {
+			if wc := hook(part); wc != nil {
+				w, hw = append(w, wc), append(hw, wc)
+			}
+		}
+		p.Size, err = io.Copy(io.MultiWriter(w...), part)
+		if err != nil {
+			bp.result.Error = err.Error()
+		}
+		for _, wc := range hw {
+			wc.Close()
+		}
+
+		p.MimeType = mimetype.Detect(head.buff.Bytes()).String()
+		if p.Filename == "" && strings.HasPrefix(p.MimeType, "text/") {
+			p.Value = Truncate(head.String(), bodyPartValueLength)
+		}
+		bp.result.Parts = append(bp.result.Parts, p)
+	}
+}
+
+var _ subEnricher = &bodyEnricher{}
+
+// bodyEnricher provides structured representation of request and response body.
+// Other sub-enrichers can use request() and response() to access the parsed body, instead of parsing it themselves.
+type bodyEnricher struct {
+	limit        int
+	requestHooks []bodyPartHook
+	req          *bodyParser
+	res          *bodyParser
+}
+
+func newBodyEnricher(limit int) *bodyEnricher {
+	return &bodyEnricher{limit: limit}
+}
+
+// onRequestPart registers hook to be invoked on each part of multipart request body.
+// It must be called before the request body is written.
+func (b *bodyEnricher) onRequestPart(hook bodyPartHook) {
+	b.requestHooks = append(b.requestHooks, hook)
+}
+
+func (b *bodyEnricher) request() *ecsx.Body {
+	if b.req == nil || !b.req.closed {
+		return nil
+	}
+	return b.req.result
+}
+
+func (b *bodyEnricher) response() *ecsx.Body {
+	if b.res == nil || !b.res.closed {
+		return nil
+	}
+	return b.res.result
+}
+
+func (b *bodyEnricher) requestBodyWriter(req *http.Request) io.WriteCloser {
+	b.req = newBodyParser(req.Header.Get("Content-Type"), b.limit, b.requestHooks...)
+	return b.req
+}
+func (b *bodyEnricher) processRequest(req *http.Request) (err error) { return }
+
+func (b *bodyEnricher) responseBodyWriter(res *http.Response) io.WriteCloser {
+	b.res = newBodyParser(res.Header.Get("Content-Type"), b.limit)
+	return b.res
+}
+func (b *bodyEnricher) processResponse(res *http.Response) (err error) { return }
+
+func (b *bodyEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage) (err error) {
+	if doc.HTTP == nil {
+		doc.HTTP = &ecsx.HTTP{}
+	}
+	if doc.HTTP.Request == nil {
+		doc.HTTP.Request = &ecsx.HTTPRequest{}
+	}
+	if doc.HTTP.Response == nil {
+		doc.HTTP.Response = &ecsx.HTTPResponse{}
+	}
+
+	doc.HTTP.Request.ParsedBody = b.request()
+	doc.HTTP.Response.ParsedBody = b.response()
+	return
+}
+
+func (b *bodyEnricher) Close() (err error) {
+	// ensure the multipart parser goroutine stops even when the body was not fully copied
+	for _, bp := range []*bodyParser{b.req, b.res} {
+		if bp == nil {
+			continue
+		}
+		if errt := bp.Close(); errt != nil {
+			err = multierr.Append(err, errt)
+		}
+	}
+	return
+}
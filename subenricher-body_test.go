@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBodyParserJSON(t *testing.T) {
+	bp := newBodyParser("application/json; charset=utf-8", defaultBodyParseLimit)
+	_, err := io.Copy(bp, strings.NewReader(`{"a":{"b":[1,"c"]}}`))
+	require.NoError(t, err, "should not return error")
+	require.NoError(t, bp.Close(), "should not return error")
+
+	assert.Equal(t, bodyTypeJSON, bp.result.Type)
+	assert.Empty(t, bp.result.Error, "should parse json")
+	assert.False(t, bp.result.Truncated, "should not be truncated")
+	assert.Contains(t, bp.result.JSON, "a")
+
+	bp = newBodyParser("application/json", 5)
+	io.Copy(bp, strings.NewReader(`{"a":"bcdefg"}`))
+	require.NoError(t, bp.Close(), "should not return error")
+	assert.True(t, bp.result.Truncated, "should be truncated")
+	assert.NotEmpty(t, bp.result.Error, "should report parse error")
+}
+
+func TestBodyParserForm(t *testing.T) {
+	bp := newBodyParser("application/x-www-form-urlencoded", defaultBodyParseLimit)
+	io.Copy(bp, strings.NewReader("a=1&b=2&b=3"))
+	require.NoError(t, bp.Close(), "should not return error")
+	assert.Equal(t, map[string][]string{"a": {"1"}, "b": {"2", "3"}}, bp.result.Form)
+}
+
+func TestBodyParserMultipart(t *testing.T) {
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	mw.WriteField("name", "value")
+	fw, _ := mw.CreateFormFile("file", "image.png")
+	fw.Write([]byte("\x89PNG\r\n\x1a\n" + strings.Repeat("x", 10000)))
+	mw.Close()
+
+	bp := newBodyParser(mw.FormDataContentType(), defaultBodyParseLimit)
+	_, err := io.Copy(bp, &b)
+	require.NoError(t, err, "should not return error")
+	require.NoError(t, bp.Close(), "should not return error")
+	require.NoError(t, bp.Close(), "should be safe to close twice")
+
+	require.Len(t, bp.result.Parts, 2, "should contain all parts")
+	assert.Equal(t, "name", bp.result.Parts[0].Name)
+	assert.Equal(t, "value", bp.result.Parts[0].Value)
+	assert.Equal(t, "image.png", bp.result.Parts[1].Filename)
+	assert.Equal(t, "image/png", bp.result.Parts[1].MimeType)
+	assert.Equal(t, int64(10008), bp.result.Parts[1].Size)
+	assert.Empty(t, bp.result.Parts[1].Value, "should not keep file content")
+}
+
+func TestBodyParserUnsupported(t *testing.T) {
+	bp := newBodyParser("image/png", defaultBodyParseLimit)
+	io.Copy(bp, strings.NewReader("data"))
+	require.NoError(t, bp.Close(), "should not return error")
+	assert.Nil(t, bp.result, "should not produce result")
+}
@@ -24,7 +24,7 @@ func (erc *crsSubEnricher) Close() error {
 	return erc.tx.Clean()
 }
 
-func (erc *crsSubEnricher) requestBodyWriter() io.WriteCloser {
+func (erc *crsSubEnricher) requestBodyWriter(req *http.Request) io.WriteCloser {
 	return nopCloser{erc.tx.RequestBodyBuffer}
 }
 
@@ -63,7 +63,7 @@ func (erc *crsSubEnricher) processRequest(req *http.Request) (err error) {
 	return
 }
 
-func (erc *crsSubEnricher) responseBodyWriter() io.WriteCloser {
+func (erc *crsSubEnricher) responseBodyWriter(res *http.Response) io.WriteCloser {
 	return nopCloser{erc.tx.ResponseBodyBuffer}
 }
 
@@ -93,7 +93,14 @@ func (erc *crsSubEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage)
 	if doc.Threat == nil {
 		doc.Threat = &ecs.Threat{}
 	}
-	for _, rule := range erc.tx.MatchedRules {
+	doc.Threat.Enrichments = append(doc.Threat.Enrichments, crsThreatEnrichments(erc.tx.MatchedRules)...)
+
+	return nil
+}
+
+// crsThreatEnrichments returns the threat enrichments of the matched attack rules.
+func crsThreatEnrichments(rules []coraza.MatchedRule) (tes []ecs.ThreatEnrichments) {
+	for _, rule := range rules {
 		idc := ecs.ThreatIndicator{
 			Description: rule.ErrorLog(0),
 			IP:          net.ParseIP(rule.ClientIPAddress),
@@ -102,6 +109,7 @@ func (erc *crsSubEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage)
 		}
 		match := &ecs.ThreatEnrichmentMatch{
 			Type:   "indicator_match_rule",
+			Field:  crsMatchedField(rule.MatchedData),
 			Atomic: Truncate(rule.MatchedData.Value, 200),
 		}
 		atk := false
@@ -129,11 +137,18 @@ func (erc *crsSubEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage)
 			continue
 		}
 
-		doc.Threat.Enrichments = append(doc.Threat.Enrichments, ecs.ThreatEnrichments{
+		tes = append(tes, ecs.ThreatEnrichments{
 			Indicator: idc,
 			Match:     match,
 		})
 	}
+	return
+}
 
-	return nil
+// crsMatchedField names the variable matched by a rule the way CRS logs do, e.g. ARGS:password.
+func crsMatchedField(md coraza.MatchData) string {
+	if md.Key == "" {
+		return md.Variable.Name()
+	}
+	return md.Variable.Name() + ":" + md.Key
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/telkomindonesia/httpmsg-enricher/ecs"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+func newEndpoint(hostname string, port uint16) *ecs.Endpoint {
+	e := &ecs.Endpoint{Address: hostname, Port: port}
+	if e.IP = net.ParseIP(e.Address); e.IP == nil {
+		e.Domain = e.Address
+		e.RegisteredDomain, e.Subdomain, e.TopLevelDomain = domainParts(e.Domain)
+	}
+	return e
+}
+
+var _ subEnricher = &endpointEnricher{}
+
+// endpointEnricher populates server.* from the requested host, destination.* from the upstream target of the context, and source.* from the client.
+// It relies on url.* and client.* populated by the other sub-enrichers, hence should be invoked last.
+type endpointEnricher struct{}
+
+func (ee *endpointEnricher) requestBodyWriter(req *http.Request) io.WriteCloser   { return nopwc }
+func (ee *endpointEnricher) processRequest(req *http.Request) (err error)         { return }
+func (ee *endpointEnricher) responseBodyWriter(res *http.Response) io.WriteCloser { return nopwc }
+func (ee *endpointEnricher) processResponse(res *http.Response) (err error)       { return }
+func (ee *endpointEnricher) Close() (err error)                                   { return }
+
+func (ee *endpointEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage) (err error) {
+	ctx, _ := msg.Context()
+
+	if doc.URL != nil && doc.URL.Domain != "" {
+		doc.Server = newEndpoint(doc.URL.Domain, doc.URL.Port)
+	}
+
+	switch {
+	case ctx != nil && ctx.Host != nil && ctx.Host.Target != nil && *ctx.Host.Target != "":
+		target, scheme := *ctx.Host.Target, ""
+		if t, err := url.Parse(target); err == nil && t.Host != "" {
+			target, scheme = t.Host, t.Scheme
+		}
+		hostname, port := hostPort(target)
+		if port == 0 {
+			port = defaultPorts[scheme]
+		}
+		if port == 0 && doc.Server != nil && doc.Server.Address == hostname {
+			port = doc.Server.Port
+		}
+		doc.Destination = newEndpoint(hostname, port)
+	case doc.Server != nil:
+		dst := *doc.Server
+		doc.Destination = &dst
+	}
+
+	switch {
+	case doc.Client != nil:
+		src := *doc.Client
+		doc.Source = &src
+	case ctx != nil && ctx.Connection != nil && ctx.Connection.Client.IP != nil:
+		doc.Source = &ecs.Endpoint{IP: ctx.Connection.Client.IP}
+	}
+	if doc.Source != nil && doc.Source.IP != nil {
+		doc.Source.Address = doc.Source.IP.String()
+	}
+	if doc.Source != nil && doc.Source.Port == 0 && ctx != nil && ctx.Connection != nil {
+		doc.Source.Port = ctx.Connection.Client.Port
+	}
+	return
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telkomindonesia/httpmsg-enricher/ecs"
+)
+
+func TestEndpointEnricher(t *testing.T) {
+	ercr, err := newEnricher()
+	require.NoError(t, err)
+
+	f, err := os.ReadFile("testdata/record1.txt")
+	require.NoError(t, err, "unexpected error in reading test data")
+	erc, err := ercr.EnrichRecord(bytes.NewReader(f))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+	require.Len(t, docs, 1, "should produce a document for the only exchange")
+	doc := docs[0]
+
+	require.NotNil(t, doc.Server)
+	assert.Equal(t, "mockbin.org", doc.Server.Domain)
+	assert.Equal(t, "mockbin.org", doc.Server.RegisteredDomain)
+	assert.Equal(t, uint16(80), doc.Server.Port)
+
+	require.NotNil(t, doc.Destination)
+	assert.Equal(t, "mockbin.org", doc.Destination.Address)
+	assert.Equal(t, uint16(80), doc.Destination.Port)
+
+	require.NotNil(t, doc.Source)
+	assert.Equal(t, "127.0.0.1", doc.Source.Address)
+
+	require.NotNil(t, doc.Related)
+	assert.Equal(t, []string{"mockbin.org"}, doc.Related.Hosts)
+	assert.Equal(t, []string{"user"}, doc.Related.User)
+	require.Len(t, doc.Related.IP, 1)
+	assert.True(t, doc.Related.IP[0].Equal(net.ParseIP("127.0.0.1")))
+}
+
+func TestNewEndpoint(t *testing.T) {
+	e := newEndpoint("10.0.0.1", 8080)
+	assert.Equal(t, &ecs.Endpoint{Address: "10.0.0.1", IP: net.ParseIP("10.0.0.1"), Port: 8080}, e)
+
+	e = newEndpoint("api.example.com", 443)
+	assert.Equal(t, "example.com", e.RegisteredDomain)
+	assert.Equal(t, "api", e.Subdomain)
+	assert.Equal(t, "com", e.TopLevelDomain)
+}
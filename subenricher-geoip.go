@@ -16,10 +16,10 @@ type geoipEnricher struct {
 	db *geoip2.Reader
 }
 
-func (g *geoipEnricher) requestBodyWriter() io.WriteCloser              { return nopwc }
-func (g *geoipEnricher) processRequest(req *http.Request) (err error)   { return }
-func (g *geoipEnricher) responseBodyWriter() io.WriteCloser             { return nopwc }
-func (g *geoipEnricher) processResponse(res *http.Response) (err error) { return }
+func (g *geoipEnricher) requestBodyWriter(req *http.Request) io.WriteCloser   { return nopwc }
+func (g *geoipEnricher) processRequest(req *http.Request) (err error)         { return }
+func (g *geoipEnricher) responseBodyWriter(res *http.Response) io.WriteCloser { return nopwc }
+func (g *geoipEnricher) processResponse(res *http.Response) (err error)       { return }
 
 func (g *geoipEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage) (err error) {
 	if g.db == nil {
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/telkomindonesia/httpmsg-enricher/ecs"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+const (
+	defaultGraphQLMaxDepth   = 10
+	defaultGraphQLMaxAliases = 15
+)
+
+const (
+	graphQLSourceBody  = "http.request.body.content"
+	graphQLSourceQuery = "url.query"
+)
+
+// graphQLQueries extracts the query documents of a request, either from its body or from its query parameter.
+// JSON (possibly batched) and form bodies are taken as parsed by bodyEnricher, while application/graphql body is given as raw.
+// explicit reports whether the request is unambiguously meant for GraphQL, in which case unparsable queries are reported instead of ignored.
+func graphQLQueries(req *http.Request, parsed *ecsx.Body, raw []byte) (source string, queries []string, explicit bool) {
+	mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	explicit = mt == "application/graphql" || strings.HasSuffix(strings.TrimSuffix(req.URL.Path, "/"), "/graphql")
+	if mt == "application/graphql" {
+		return graphQLSourceBody, []string{string(bytes.TrimSpace(raw))}, explicit
+	}
+
+	switch {
+	case parsed == nil:
+
+	case parsed.Type == bodyTypeJSON:
+		switch v := parsed.JSON.(type) {
+		case []interface{}:
+			for _, r := range v {
+				q := graphQLQueryOf(r)
+				if q == "" {
+					return "", nil, false
+				}
+				queries = append(queries, q)
+			}
+		case map[string]interface{}:
+			if q := graphQLQueryOf(v); q != "" {
+				queries = append(queries, q)
+			}
+		}
+		if len(queries) > 0 {
+			return graphQLSourceBody, queries, explicit
+		}
+
+	case parsed.Type == bodyTypeForm:
+		if vs := parsed.Form["query"]; len(vs) > 0 && vs[0] != "" {
+			return graphQLSourceBody, vs[:1], explicit
+		}
+	}
+
+	if q := req.URL.Query().Get("query"); q != "" {
+		return graphQLSourceQuery, []string{q}, explicit
+	}
+	return "", nil, false
+}
+
+func graphQLQueryOf(v interface{}) string {
+	r, _ := v.(map[string]interface{})
+	q, _ := r["query"].(string)
+	return q
+}
+
+type graphQLSelectionStat struct {
+	depth   int
+	aliases int
+}
+
+// graphQLAnalysis walks selection sets, expanding fragment spreads.
+// Fragment results are memoized so that fragments spreading each other repeatedly can not blow up the walk.
+type graphQLAnalysis struct {
+	fragments ast.FragmentDefinitionList
+	memo      map[string]graphQLSelectionStat
+	visiting  map[string]bool
+}
+
+func (a *graphQLAnalysis) walk(set ast.SelectionSet) (st graphQLSelectionStat) {
+	for _, sel := range set {
+		var s graphQLSelectionStat
+		switch sel := sel.(type) {
+		case *ast.Field:
+			s = a.walk(sel.SelectionSet)
+			s.depth++
+			if sel.Alias != sel.Name {
+				s.aliases++
+			}
+		case *ast.InlineFragment:
+			s = a.walk(sel.SelectionSet)
+		case *ast.FragmentSpread:
+			s = a.fragment(sel.Name)
+		}
+
+		if s.depth > st.depth {
+			st.depth = s.depth
+		}
+		st.aliases = saturatedAdd(st.aliases, s.aliases)
+	}
+	return
+}
+
+func (a *graphQLAnalysis) fragment(name string) (st graphQLSelectionStat) {
+	if st, ok := a.memo[name]; ok {
+		return st
+	}
+	f := a.fragments.ForName(name)
+	if f == nil || a.visiting[name] {
+		return
+	}
+
+	a.visiting[name] = true
+	st = a.walk(f.SelectionSet)
+	delete(a.visiting, name)
+	a.memo[name] = st
+	return
+}
+
+func (a *graphQLAnalysis) rootFields(set ast.SelectionSet, fields []string) []string {
+	for _, sel := range set {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if !containsString(fields, sel.Name) {
+				fields = append(fields, sel.Name)
+			}
+		case *ast.InlineFragment:
+			fields = a.rootFields(sel.SelectionSet, fields)
+		case *ast.FragmentSpread:
+			if f := a.fragments.ForName(sel.Name); f != nil && !a.visiting[sel.Name] {
+				a.visiting[sel.Name] = true
+				fields = a.rootFields(f.SelectionSet, fields)
+				delete(a.visiting, sel.Name)
+			}
+		}
+	}
+	return fields
+}
+
+func analyzeGraphQL(query string) (ops []ecsx.GraphQLOperation, err error) {
+	doc, err := parser.ParseQuery(&ast.Source{Input: query})
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Operations) == 0 {
+		return nil, fmt.Errorf("no operation found")
+	}
+
+	a := &graphQLAnalysis{fragments: doc.Fragments, memo: map[string]graphQLSelectionStat{}, visiting: map[string]bool{}}
+	for _, op := range doc.Operations {
+		o := ecsx.GraphQLOperation{Type: string(op.Operation), Name: op.Name}
+		if o.Type == "" {
+			o.Type = string(ast.Query)
+		}
+		st := a.walk(op.SelectionSet)
+		o.Depth, o.Aliases = st.depth, st.aliases
+		o.RootFields = a.rootFields(op.SelectionSet, nil)
+		o.Introspection = containsString(o.RootFields, "__schema") || containsString(o.RootFields, "__type")
+		ops = append(ops, o)
+	}
+	return
+}
+
+func saturatedAdd(a, b int) int {
+	if a > math.MaxInt32-b {
+		return math.MaxInt32
+	}
+	return a + b
+}
+
+func containsString(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+var _ subEnricher = &graphQLEnricher{}
+
+// graphQLEnricher reuses the JSON and form bodies parsed by bodyEnricher, buffering application/graphql bodies only.
+type graphQLEnricher struct {
+	body       *bodyEnricher
+	limit      int
+	maxDepth   int
+	maxAliases int
+
+	raw    *truncatedBuffer
+	result *ecsx.GraphQL
+}
+
+func newGraphQLEnricher(body *bodyEnricher, limit, maxDepth, maxAliases int) *graphQLEnricher {
+	return &graphQLEnricher{
+		body:       body,
+		limit:      limit,
+		maxDepth:   maxDepth,
+		maxAliases: maxAliases,
+	}
+}
+
+func (g *graphQLEnricher) requestBodyWriter(req *http.Request) io.WriteCloser {
+	if mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mt != "application/graphql" {
+		return nopwc
+	}
+	g.raw = newTruncatedBuffer(g.limit)
+	return g.raw
+}
+
+func (g *graphQLEnricher) processRequest(req *http.Request) (err error) {
+	var raw []byte
+	if g.raw != nil {
+		raw = g.raw.buff.Bytes()
+	}
+	parsed := g.body.request()
+	source, queries, explicit := graphQLQueries(req, parsed, raw)
+	if len(queries) == 0 {
+		return
+	}
+
+	result := &ecsx.GraphQL{Source: source, BatchSize: len(queries)}
+	for _, q := range queries {
+		ops, err := analyzeGraphQL(q)
+		if err != nil {
+			result.Errors = append(result.Errors, err.Error())
+			continue
+		}
+		for _, o := range ops {
+			result.Introspection = result.Introspection || o.Introspection
+			result.ExcessiveDepth = result.ExcessiveDepth || o.Depth > g.maxDepth
+			result.ExcessiveAliases = result.ExcessiveAliases || o.Aliases > g.maxAliases
+		}
+		result.Operations = append(result.Operations, ops...)
+	}
+	if len(result.Operations) == 0 && !explicit {
+		return
+	}
+	if source == graphQLSourceBody && ((g.raw != nil && g.raw.Len() > g.raw.buff.Len()) || (parsed != nil && parsed.Truncated)) {
+		result.Errors = append(result.Errors, "body is truncated")
+	}
+
+	g.result = result
+	return
+}
+
+func (g *graphQLEnricher) responseBodyWriter(res *http.Response) io.WriteCloser { return nopwc }
+func (g *graphQLEnricher) processResponse(res *http.Response) (err error)       { return }
+func (g *graphQLEnricher) Close() (err error)                                   { return }
+
+func (g *graphQLEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage) (err error) {
+	if g.result == nil {
+		return
+	}
+
+	doc.GraphQL = g.result
+	for _, flag := range []struct {
+		set        bool
+		confidence string
+		desc       string
+	}{
+		{g.result.Introspection, "Low", "graphql introspection query"},
+		{g.result.ExcessiveDepth, "Medium", fmt.Sprintf("graphql query deeper than %d", g.maxDepth)},
+		{g.result.ExcessiveAliases, "Medium", fmt.Sprintf("graphql query with more than %d aliases", g.maxAliases)},
+	} {
+		if !flag.set {
+			continue
+		}
+		if doc.Threat == nil {
+			doc.Threat = &ecs.Threat{}
+		}
+		doc.Threat.Enrichments = append(doc.Threat.Enrichments, ecs.ThreatEnrichments{
+			Indicator: ecs.ThreatIndicator{
+				Confidence:  flag.confidence,
+				Description: flag.desc,
+				Provider:    "graphql",
+				Type:        "graphql",
+			},
+			Match: &ecs.ThreatEnrichmentMatch{
+				Type:  "indicator_match_rule",
+				Field: g.result.Source,
+			},
+		})
+	}
+	return
+}
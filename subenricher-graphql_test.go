@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+func TestAnalyzeGraphQL(t *testing.T) {
+	ops, err := analyzeGraphQL(`
+		query Users { a: users { id friends { ...F } } b: users { id } }
+		fragment F on User { id posts { title } }
+		mutation { login(user: "x") }
+	`)
+	require.NoError(t, err)
+	require.Len(t, ops, 2)
+	assert.Equal(t, ecsx.GraphQLOperation{Type: "query", Name: "Users", RootFields: []string{"users"}, Depth: 4, Aliases: 2}, ops[0])
+	assert.Equal(t, ecsx.GraphQLOperation{Type: "mutation", RootFields: []string{"login"}, Depth: 1}, ops[1])
+
+	ops, err = analyzeGraphQL(`{ __schema { types { name } } }`)
+	require.NoError(t, err)
+	assert.True(t, ops[0].Introspection)
+
+	// each fragment spreads the next one twice, walking it naively would take 2^30 steps
+	bomb := strings.Builder{}
+	bomb.WriteString("{ ...F0 }\n")
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(&bomb, "fragment F%d on Q { a: f { ...F%d } b: f { ...F%d } }\n", i, i+1, i+1)
+	}
+	bomb.WriteString("fragment F30 on Q { id }")
+	ops, err = analyzeGraphQL(bomb.String())
+	require.NoError(t, err)
+	assert.Equal(t, 31, ops[0].Depth)
+
+	_, err = analyzeGraphQL(`not graphql`)
+	assert.Error(t, err)
+}
+
+func TestGraphQLEnricher(t *testing.T) {
+	for name, tc := range map[string]struct {
+		req      *http.Request
+		expected *ecsx.GraphQL
+	}{
+		"json": {
+			req:      httptest.NewRequest("POST", "/api", strings.NewReader(`{"query":"{ __schema { types { name } } }"}`)),
+			expected: &ecsx.GraphQL{Source: graphQLSourceBody, BatchSize: 1, Introspection: true, ExcessiveDepth: true},
+		},
+		"batch": {
+			req:      httptest.NewRequest("POST", "/api", strings.NewReader(`[{"query":"{ a }"},{"query":"{ x1: a x2: a }"}]`)),
+			expected: &ecsx.GraphQL{Source: graphQLSourceBody, BatchSize: 2, ExcessiveAliases: true},
+		},
+		"get": {
+			req:      httptest.NewRequest("GET", "/api?query="+strings.ReplaceAll("{ a { b { c } } }", " ", "+"), nil),
+			expected: &ecsx.GraphQL{Source: graphQLSourceQuery, BatchSize: 1, ExcessiveDepth: true},
+		},
+		"graphql": {
+			req:      httptest.NewRequest("POST", "/api", strings.NewReader(`{ a(`)),
+			expected: &ecsx.GraphQL{Source: graphQLSourceBody, BatchSize: 1},
+		},
+		"form": {
+			req:      httptest.NewRequest("POST", "/graphql", strings.NewReader("query="+strings.ReplaceAll("{ a { b { c } } }", " ", "+"))),
+			expected: &ecsx.GraphQL{Source: graphQLSourceBody, BatchSize: 1, ExcessiveDepth: true},
+		},
+		"not graphql": {
+			req: httptest.NewRequest("POST", "/search", strings.NewReader(`{"query":"shoes"}`)),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			switch name {
+			case "graphql":
+				tc.req.Header.Set("Content-Type", "application/graphql")
+			case "form":
+				tc.req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			default:
+				tc.req.Header.Set("Content-Type", "application/json")
+			}
+
+			body := newBodyEnricher(defaultBodyParseLimit)
+			g := newGraphQLEnricher(body, defaultBodyParseLimit, 2, 1)
+			require.NoError(t, MultiCopy(tc.req.Body, body.requestBodyWriter(tc.req), g.requestBodyWriter(tc.req)))
+			require.NoError(t, g.processRequest(tc.req))
+
+			doc := &ecsx.Document{}
+			require.NoError(t, g.enrich(doc, nil))
+			if tc.expected == nil {
+				assert.Nil(t, doc.GraphQL)
+				return
+			}
+			require.NotNil(t, doc.GraphQL)
+			assert.Equal(t, tc.expected.Source, doc.GraphQL.Source)
+			assert.Equal(t, tc.expected.BatchSize, doc.GraphQL.BatchSize)
+			assert.Equal(t, tc.expected.Introspection, doc.GraphQL.Introspection)
+			assert.Equal(t, tc.expected.ExcessiveDepth, doc.GraphQL.ExcessiveDepth)
+			assert.Equal(t, tc.expected.ExcessiveAliases, doc.GraphQL.ExcessiveAliases)
+			if name == "graphql" {
+				assert.NotEmpty(t, doc.GraphQL.Errors, "should report unparsable query")
+			}
+		})
+	}
+}
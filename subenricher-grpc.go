@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	grpcMaxDecoded = 16
+
+	grpcFlagCompressed = 0x01
+	grpcFlagTrailer    = 0x80
+)
+
+var grpcStatusNames = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND", "ALREADY_EXISTS", "PERMISSION_DENIED",
+	"RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+// loadGRPCDescriptors loads a FileDescriptorSet, as written by `protoc --include_imports --descriptor_set_out`.
+func loadGRPCDescriptors(file string) (*protoregistry.Files, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err = proto.Unmarshal(b, set); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %w", err)
+	}
+	return protodesc.NewFiles(set)
+}
+
+type grpcContentType struct {
+	web   bool
+	text  bool // grpc-web-text, whose body is base64 encoded
+	proto bool
+}
+
+func parseGRPCContentType(h http.Header) (ct grpcContentType, ok bool) {
+	mt, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	codec := ""
+	switch {
+	case strings.HasPrefix(mt, "application/grpc-web-text"):
+		ct.web, ct.text, codec = true, true, strings.TrimPrefix(mt, "application/grpc-web-text")
+	case strings.HasPrefix(mt, "application/grpc-web"):
+		ct.web, codec = true, strings.TrimPrefix(mt, "application/grpc-web")
+	case strings.HasPrefix(mt, "application/grpc"):
+		codec = strings.TrimPrefix(mt, "application/grpc")
+	default:
+		return ct, false
+	}
+	if codec != "" && !strings.HasPrefix(codec, "+") {
+		return ct, false
+	}
+	ct.proto = codec == "" || codec == "+proto"
+	return ct, true
+}
+
+// grpcMethod splits the path of a call, i.e. /<package>.<service>/<method>.
+func grpcMethod(path string) (service, method string, ok bool) {
+	i := strings.LastIndex(path, "/")
+	if !strings.HasPrefix(path, "/") || i <= 1 || i == len(path)-1 {
+		return "", "", false
+	}
+	return path[1:i], path[i+1:], true
+}
+
+// grpcTextWriter decodes the base64 body of grpc-web-text messages, which may be made of several padded segments.
+type grpcTextWriter struct {
+	w   *grpcFrames
+	buf []byte
+}
+
+func (tw *grpcTextWriter) Write(p []byte) (n int, err error) {
+	n = len(p)
+	for _, c := range p {
+		if c != '\r' && c != '\n' && c != ' ' && c != '\t' {
+			tw.buf = append(tw.buf, c)
+		}
+	}
+
+	k := len(tw.buf) / 4 * 4
+	dst := make([]byte, 0, k/4*3)
+	for i := 0; i < k && !tw.w.failed; i += 4 {
+		b, errt := base64.StdEncoding.DecodeString(string(tw.buf[i : i+4]))
+		if errt != nil {
+			tw.w.fail("invalid base64 body of the %s", tw.w.side)
+			break
+		}
+		dst = append(dst, b...)
+	}
+	tw.buf = append(tw.buf[:0], tw.buf[k:]...)
+	tw.w.Write(dst)
+	return
+}
+
+func (tw *grpcTextWriter) Close() error { return nil }
+
+// grpcFrames parses the length-prefixed messages of one side of a call as they are written.
+type grpcFrames struct {
+	ge       *grpcEnricher
+	side     string
+	stats    *ecsx.GRPCMessages
+	desc     protoreflect.MessageDescriptor
+	encoding string
+
+	head      []byte
+	inFrame   bool
+	flag      byte
+	length    int64
+	read      int64
+	payload   []byte
+	truncated bool
+	failed    bool
+}
+
+func (gf *grpcFrames) fail(format string, args ...interface{}) {
+	gf.ge.errorf(format, args...)
+	gf.failed = true
+}
+
+func (gf *grpcFrames) Write(p []byte) (n int, err error) {
+	n = len(p)
+	for len(p) > 0 && !gf.failed {
+		if !gf.inFrame {
+			k := 5 - len(gf.head)
+			if k > len(p) {
+				k = len(p)
+			}
+			gf.head, p = append(gf.head, p[:k]...), p[k:]
+			if len(gf.head) < 5 {
+				return
+			}
+			gf.inFrame, gf.flag, gf.length = true, gf.head[0], int64(binary.BigEndian.Uint32(gf.head[1:]))
+			gf.head = gf.head[:0]
+			if gf.length == 0 {
+				gf.endFrame()
+			}
+			continue
+		}
+
+		k := int64(len(p))
+		if rest := gf.length - gf.read; k > rest {
+			k = rest
+		}
+		gf.read += k
+		if !gf.truncated && len(gf.payload)+int(k) > gf.ge.limit {
+			gf.truncated = true
+		}
+		if !gf.truncated {
+			gf.payload = append(gf.payload, p[:k]...)
+		}
+		p = p[k:]
+		if gf.read == gf.length {
+			gf.endFrame()
+		}
+	}
+	return
+}
+
+func (gf *grpcFrames) Close() error { return nil }
+
+func (gf *grpcFrames) pending() bool {
+	return gf.inFrame || len(gf.head) > 0
+}
+
+func (gf *grpcFrames) endFrame() {
+	defer func() {
+		gf.inFrame, gf.flag, gf.length, gf.read, gf.payload, gf.truncated = false, 0, 0, 0, gf.payload[:0], false
+	}()
+
+	if gf.flag&grpcFlagTrailer != 0 {
+		gf.readTrailer()
+		return
+	}
+
+	s := gf.stats
+	s.Messages++
+	s.Bytes += gf.length
+	if gf.length > s.MaxMessageBytes {
+		s.MaxMessageBytes = gf.length
+	}
+	if gf.flag&grpcFlagCompressed != 0 {
+		s.Compressed++
+	}
+	if gf.desc != nil && len(s.Decoded) < grpcMaxDecoded {
+		gf.decode()
+	}
+}
+
+// readTrailer reads the trailer frame ending grpc-web responses, which holds the status as header lines.
+func (gf *grpcFrames) readTrailer() {
+	if gf.truncated {
+		gf.ge.errorf("trailer of the %s exceeds %d bytes", gf.side, gf.ge.limit)
+		return
+	}
+	b := append(bytes.TrimRight(gf.payload, "\r\n"), "\r\n\r\n"...)
+	h, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(b))).ReadMIMEHeader()
+	if err != nil {
+		gf.ge.errorf("invalid trailer of the %s: %v", gf.side, err)
+		return
+	}
+	gf.ge.setStatus(http.Header(h))
+}
+
+func (gf *grpcFrames) decode() {
+	n := gf.stats.Messages
+	if gf.truncated {
+		gf.ge.errorf("%s message %d exceeds %d bytes and is not decoded", gf.side, n, gf.ge.limit)
+		return
+	}
+
+	payload := gf.payload
+	if gf.flag&grpcFlagCompressed != 0 {
+		if gf.encoding != "gzip" {
+			gf.ge.errorf("%s message %d is compressed with unsupported encoding %q", gf.side, n, gf.encoding)
+			return
+		}
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err == nil {
+			payload, err = io.ReadAll(io.LimitReader(r, int64(gf.ge.limit)+1))
+		}
+		if err == nil && len(payload) > gf.ge.limit {
+			err = fmt.Errorf("decompressed message exceeds %d bytes", gf.ge.limit)
+		}
+		if err != nil {
+			gf.ge.errorf("error decompressing %s message %d: %v", gf.side, n, err)
+			return
+		}
+	}
+
+	m := dynamicpb.NewMessage(gf.desc)
+	if err := proto.Unmarshal(payload, m); err != nil {
+		gf.ge.errorf("error decoding %s message %d as %s: %v", gf.side, n, gf.desc.FullName(), err)
+		return
+	}
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		gf.ge.errorf("error encoding %s message %d as JSON: %v", gf.side, n, err)
+		return
+	}
+	gf.stats.Decoded = append(gf.stats.Decoded, json.RawMessage(b))
+}
+
+var _ subEnricher = &grpcEnricher{}
+
+// grpcEnricher describes gRPC and gRPC-Web calls: the called method, the messages of both sides and the status of the call.
+// Messages are decoded into JSON when the descriptors of the method are known.
+type grpcEnricher struct {
+	limit int
+	files *protoregistry.Files
+
+	g      *ecsx.GRPC
+	method protoreflect.MethodDescriptor
+	req    *grpcFrames
+	res    *grpcFrames
+}
+
+func newGRPCEnricher(limit int, files *protoregistry.Files) *grpcEnricher {
+	return &grpcEnricher{limit: limit, files: files}
+}
+
+func (ge *grpcEnricher) processRequest(req *http.Request) (err error) { return }
+func (ge *grpcEnricher) Close() (err error)                           { return }
+
+func (ge *grpcEnricher) errorf(format string, args ...interface{}) {
+	ge.g.Errors = append(ge.g.Errors, fmt.Sprintf(format, args...))
+}
+
+func (ge *grpcEnricher) frames(side string, stats *ecsx.GRPCMessages, h http.Header, ct grpcContentType, desc protoreflect.MessageDescriptor) (gf *grpcFrames, w io.WriteCloser) {
+	gf = &grpcFrames{ge: ge, side: side, stats: stats, encoding: h.Get("Grpc-Encoding")}
+	if ct.proto {
+		gf.desc = desc
+	}
+	if ct.text {
+		return gf, &grpcTextWriter{w: gf}
+	}
+	return gf, gf
+}
+
+func (ge *grpcEnricher) requestBodyWriter(req *http.Request) io.WriteCloser {
+	ct, ok := parseGRPCContentType(req.Header)
+	if !ok {
+		return nopwc
+	}
+
+	ge.g = &ecsx.GRPC{Web: ct.web}
+	if enc := req.Header.Get("Grpc-Encoding"); enc != "identity" {
+		ge.g.Encoding = enc
+	}
+	service, method, ok := grpcMethod(req.URL.Path)
+	if !ok {
+		ge.errorf("invalid method path %q", req.URL.Path)
+	}
+	ge.g.Service, ge.g.Method = service, method
+
+	var input protoreflect.MessageDescriptor
+	if ok && ct.proto && ge.files != nil {
+		if d, err := ge.files.FindDescriptorByName(protoreflect.FullName(service)); err == nil {
+			if sd, isService := d.(protoreflect.ServiceDescriptor); isService {
+				ge.method = sd.Methods().ByName(protoreflect.Name(method))
+			}
+		}
+		if ge.method == nil {
+			ge.errorf("no descriptor of method %s/%s", service, method)
+		} else {
+			input = ge.method.Input()
+		}
+	}
+
+	var w io.WriteCloser
+	ge.req, w = ge.frames("request", &ge.g.Request, req.Header, ct, input)
+	return w
+}
+
+func (ge *grpcEnricher) responseBodyWriter(res *http.Response) io.WriteCloser {
+	if ge.g == nil {
+		return nopwc
+	}
+	ct, ok := parseGRPCContentType(res.Header)
+	if !ok {
+		return nopwc
+	}
+
+	var output protoreflect.MessageDescriptor
+	if ge.method != nil {
+		output = ge.method.Output()
+	}
+	var w io.WriteCloser
+	ge.res, w = ge.frames("response", &ge.g.Response, res.Header, ct, output)
+	return w
+}
+
+// processResponse reads the status from the trailers, or from the headers of responses made only of trailers.
+func (ge *grpcEnricher) processResponse(res *http.Response) (err error) {
+	if ge.g == nil || ge.g.StatusCode != nil {
+		return
+	}
+	if !ge.setStatus(res.Trailer) {
+		ge.setStatus(res.Header)
+	}
+	return
+}
+
+func (ge *grpcEnricher) setStatus(h http.Header) bool {
+	v := h.Get("Grpc-Status")
+	if v == "" {
+		return false
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		ge.errorf("invalid grpc-status %q", v)
+		return false
+	}
+
+	ge.g.StatusCode = &code
+	if code >= 0 && code < len(grpcStatusNames) {
+		ge.g.Status = grpcStatusNames[code]
+	}
+	msg := h.Get("Grpc-Message")
+	if m, err := url.PathUnescape(msg); err == nil {
+		msg = m
+	}
+	ge.g.Message = msg
+	return true
+}
+
+func (ge *grpcEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage) (err error) {
+	if ge.g == nil {
+		return
+	}
+	for _, gf := range []*grpcFrames{ge.req, ge.res} {
+		if gf != nil && !gf.failed && gf.pending() {
+			ge.errorf("body of the %s ends within a message", gf.side)
+		}
+	}
+
+	doc.GRPC = ge.g
+	return
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func testGRPCDescriptorSet(t *testing.T) (file string, fd protoreflect.FileDescriptor) {
+	str, i32 := descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_TYPE_INT32
+	opt := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("greeter.proto"),
+		Package: proto.String("test.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("HelloRequest"), Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("name"), JsonName: proto.String("name"), Number: proto.Int32(1), Type: &str, Label: &opt},
+			}},
+			{Name: proto.String("HelloReply"), Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("message"), JsonName: proto.String("message"), Number: proto.Int32(1), Type: &str, Label: &opt},
+				{Name: proto.String("count"), JsonName: proto.String("count"), Number: proto.Int32(2), Type: &i32, Label: &opt},
+			}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{Name: proto.String("Greeter"), Method: []*descriptorpb.MethodDescriptorProto{
+				{Name: proto.String("SayHello"), InputType: proto.String(".test.v1.HelloRequest"), OutputType: proto.String(".test.v1.HelloReply"), ServerStreaming: proto.Bool(true)},
+			}},
+		},
+	}
+	fd, err := protodesc.NewFile(fdp, nil)
+	require.NoError(t, err)
+
+	b, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{fdp}})
+	require.NoError(t, err)
+	file = filepath.Join(t.TempDir(), "greeter.pb")
+	require.NoError(t, os.WriteFile(file, b, 0o644))
+	return
+}
+
+func testGRPCMessage(t *testing.T, fd protoreflect.FileDescriptor, name string, fields map[string]interface{}) []byte {
+	m := dynamicpb.NewMessage(fd.Messages().ByName(protoreflect.Name(name)))
+	for k, v := range fields {
+		m.Set(m.Descriptor().Fields().ByName(protoreflect.Name(k)), protoreflect.ValueOf(v))
+	}
+	b, err := proto.Marshal(m)
+	require.NoError(t, err)
+	return b
+}
+
+func testGRPCFrame(flag byte, payload []byte) []byte {
+	b := []byte{flag, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], uint32(len(payload)))
+	return append(b, payload...)
+}
+
+func testGRPCGzip(t *testing.T, b []byte) []byte {
+	buf := bytes.Buffer{}
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(b)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func testGRPCEnrich(t *testing.T, ercr *enricher, req *http.Request, res *http.Response) *ecsx.GRPC {
+	erc, err := ercr.EnrichRecord(testRecord(t, req, res, &httpRecordedMessageContext{ID: "grpc"}))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+	return docs[0].GRPC
+}
+
+func TestGRPCEnricher(t *testing.T) {
+	file, fd := testGRPCDescriptorSet(t)
+	ercr, err := newEnricher(enricherWithGRPCDescriptorSet(file))
+	require.NoError(t, err)
+
+	hello := testGRPCMessage(t, fd, "HelloRequest", map[string]interface{}{"name": "world"})
+	req := httptest.NewRequest(http.MethodPost, "http://api.example.com/test.v1.Greeter/SayHello", bytes.NewReader(testGRPCFrame(0, hello)))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Grpc-Encoding", "gzip")
+	req.Header.Set("Te", "trailers")
+
+	body := bytes.Join([][]byte{
+		testGRPCFrame(0, testGRPCMessage(t, fd, "HelloReply", map[string]interface{}{"message": "hello world", "count": int32(1)})),
+		testGRPCFrame(grpcFlagCompressed, testGRPCGzip(t, testGRPCMessage(t, fd, "HelloReply", map[string]interface{}{"message": "hello again", "count": int32(2)}))),
+	}, nil)
+	res := &http.Response{
+		Status: "200 OK", StatusCode: 200, Proto: "HTTP/1.1",
+		Header:           http.Header{"Content-Type": {"application/grpc+proto"}, "Grpc-Encoding": {"gzip"}},
+		Body:             io.NopCloser(bytes.NewReader(body)),
+		ContentLength:    -1,
+		TransferEncoding: []string{"chunked"},
+		Trailer:          http.Header{"Grpc-Status": {"5"}, "Grpc-Message": {"no%20more%20greetings"}},
+	}
+
+	g := testGRPCEnrich(t, ercr, req, res)
+	require.NotNil(t, g, "should recognize the call")
+	assert.Empty(t, g.Errors)
+	assert.False(t, g.Web)
+	assert.Equal(t, "test.v1.Greeter", g.Service)
+	assert.Equal(t, "SayHello", g.Method)
+	assert.Equal(t, "gzip", g.Encoding)
+	require.NotNil(t, g.StatusCode)
+	assert.Equal(t, 5, *g.StatusCode)
+	assert.Equal(t, "NOT_FOUND", g.Status)
+	assert.Equal(t, "no more greetings", g.Message)
+
+	assert.Equal(t, 1, g.Request.Messages)
+	assert.Equal(t, int64(len(hello)), g.Request.Bytes)
+	require.Len(t, g.Request.Decoded, 1)
+	assert.JSONEq(t, `{"name":"world"}`, string(g.Request.Decoded[0]))
+	assert.Equal(t, 2, g.Response.Messages)
+	assert.Equal(t, 1, g.Response.Compressed)
+	require.Len(t, g.Response.Decoded, 2)
+	assert.JSONEq(t, `{"message":"hello world","count":1}`, string(g.Response.Decoded[0]))
+	assert.JSONEq(t, `{"message":"hello again","count":2}`, string(g.Response.Decoded[1]), "should decompress messages")
+}
+
+func TestGRPCEnricherWebText(t *testing.T) {
+	_, fd := testGRPCDescriptorSet(t)
+	ercr, err := newEnricher()
+	require.NoError(t, err)
+
+	hello := testGRPCMessage(t, fd, "HelloRequest", map[string]interface{}{"name": "world"})
+	req := httptest.NewRequest(http.MethodPost, "http://api.example.com/test.v1.Greeter/SayHello",
+		bytes.NewReader([]byte(base64.StdEncoding.EncodeToString(testGRPCFrame(0, hello)))))
+	req.Header.Set("Content-Type", "application/grpc-web-text")
+
+	// grpc-web-text responses may be made of separately encoded, hence padded, segments
+	reply := testGRPCFrame(0, testGRPCMessage(t, fd, "HelloReply", map[string]interface{}{"message": "hello"}))
+	trailer := testGRPCFrame(grpcFlagTrailer, []byte("grpc-status: 3\r\ngrpc-message: name%20too%20short\r\n"))
+	body := base64.StdEncoding.EncodeToString(reply) + base64.StdEncoding.EncodeToString(trailer)
+	res := &http.Response{
+		Status: "200 OK", StatusCode: 200, Proto: "HTTP/1.1",
+		Header:        http.Header{"Content-Type": {"application/grpc-web-text+proto"}},
+		Body:          io.NopCloser(bytes.NewReader([]byte(body))),
+		ContentLength: int64(len(body)),
+	}
+
+	g := testGRPCEnrich(t, ercr, req, res)
+	require.NotNil(t, g, "should recognize the call")
+	assert.Empty(t, g.Errors)
+	assert.True(t, g.Web)
+	assert.Equal(t, 1, g.Request.Messages)
+	assert.Equal(t, 1, g.Response.Messages, "should not count the trailer frame")
+	assert.Empty(t, g.Response.Decoded, "should not decode without descriptors")
+	require.NotNil(t, g.StatusCode)
+	assert.Equal(t, "INVALID_ARGUMENT", g.Status)
+	assert.Equal(t, "name too short", g.Message)
+}
+
+func TestGRPCEnricherErrors(t *testing.T) {
+	file, _ := testGRPCDescriptorSet(t)
+	ercr, err := newEnricher(enricherWithGRPCDescriptorSet(file))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "http://api.example.com/test.v1.Greeter/SayGoodbye", bytes.NewReader(testGRPCFrame(0, []byte{0x0a})[:4]))
+	req.Header.Set("Content-Type", "application/grpc")
+	res := &http.Response{
+		Status: "200 OK", StatusCode: 200, Proto: "HTTP/1.1",
+		Header:        http.Header{"Content-Type": {"application/grpc"}, "Grpc-Status": {"12"}},
+		Body:          http.NoBody,
+		ContentLength: 0,
+	}
+
+	g := testGRPCEnrich(t, ercr, req, res)
+	require.NotNil(t, g)
+	assert.Equal(t, []string{
+		"no descriptor of method test.v1.Greeter/SayGoodbye",
+		"body of the request ends within a message",
+	}, g.Errors)
+	assert.Equal(t, "UNIMPLEMENTED", g.Status, "should read the status of trailers-only responses")
+
+	_, err = newEnricher(enricherWithGRPCDescriptorSet(filepath.Join(t.TempDir(), "missing.pb")))
+	assert.Error(t, err)
+}
+
+func TestGRPCContentType(t *testing.T) {
+	for mt, want := range map[string]*grpcContentType{
+		"application/grpc":           {proto: true},
+		"application/grpc+json":      {},
+		"application/grpc-web+proto": {web: true, proto: true},
+		"application/grpc-web-text":  {web: true, text: true, proto: true},
+		"application/grpcx":          nil,
+		"application/json":           nil,
+	} {
+		ct, ok := parseGRPCContentType(http.Header{"Content-Type": {mt}})
+		if want == nil {
+			assert.False(t, ok, mt)
+			continue
+		}
+		assert.True(t, ok, mt)
+		assert.Equal(t, *want, ct, mt)
+	}
+}
+
+func TestGRPCEnricherRedaction(t *testing.T) {
+	file, fd := testGRPCDescriptorSet(t)
+	ercr, err := newEnricher(enricherWithGRPCDescriptorSet(file), enricherWithRedaction("", nil, []string{"name"}, []string{`\bhello\b`}))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "http://api.example.com/test.v1.Greeter/SayHello",
+		bytes.NewReader(testGRPCFrame(0, testGRPCMessage(t, fd, "HelloRequest", map[string]interface{}{"name": "alice"}))))
+	req.Header.Set("Content-Type", "application/grpc")
+	res := &http.Response{
+		Status: "200 OK", StatusCode: 200, Proto: "HTTP/1.1",
+		Header:  http.Header{"Content-Type": {"application/grpc"}},
+		Body:    io.NopCloser(bytes.NewReader(testGRPCFrame(0, testGRPCMessage(t, fd, "HelloReply", map[string]interface{}{"message": "hello alice", "count": int32(1)})))),
+		Trailer: http.Header{"Grpc-Status": {"0"}},
+	}
+
+	g := testGRPCEnrich(t, ercr, req, res)
+	require.NotNil(t, g)
+	require.Len(t, g.Request.Decoded, 1)
+	assert.JSONEq(t, `{"name":"[REDACTED]"}`, string(g.Request.Decoded[0]), "should redact sensitive fields")
+	require.Len(t, g.Response.Decoded, 1)
+	assert.JSONEq(t, `{"message":"[REDACTED] alice","count":1}`, string(g.Response.Decoded[0]), "should redact patterns")
+}
@@ -13,19 +13,23 @@ type writableMimeReader struct {
 	r    *io.PipeReader
 	w    *io.PipeWriter
 	mime string
+	done chan struct{}
 }
 
 func newWritableMimeReader() *writableMimeReader {
 	r, w := io.Pipe()
 	me := &writableMimeReader{
-		r: r,
-		w: w,
+		r:    r,
+		w:    w,
+		done: make(chan struct{}),
 	}
 	go me.detectMime()
 	return me
 }
 
 func (me *writableMimeReader) detectMime() {
+	defer close(me.done)
+
 	mtype, err := mimetype.DetectReader(me.r)
 	if err != nil {
 		return
@@ -39,6 +43,12 @@ func (me *writableMimeReader) Close() error {
 	return me.w.Close()
 }
 
+// Mime returns the detected mime type, waiting for the detection to finish. The writer must be closed beforehand.
+func (me *writableMimeReader) Mime() string {
+	<-me.done
+	return me.mime
+}
+
 var _ subEnricher = &mimeEnricher{}
 
 type mimeEnricher struct {
@@ -56,12 +66,12 @@ func (erc *mimeEnricher) Close() (err error) {
 	return
 }
 
-func (erc *mimeEnricher) requestBodyWriter() io.WriteCloser {
+func (erc *mimeEnricher) requestBodyWriter(req *http.Request) io.WriteCloser {
 	return erc.req.w
 }
 func (erc *mimeEnricher) processRequest(req *http.Request) (err error) { return nil }
 
-func (erc *mimeEnricher) responseBodyWriter() io.WriteCloser {
+func (erc *mimeEnricher) responseBodyWriter(res *http.Response) io.WriteCloser {
 	return erc.res.w
 }
 func (erc *mimeEnricher) processResponse(res *http.Response) (err error) { return nil }
@@ -77,7 +87,7 @@ func (erc *mimeEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage) (e
 		doc.HTTP.Response = &ecsx.HTTPResponse{}
 	}
 
-	doc.HTTP.Request.MimeType = erc.req.mime
-	doc.HTTP.Response.MimeType = erc.res.mime
+	doc.HTTP.Request.MimeType = erc.req.Mime()
+	doc.HTTP.Response.MimeType = erc.res.Mime()
 	return
 }
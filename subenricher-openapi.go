@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+// openAPIBodyLimit is the maximum body size to be validated. Larger body is only validated by its headers.
+const openAPIBodyLimit = 1024 * 1024
+
+func openAPIViolations(in, location string, err error) (vs []ecsx.OpenAPIViolation) {
+	switch e := err.(type) {
+	case openapi3.MultiError:
+		for _, err := range e {
+			vs = append(vs, openAPIViolations(in, location, err)...)
+		}
+		return
+
+	case *openapi3filter.RequestError:
+		switch {
+		case e.Parameter != nil:
+			location = e.Parameter.In + "." + e.Parameter.Name
+		case e.RequestBody != nil:
+			location = "body"
+		}
+		if isOpenAPISchemaError(e.Err) {
+			return openAPIViolations(in, location, e.Err)
+		}
+		return []ecsx.OpenAPIViolation{{In: in, Location: location, Message: openAPIReason(e.Reason, e.Err, location)}}
+
+	case *openapi3filter.ResponseError:
+		switch {
+		case strings.HasPrefix(e.Reason, "status"):
+			location = "status"
+		case strings.Contains(e.Reason, "header"):
+			location = "header"
+		case strings.Contains(e.Reason, "body"):
+			location = "body"
+		}
+		if isOpenAPISchemaError(e.Err) {
+			return openAPIViolations(in, location, e.Err)
+		}
+		return []ecsx.OpenAPIViolation{{In: in, Location: location, Message: openAPIReason(e.Reason, e.Err, location)}}
+
+	case *openapi3.SchemaError:
+		if p := e.JSONPointer(); len(p) > 0 {
+			location += "/" + strings.Join(p, "/")
+		}
+		return []ecsx.OpenAPIViolation{{In: in, Location: location, Message: e.Reason}}
+	}
+
+	return []ecsx.OpenAPIViolation{{In: in, Location: location, Message: err.Error()}}
+}
+
+// openAPIReason describes a validation error without the offending value, which the error text of a request or
+// response error would otherwise carry.
+func openAPIReason(reason string, err error, location string) string {
+	if reason != "" {
+		return reason
+	}
+	for pe, ok := err.(*openapi3filter.ParseError); ok; pe, ok = pe.Cause.(*openapi3filter.ParseError) {
+		if pe.Reason != "" {
+			return pe.Reason
+		}
+	}
+	switch err.(type) {
+	case nil, *openapi3filter.ParseError:
+		return "invalid " + location
+	}
+	return err.Error()
+}
+
+func isOpenAPISchemaError(err error) bool {
+	switch err.(type) {
+	case openapi3.MultiError, *openapi3.SchemaError:
+		return true
+	}
+	return false
+}
+
+var _ subEnricher = &openAPIEnricher{}
+
+type openAPIEnricher struct {
+	registry *openAPIRegistry
+
+	spec    *openAPISpec
+	reqBody *truncatedBuffer
+	resBody *truncatedBuffer
+	input   *openapi3filter.RequestValidationInput
+	result  *ecsx.OpenAPI
+}
+
+func newOpenAPIEnricher(registry *openAPIRegistry) *openAPIEnricher {
+	return &openAPIEnricher{registry: registry}
+}
+
+func (o *openAPIEnricher) requestBodyWriter(req *http.Request) io.WriteCloser {
+	if o.spec = o.registry.lookup(req.Host); o.spec == nil {
+		return nopwc
+	}
+	o.reqBody = newTruncatedBuffer(openAPIBodyLimit)
+	return o.reqBody
+}
+
+func (o *openAPIEnricher) processRequest(req *http.Request) (err error) {
+	if o.spec == nil {
+		return
+	}
+
+	o.result = &ecsx.OpenAPI{}
+	if info := o.spec.doc.Info; info != nil {
+		o.result.Spec, o.result.SpecVersion = info.Title, info.Version
+	}
+
+	r := req.Clone(context.Background())
+	r.Body = io.NopCloser(bytes.NewReader(o.reqBody.buff.Bytes()))
+	route, params, err := o.spec.router.FindRoute(r)
+	if err != nil {
+		o.result.Violations = append(o.result.Violations, ecsx.OpenAPIViolation{In: "request", Message: err.Error()})
+		return nil
+	}
+
+	o.result.Matched = true
+	o.result.OperationID = route.Operation.OperationID
+	o.result.Deprecated = route.Operation.Deprecated
+	o.result.Route = route.Path
+	if route.Server != nil {
+		o.result.Route = strings.TrimSuffix(route.Server.URL, "/") + route.Path
+	}
+
+	o.input = &openapi3filter.RequestValidationInput{
+		Request:    r,
+		PathParams: params,
+		Route:      route,
+		Options: &openapi3filter.Options{
+			MultiError:          true,
+			ExcludeRequestBody:  o.reqBody.Len() > openAPIBodyLimit,
+			SkipSettingDefaults: true,
+			AuthenticationFunc:  openapi3filter.NoopAuthenticationFunc,
+		},
+	}
+	if err := openapi3filter.ValidateRequest(context.Background(), o.input); err != nil {
+		o.result.Violations = append(o.result.Violations, openAPIViolations("request", "", err)...)
+	}
+	return nil
+}
+
+func (o *openAPIEnricher) responseBodyWriter(res *http.Response) io.WriteCloser {
+	if o.input == nil {
+		return nopwc
+	}
+	o.resBody = newTruncatedBuffer(openAPIBodyLimit)
+	return o.resBody
+}
+
+func (o *openAPIEnricher) processResponse(res *http.Response) (err error) {
+	if o.input == nil {
+		return
+	}
+
+	input := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: o.input,
+		Status:                 res.StatusCode,
+		Header:                 res.Header,
+		Body:                   io.NopCloser(bytes.NewReader(o.resBody.buff.Bytes())),
+		Options: &openapi3filter.Options{
+			MultiError:            true,
+			ExcludeResponseBody:   o.resBody.Len() > openAPIBodyLimit,
+			IncludeResponseStatus: true,
+		},
+	}
+	if err := openapi3filter.ValidateResponse(context.Background(), input); err != nil {
+		o.result.Violations = append(o.result.Violations, openAPIViolations("response", "", err)...)
+	}
+	return nil
+}
+
+func (o *openAPIEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage) (err error) {
+	if o.result == nil {
+		return
+	}
+
+	doc.OpenAPI = o.result
+	if doc.HTTP == nil {
+		doc.HTTP = &ecsx.HTTP{}
+	}
+	doc.HTTP.Route = o.result.Route
+	return
+}
+
+func (o *openAPIEnricher) Close() (err error) { return }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telkomindonesia/httpmsg-enricher/ecs"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+func TestOpenAPIEnricher(t *testing.T) {
+	ercr, err := newEnricher(enricherWithOpenAPI("testdata/openapi"))
+	require.NoError(t, err, "should load specs")
+	require.NotNil(t, ercr.openAPI.lookup("mockbin.org:443"), "should lookup host without port")
+
+	f, err := os.ReadFile("testdata/record.txt")
+	require.NoError(t, err, "unexpected error in reading test data")
+	erc, err := ercr.EnrichRecord(bytes.NewReader(f))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+	require.Len(t, docs, 1, "should produce a document for the only exchange")
+	doc := docs[0]
+	require.NotNil(t, doc.OpenAPI, "should contain openapi enrichment")
+	assert.True(t, doc.OpenAPI.Matched, "should match an operation")
+	assert.Equal(t, "echo", doc.OpenAPI.OperationID)
+	assert.Equal(t, "/", doc.HTTP.Route)
+	require.Len(t, doc.OpenAPI.Violations, 1, "should only violate maxLength")
+	assert.Equal(t, "request", doc.OpenAPI.Violations[0].In)
+	assert.Equal(t, "body/test", doc.OpenAPI.Violations[0].Location)
+}
+
+func TestOpenAPIEnricherRedaction(t *testing.T) {
+	ercr, err := newEnricher(
+		enricherWithOpenAPI("testdata/openapi"),
+		enricherWithRedaction("mask", nil, []string{"otp"}, nil),
+	)
+	require.NoError(t, err, "should load specs")
+
+	req := httptest.NewRequest(http.MethodGet, "https://mockbin.org/bins/3c1f8a52-2b3e-4d6f-9a1b-7c8d9e0f1a2b?otp=s3cr3t-passcode", nil)
+	erc, err := ercr.EnrichRecord(testRecord(t, req, testResponse(req, http.StatusOK, http.Header{}, nil), &httpRecordedMessageContext{ID: "openapi"}))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+	require.Len(t, docs, 1, "should produce a document for the only exchange")
+	doc := docs[0]
+	require.NotNil(t, doc.OpenAPI, "should contain openapi enrichment")
+	require.Len(t, doc.OpenAPI.Violations, 1, "should only violate the otp type")
+	assert.Equal(t, "query.otp", doc.OpenAPI.Violations[0].Location)
+	assert.NotContains(t, doc.OpenAPI.Violations[0].Message, "s3cr3t-passcode", "should not leak the redacted value")
+	assert.NotContains(t, doc.URL.Full, "s3cr3t-passcode", "should redact the query")
+}
+
+func TestOpenAPIViolationsRedacted(t *testing.T) {
+	rdc, err := newRedactor("mask", nil, []string{"otp"}, nil)
+	require.NoError(t, err, "should not return error")
+
+	doc := &ecsx.Document{
+		Document: ecs.Document{URL: &ecs.URL{Query: "otp=s3cr3t-passcode"}},
+		OpenAPI: &ecsx.OpenAPI{Violations: []ecsx.OpenAPIViolation{
+			{In: "request", Location: "query.otp", Message: `parameter "otp" in query has an error: value s3cr3t-passcode: an invalid integer`},
+		}},
+	}
+	rdc.redact(doc)
+	assert.NotContains(t, doc.OpenAPI.Violations[0].Message, "s3cr3t-passcode", "should redact values redacted elsewhere")
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+//go:embed secrets/rules.toml
+var builtinSecretRules string
+
+// secretScannerWindow is the number of trailing bytes kept between writes so that secrets spanning two writes are still detected.
+const secretScannerWindow = 4096
+
+type secretRule struct {
+	ID          string   `toml:"id"`
+	Description string   `toml:"description"`
+	Regex       string   `toml:"regex"`
+	SecretGroup int      `toml:"secretGroup"`
+	Entropy     float64  `toml:"entropy"`
+	Keywords    []string `toml:"keywords"`
+
+	re *regexp.Regexp
+}
+
+func loadSecretRules(r io.Reader) (rules []*secretRule, err error) {
+	cfg := struct {
+		Rules []*secretRule `toml:"rules"`
+	}{}
+	if err = toml.NewDecoder(r).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("error decoding secret rules: %w", err)
+	}
+
+	for _, rule := range cfg.Rules {
+		if rule.re, err = regexp.Compile(rule.Regex); err != nil {
+			return nil, fmt.Errorf("error compiling regex of secret rule %s: %w", rule.ID, err)
+		}
+		if rule.SecretGroup > rule.re.NumSubexp() {
+			return nil, fmt.Errorf("secret rule %s refers to non existent group %d", rule.ID, rule.SecretGroup)
+		}
+		for i, k := range rule.Keywords {
+			rule.Keywords[i] = strings.ToLower(k)
+		}
+	}
+	return cfg.Rules, nil
+}
+
+func shannonEntropy(s string) (e float64) {
+	if s == "" {
+		return
+	}
+	freq := map[rune]float64{}
+	for _, r := range s {
+		freq[r]++
+	}
+	l := float64(len([]rune(s)))
+	for _, f := range freq {
+		p := f / l
+		e -= p * math.Log2(p)
+	}
+	return
+}
+
+// redactSecret keeps a short prefix of long secrets only, which is usually enough to tell the kind of key, the fingerprint being used for correlation.
+func redactSecret(s string) string {
+	if r := []rune(s); len(r) >= 20 {
+		return string(r[:4]) + "****"
+	}
+	return "****"
+}
+
+// secretFingerprint returns a keyed hash of the secret found by the rule, so that the fingerprint of a low entropy secret can not be brute-forced
+// without the key.
+func secretFingerprint(key []byte, ruleID, secret string) string {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(ruleID + ":" + secret))
+	return "hmac-sha256:" + hex.EncodeToString(m.Sum(nil)[:8])
+}
+
+// matches returns the findings of the rule against data, along with the start and end offset of each match relative to data.
+func (rule *secretRule) matches(data []byte, key []byte, location string) (findings []ecsx.Secret, bounds [][2]int) {
+	for _, idx := range rule.re.FindAllSubmatchIndex(data, -1) {
+		match := strings.ToLower(string(data[idx[0]:idx[1]]))
+		kw := len(rule.Keywords) == 0
+		for _, k := range rule.Keywords {
+			kw = kw || strings.Contains(match, k)
+		}
+		if !kw {
+			continue
+		}
+
+		start, end := idx[2*rule.SecretGroup], idx[2*rule.SecretGroup+1]
+		if start < 0 {
+			continue
+		}
+		secret := string(data[start:end])
+		entropy := shannonEntropy(secret)
+		if entropy < rule.Entropy {
+			continue
+		}
+
+		findings = append(findings, ecsx.Secret{
+			RuleID:      rule.ID,
+			Description: rule.Description,
+			Location:    location,
+			Redacted:    redactSecret(secret),
+			Fingerprint: secretFingerprint(key, rule.ID, secret),
+			Entropy:     math.Round(entropy*1000) / 1000,
+		})
+		bounds = append(bounds, [2]int{idx[0], idx[1]})
+	}
+	return
+}
+
+func scanSecrets(rules []*secretRule, key []byte, s string, location string) (findings []ecsx.Secret) {
+	for _, rule := range rules {
+		f, _ := rule.matches([]byte(s), key, location)
+		findings = append(findings, f...)
+	}
+	return
+}
+
+type secretScanner struct {
+	*windowScanner
+	rules    []*secretRule
+	key      []byte
+	location string
+
+	lastEnd  map[string]int64
+	findings []ecsx.Secret
+}
+
+func newSecretScanner(rules []*secretRule, key []byte, location string) *secretScanner {
+	sc := &secretScanner{
+		rules:    rules,
+		key:      key,
+		location: location,
+		lastEnd:  map[string]int64{},
+	}
+	sc.windowScanner = newWindowScanner(secretScannerWindow, sc.scan)
+	return sc
+}
+
+func (sc *secretScanner) scan(buf []byte, base int64, limit int) {
+	for _, rule := range sc.rules {
+		findings, bounds := rule.matches(buf, sc.key, sc.location)
+		for i, f := range findings {
+			if bounds[i][0] >= limit {
+				break
+			}
+
+			offset := base + int64(bounds[i][0])
+			if offset < sc.lastEnd[rule.ID] {
+				continue
+			}
+			sc.lastEnd[rule.ID] = base + int64(bounds[i][1])
+			f.Offset = &offset
+			sc.findings = append(sc.findings, f)
+		}
+	}
+}
+
+// walkBodyFields calls visit with the path and value of each string field of the parsed body, in a stable order.
+// Paths are relative to the parsed body, e.g. json.user.token, form.password or parts.api_key.
+func walkBodyFields(b *ecsx.Body, visit func(path, value string)) {
+	var walkJSON func(path string, v interface{})
+	walkJSON = func(path string, v interface{}) {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(t))
+			for k := range t {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walkJSON(path+"."+k, t[k])
+			}
+		case []interface{}:
+			for i, e := range t {
+				walkJSON(path+"."+strconv.Itoa(i), e)
+			}
+		case string:
+			visit(path, t)
+		}
+	}
+	if b.JSON != nil {
+		walkJSON("json", b.JSON)
+	}
+
+	keys := make([]string, 0, len(b.Form))
+	for k := range b.Form {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range b.Form[k] {
+			visit("form."+k, v)
+		}
+	}
+	for _, p := range b.Parts {
+		visit("parts."+p.Name, p.Value)
+	}
+}
+
+var _ subEnricher = &secretsEnricher{}
+
+type secretsEnricher struct {
+	rules    []*secretRule
+	key      []byte
+	body     *bodyEnricher
+	req      *secretScanner
+	res      *secretScanner
+	findings []ecsx.Secret
+}
+
+func newSecretsEnricher(rules []*secretRule, key []byte, body *bodyEnricher) *secretsEnricher {
+	return &secretsEnricher{
+		rules: rules,
+		key:   key,
+		body:  body,
+		req:   newSecretScanner(rules, key, "http.request.body"),
+		res:   newSecretScanner(rules, key, "http.response.body"),
+	}
+}
+
+func (s *secretsEnricher) scanHeaders(h http.Header, prefix string) {
+	for k, vs := range h {
+		for _, v := range vs {
+			s.findings = append(s.findings, scanSecrets(s.rules, s.key, v, prefix+strings.ToLower(k))...)
+		}
+	}
+}
+
+// locateBodyFindings sets the location of the body findings to the path of the field holding the secret when the body was parsed,
+// e.g. http.request._body.json.user.token, keeping the offset within the raw body.
+func (s *secretsEnricher) locateBodyFindings(findings []ecsx.Secret, parsed *ecsx.Body, prefix string) []ecsx.Secret {
+	if parsed == nil || len(findings) == 0 {
+		return findings
+	}
+
+	paths := map[string]string{}
+	walkBodyFields(parsed, func(path, value string) {
+		for _, f := range scanSecrets(s.rules, s.key, value, prefix+"."+path) {
+			if _, ok := paths[f.Fingerprint]; !ok {
+				paths[f.Fingerprint] = f.Location
+			}
+		}
+	})
+
+	located := make([]ecsx.Secret, 0, len(findings))
+	for _, f := range findings {
+		if path, ok := paths[f.Fingerprint]; ok {
+			f.Location = path
+		}
+		located = append(located, f)
+	}
+	return located
+}
+
+func (s *secretsEnricher) requestBodyWriter(req *http.Request) io.WriteCloser { return s.req }
+
+func (s *secretsEnricher) processRequest(req *http.Request) (err error) {
+	s.scanHeaders(req.Header, "http.request.headers.")
+	for k, vs := range req.URL.Query() {
+		for _, v := range vs {
+			s.findings = append(s.findings, scanSecrets(s.rules, s.key, k+"="+v, "url.query."+k)...)
+		}
+	}
+	s.findings = append(s.findings, scanSecrets(s.rules, s.key, req.URL.Path, "url.path")...)
+	if req.URL.User != nil {
+		s.findings = append(s.findings, scanSecrets(s.rules, s.key, req.URL.String(), "url.original")...)
+	}
+	return
+}
+
+func (s *secretsEnricher) responseBodyWriter(res *http.Response) io.WriteCloser { return s.res }
+
+func (s *secretsEnricher) processResponse(res *http.Response) (err error) {
+	s.scanHeaders(res.Header, "http.response.headers.")
+	return
+}
+
+func (s *secretsEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage) (err error) {
+	findings := append([]ecsx.Secret{}, s.findings...)
+	findings = append(findings, s.locateBodyFindings(s.req.findings, s.body.request(), "http.request._body")...)
+	findings = append(findings, s.locateBodyFindings(s.res.findings, s.body.response(), "http.response._body")...)
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Location != findings[j].Location {
+			return findings[i].Location < findings[j].Location
+		}
+		if findings[i].Offset != nil && findings[j].Offset != nil {
+			return *findings[i].Offset < *findings[j].Offset
+		}
+		return findings[i].RuleID < findings[j].RuleID
+	})
+	doc.Secrets = findings
+	return
+}
+
+func (s *secretsEnricher) Close() (err error) { return }
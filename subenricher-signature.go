@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/telkomindonesia/httpmsg-enricher/ecs"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+const (
+	signatureScannerWindow = 4096
+	signatureOffsetsLimit  = 16
+)
+
+type signatureScanner struct {
+	*windowScanner
+	rules    []*signatureRule
+	location string
+
+	lastEnd map[*signatureString]int64
+	counts  map[*signatureRule]signatureMatches
+	offsets map[*signatureString][]int64
+}
+
+func newSignatureScanner(rules []*signatureRule, location string) *signatureScanner {
+	sc := &signatureScanner{
+		rules:    rules,
+		location: location,
+		lastEnd:  map[*signatureString]int64{},
+		counts:   map[*signatureRule]signatureMatches{},
+		offsets:  map[*signatureString][]int64{},
+	}
+	for _, r := range rules {
+		sc.counts[r] = signatureMatches{}
+	}
+	sc.windowScanner = newWindowScanner(signatureScannerWindow, sc.scan)
+	return sc
+}
+
+func (sc *signatureScanner) scan(buf []byte, base int64, limit int) {
+	for _, rule := range sc.rules {
+		for _, s := range rule.strings {
+			for _, m := range s.m.findAll(buf) {
+				offset := base + int64(m[0])
+				if m[0] >= limit || offset < sc.lastEnd[s] {
+					continue
+				}
+				sc.lastEnd[s] = base + int64(m[1])
+
+				sc.counts[rule][s.id]++
+				if len(sc.offsets[s]) < signatureOffsetsLimit {
+					sc.offsets[s] = append(sc.offsets[s], offset)
+				}
+			}
+		}
+	}
+}
+
+func (sc *signatureScanner) matches() (sigs []ecsx.Signature) {
+	for _, rule := range sc.rules {
+		if !rule.condition.eval(sc.counts[rule]) {
+			continue
+		}
+
+		sig := ecsx.Signature{
+			Rule:        rule.Name,
+			Tags:        rule.Tags,
+			Description: rule.Meta["description"],
+			Location:    sc.location,
+		}
+		for _, s := range rule.strings {
+			if c := sc.counts[rule][s.id]; c > 0 {
+				sig.Strings = append(sig.Strings, ecsx.SignatureString{ID: "$" + s.id, Count: c, Offsets: sc.offsets[s]})
+			}
+		}
+		sigs = append(sigs, sig)
+	}
+	return
+}
+
+var _ subEnricher = &signatureEnricher{}
+
+type signatureEnricher struct {
+	req *signatureScanner
+	res *signatureScanner
+}
+
+func newSignatureEnricher(rules []*signatureRule) *signatureEnricher {
+	return &signatureEnricher{
+		req: newSignatureScanner(rules, "http.request.body"),
+		res: newSignatureScanner(rules, "http.response.body"),
+	}
+}
+
+func (s *signatureEnricher) requestBodyWriter(req *http.Request) io.WriteCloser   { return s.req }
+func (s *signatureEnricher) processRequest(req *http.Request) (err error)         { return }
+func (s *signatureEnricher) responseBodyWriter(res *http.Response) io.WriteCloser { return s.res }
+func (s *signatureEnricher) processResponse(res *http.Response) (err error)       { return }
+func (s *signatureEnricher) Close() (err error)                                   { return }
+
+func (s *signatureEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage) (err error) {
+	sigs := append(s.req.matches(), s.res.matches()...)
+	if len(sigs) == 0 {
+		return
+	}
+
+	if doc.Threat == nil {
+		doc.Threat = &ecs.Threat{}
+	}
+	for _, sig := range sigs {
+		desc := sig.Description
+		if desc == "" {
+			desc = sig.Rule
+		}
+		doc.Threat.Enrichments = append(doc.Threat.Enrichments, ecs.ThreatEnrichments{
+			Indicator: ecs.ThreatIndicator{
+				Description: desc,
+				Provider:    "signature",
+				Type:        "file",
+			},
+			Match: &ecs.ThreatEnrichmentMatch{
+				Type:   "indicator_match_rule",
+				Field:  sig.Location,
+				Atomic: sig.Rule,
+			},
+		})
+	}
+	doc.Signatures = sigs
+	return
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+const (
+	sseMaxLine  = 4 * 1024
+	sseMaxTypes = 32
+	sseMaxID    = 256
+)
+
+var sseBOM = []byte("\xef\xbb\xbf")
+
+func isEventStream(res *http.Response) bool {
+	mt, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	return mt == "text/event-stream"
+}
+
+var _ subEnricher = &sseEnricher{}
+
+// sseEnricher parses a text/event-stream response as it is written, so that the whole stream is described regardless of its size.
+// Only the beginning of each line is kept, which is enough for field names, event types and ids, data being only measured.
+type sseEnricher struct {
+	sse *ecsx.SSE
+
+	line    []byte
+	lineLen int64
+	cr      bool // the previous line ended with CR, hence a following LF ends nothing
+	started bool
+
+	eventType string
+	eventData int64 // length of the data buffer, including the LF appended to each data line
+	pending   bool  // any field of the event being read
+}
+
+func newSSEEnricher() *sseEnricher {
+	return &sseEnricher{}
+}
+
+func (se *sseEnricher) requestBodyWriter(req *http.Request) io.WriteCloser { return nopwc }
+func (se *sseEnricher) processRequest(req *http.Request) (err error)       { return }
+func (se *sseEnricher) processResponse(res *http.Response) (err error)     { return }
+func (se *sseEnricher) Close() (err error)                                 { return }
+
+func (se *sseEnricher) responseBodyWriter(res *http.Response) io.WriteCloser {
+	if !isEventStream(res) {
+		return nopwc
+	}
+	se.sse = &ecsx.SSE{}
+	return se
+}
+
+func (se *sseEnricher) Write(p []byte) (n int, err error) {
+	n = len(p)
+	for len(p) > 0 {
+		if se.cr && p[0] == '\n' {
+			p = p[1:]
+		}
+		se.cr = false
+
+		i := bytes.IndexAny(p, "\r\n")
+		if i < 0 {
+			se.appendLine(p)
+			return
+		}
+		se.appendLine(p[:i])
+		se.cr = p[i] == '\r'
+		p = p[i+1:]
+		se.endLine()
+	}
+	return
+}
+
+func (se *sseEnricher) appendLine(b []byte) {
+	se.lineLen += int64(len(b))
+	if k := sseMaxLine - len(se.line); k > 0 {
+		if k > len(b) {
+			k = len(b)
+		}
+		se.line = append(se.line, b[:k]...)
+	}
+}
+
+func (se *sseEnricher) endLine() {
+	line, lineLen := se.line, se.lineLen
+	defer func() { se.line, se.lineLen = se.line[:0], 0 }()
+
+	if !se.started {
+		se.started = true
+		if bytes.HasPrefix(line, sseBOM) {
+			line, lineLen = line[len(sseBOM):], lineLen-int64(len(sseBOM))
+		}
+	}
+
+	switch {
+	case lineLen == 0:
+		se.dispatch()
+		return
+	case line[0] == ':':
+		se.sse.Comments++
+		return
+	}
+
+	field, value := line, []byte{}
+	valueLen := int64(0)
+	if i := bytes.IndexByte(line, ':'); i >= 0 {
+		field, value = line[:i], line[i+1:]
+		valueLen = lineLen - int64(i) - 1
+		if len(value) > 0 && value[0] == ' ' {
+			value, valueLen = value[1:], valueLen-1
+		}
+	}
+
+	se.pending = true
+	switch string(field) {
+	case "event":
+		se.eventType = string(value)
+	case "data":
+		se.eventData += valueLen + 1
+	case "id":
+		if bytes.IndexByte(value, 0) >= 0 {
+			return
+		}
+		id := string(value)
+		if len(id) > sseMaxID {
+			id = id[:sseMaxID]
+		}
+		if se.sse.IDs == 0 {
+			se.sse.FirstID = id
+		}
+		se.sse.IDs++
+		se.sse.LastID = id
+	case "retry":
+		if ms, err := strconv.ParseInt(string(value), 10, 64); err == nil && ms >= 0 && int64(len(value)) == valueLen {
+			se.sse.Retry = &ms
+		}
+	}
+}
+
+// dispatch ends the event being read. As done by browsers, events without data are not dispatched.
+func (se *sseEnricher) dispatch() {
+	defer func() { se.eventType, se.eventData, se.pending = "", 0, false }()
+	if se.eventData == 0 {
+		return
+	}
+
+	s := se.sse
+	s.Events++
+	size := se.eventData - 1
+	s.DataBytes += size
+	if size > s.MaxDataBytes {
+		s.MaxDataBytes = size
+	}
+
+	typ := se.eventType
+	if typ == "" {
+		typ = "message"
+	}
+	for i := range s.Types {
+		if s.Types[i].Type == typ {
+			s.Types[i].Count++
+			return
+		}
+	}
+	if len(s.Types) < sseMaxTypes {
+		s.Types = append(s.Types, ecsx.SSEEventType{Type: typ, Count: 1})
+	}
+}
+
+func (se *sseEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage) (err error) {
+	if se.sse == nil {
+		return
+	}
+	if se.lineLen > 0 {
+		se.endLine()
+	}
+	se.sse.Incomplete = se.pending
+
+	// the stream lasts as long as the response, which is only known when the recorder provides the durations of the exchange
+	if l := doc.Latency; l != nil {
+		d := l.Total
+		if l.Upstream != nil {
+			d = *l.Upstream
+		}
+		se.sse.Duration = &d
+		if d > 0 {
+			rate := float64(se.sse.Events) / (float64(d) / float64(time.Second))
+			se.sse.EventsPerSecond = &rate
+		}
+	}
+
+	doc.SSE = se.sse
+	return
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSEEnricher(t *testing.T) {
+	big := strings.Repeat("x", 20*1024)
+	stream := "\xef\xbb\xbf: welcome\n" +
+		"retry: 3000\n\n" +
+		"id: 1\r\ndata: hello\r\n\r\n" +
+		"event: update\rid: 2\rdata:{\"a\":1}\rdata: {\"b\":2}\r\r" +
+		"event: update\nid: 3\ndata: " + big + "\n\n" +
+		"event: ignored\n\n" +
+		"id: 4\ndata"
+
+	req := httptest.NewRequest(http.MethodGet, "http://api.example.com/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	res := testResponse(req, http.StatusOK, http.Header{"Content-Type": {"text/event-stream; charset=utf-8"}}, []byte(stream))
+	start := time.Date(2022, 8, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Second)
+	ctx := &httpRecordedMessageContext{ID: "sse", Durations: &httpRecordedMessageContextDurations{
+		Total: httpRecordedMessageContextDuration{Start: start, End: &end},
+	}}
+
+	ercr, err := newEnricher()
+	require.NoError(t, err)
+	erc, err := ercr.EnrichRecord(testRecord(t, req, res, ctx))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+
+	sse := docs[0].SSE
+	require.NotNil(t, sse, "should parse the event stream")
+	assert.Equal(t, 3, sse.Events, "should not dispatch events without data")
+	assert.Len(t, sse.Types, 2)
+	assert.Equal(t, "message", sse.Types[0].Type)
+	assert.Equal(t, 1, sse.Types[0].Count)
+	assert.Equal(t, "update", sse.Types[1].Type)
+	assert.Equal(t, 2, sse.Types[1].Count)
+	assert.Equal(t, 4, sse.IDs)
+	assert.Equal(t, "1", sse.FirstID)
+	assert.Equal(t, "4", sse.LastID)
+	assert.Equal(t, 1, sse.Comments)
+	assert.Equal(t, int64(5+15+len(big)), sse.DataBytes)
+	assert.Equal(t, int64(len(big)), sse.MaxDataBytes, "should measure data beyond the kept line")
+	require.NotNil(t, sse.Retry)
+	assert.Equal(t, int64(3000), *sse.Retry)
+	assert.True(t, sse.Incomplete, "should tell the stream ends within an event")
+	require.NotNil(t, sse.Duration)
+	assert.Equal(t, 2*time.Second, *sse.Duration)
+	require.NotNil(t, sse.EventsPerSecond)
+	assert.Equal(t, 1.5, *sse.EventsPerSecond)
+}
+
+func TestSSEEnricherWithoutDurations(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://api.example.com/events", nil)
+	res := testResponse(req, http.StatusOK, http.Header{"Content-Type": {"text/event-stream"}}, []byte("data: a\n\ndata: b\n\n"))
+
+	ercr, err := newEnricher()
+	require.NoError(t, err)
+	erc, err := ercr.EnrichRecord(testRecord(t, req, res, &httpRecordedMessageContext{ID: "sse"}))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+
+	sse := docs[0].SSE
+	require.NotNil(t, sse)
+	assert.Equal(t, 2, sse.Events)
+	assert.False(t, sse.Incomplete)
+	assert.Nil(t, sse.Duration)
+	assert.Nil(t, sse.EventsPerSecond)
+}
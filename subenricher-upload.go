@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/telkomindonesia/httpmsg-enricher/ecs"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+var (
+	executableMimeTypes = []string{
+		"application/vnd.microsoft.portable-executable",
+		"application/x-elf",
+		"application/x-mach-binary",
+		"application/x-msi",
+		"application/jar",
+		"application/x-java-applet",
+	}
+	scriptMimeTypes = []string{
+		"text/x-php",
+		"application/javascript",
+		"application/x-python",
+		"text/x-perl",
+		"text/x-lua",
+		"text/x-tcl",
+		"text/html",
+	}
+	// scriptMarkers catch scripts embedded in otherwise valid files, e.g. PHP code appended to a GIF header.
+	// They are long enough not to occur by chance within compressed image data, hence only ASP/JSP directives and expressions are looked for.
+	scriptMarkers = [][]byte{[]byte("<?php"), []byte("<?="), []byte("<%@"), []byte("<%="), []byte("<script")}
+)
+
+// genericMimeTypes are too broad to be used as an evidence of mismatch.
+var genericMimeTypes = []string{"application/octet-stream", "text/plain"}
+
+func isMimeTypeOf(m *mimetype.MIME, types ...string) bool {
+	for ; m != nil; m = m.Parent() {
+		if mimetype.EqualsAny(m.String(), types...) {
+			return true
+		}
+	}
+	return false
+}
+
+type uploadedFile struct {
+	file ecsx.File
+
+	head   *truncatedBuffer
+	md5    hash.Hash
+	sha1   hash.Hash
+	sha256 hash.Hash
+	w      io.Writer
+}
+
+func newUploadedFile(part *multipart.Part) *uploadedFile {
+	f := &uploadedFile{
+		head:   newTruncatedBuffer(bodyPartSniffLen),
+		md5:    md5.New(),
+		sha1:   sha1.New(),
+		sha256: sha256.New(),
+	}
+	f.w = io.MultiWriter(f.head, f.md5, f.sha1, f.sha256)
+
+	f.file.Name = part.FileName()
+	f.file.Extension = strings.TrimPrefix(strings.ToLower(filepath.Ext(f.file.Name)), ".")
+	f.file.Type = "file"
+	f.file.Field = part.FormName()
+	f.file.DeclaredMimeType, _, _ = mime.ParseMediaType(part.Header.Get("Content-Type"))
+	if f.file.Extension != "" {
+		f.file.ExtensionMimeType, _, _ = mime.ParseMediaType(mime.TypeByExtension("." + f.file.Extension))
+	}
+	return f
+}
+
+func (f *uploadedFile) Write(p []byte) (n int, err error) {
+	return f.w.Write(p)
+}
+
+func (f *uploadedFile) Close() error {
+	head := f.head.buff.Bytes()
+	sniffed := mimetype.Detect(head)
+
+	f.file.Size = int64(f.head.Len())
+	f.file.MimeType = sniffed.String()
+	f.file.Hash = &ecs.Hash{
+		MD5:    hex.EncodeToString(f.md5.Sum(nil)),
+		SHA1:   hex.EncodeToString(f.sha1.Sum(nil)),
+		SHA256: hex.EncodeToString(f.sha256.Sum(nil)),
+	}
+
+	generic := mimetype.EqualsAny(sniffed.String(), genericMimeTypes...)
+	if d := f.file.DeclaredMimeType; d != "" && !generic && !mimetype.EqualsAny(d, genericMimeTypes...) {
+		f.file.MimeMismatch = !sniffed.Is(d)
+	}
+	if e := f.file.ExtensionMimeType; e != "" && !generic {
+		f.file.ExtensionMismatch = !sniffed.Is(e) && strings.TrimPrefix(sniffed.Extension(), ".") != f.file.Extension
+	}
+
+	claimsImage := strings.HasPrefix(f.file.DeclaredMimeType, "image/") || strings.HasPrefix(f.file.ExtensionMimeType, "image/")
+	if claimsImage && !strings.HasPrefix(f.file.MimeType, "image/svg") {
+		f.file.Disguised = isMimeTypeOf(sniffed, executableMimeTypes...) || isMimeTypeOf(sniffed, scriptMimeTypes...)
+		for _, m := range scriptMarkers {
+			f.file.Disguised = f.file.Disguised || bytes.Contains(bytes.ToLower(head), m)
+		}
+	}
+	return nil
+}
+
+var _ subEnricher = &uploadEnricher{}
+
+// uploadEnricher inspects files uploaded through multipart request, reusing the parts produced by bodyEnricher.
+type uploadEnricher struct {
+	files []*uploadedFile
+}
+
+func newUploadEnricher(body *bodyEnricher) *uploadEnricher {
+	u := &uploadEnricher{}
+	body.onRequestPart(u.onPart)
+	return u
+}
+
+func (u *uploadEnricher) onPart(part *multipart.Part) io.WriteCloser {
+	if part.FileName() == "" {
+		return nil
+	}
+	f := newUploadedFile(part)
+	u.files = append(u.files, f)
+	return f
+}
+
+func (u *uploadEnricher) requestBodyWriter(req *http.Request) io.WriteCloser   { return nopwc }
+func (u *uploadEnricher) processRequest(req *http.Request) (err error)         { return }
+func (u *uploadEnricher) responseBodyWriter(res *http.Response) io.WriteCloser { return nopwc }
+func (u *uploadEnricher) processResponse(res *http.Response) (err error)       { return }
+func (u *uploadEnricher) Close() (err error)                                   { return }
+
+func (u *uploadEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage) (err error) {
+	if len(u.files) == 0 {
+		return
+	}
+
+	for _, f := range u.files {
+		doc.Files = append(doc.Files, f.file)
+		if doc.File == nil || f.file.Disguised {
+			file := f.file.File
+			doc.File = &file
+		}
+		if !f.file.Disguised {
+			continue
+		}
+
+		if doc.Threat == nil {
+			doc.Threat = &ecs.Threat{}
+		}
+		doc.Threat.Enrichments = append(doc.Threat.Enrichments, ecs.ThreatEnrichments{
+			Indicator: ecs.ThreatIndicator{
+				Confidence:  "Medium",
+				Description: "executable or script uploaded as image: " + f.file.Name,
+				Provider:    "upload",
+				Type:        "file",
+			},
+			Match: &ecs.ThreatEnrichmentMatch{
+				Type:   "indicator_match_rule",
+				Field:  "file.hash.sha256",
+				Atomic: f.file.Hash.SHA256,
+			},
+		})
+	}
+	return
+}
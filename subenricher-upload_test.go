@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+// testPNG returns a valid PNG image carrying the text in a tEXt chunk following its header.
+func testPNG(t *testing.T, text string) []byte {
+	var b bytes.Buffer
+	require.NoError(t, png.Encode(&b, image.NewGray(image.Rect(0, 0, 4, 4))))
+	img := b.Bytes()
+
+	data := append([]byte("Comment\x00"), text...)
+	chunk := make([]byte, 4, 4+4+len(data)+4)
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	chunk = append(append(chunk, "tEXt"...), data...)
+	chunk = append(chunk, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(chunk[len(chunk)-4:], crc32.ChecksumIEEE(chunk[4:len(chunk)-4]))
+
+	ihdrEnd := 8 + 4 + 4 + 13 + 4
+	return append(append(append([]byte{}, img[:ihdrEnd]...), chunk...), img[ihdrEnd:]...)
+}
+
+func TestUploadEnricher(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	elf := []byte("\x7fELF\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02\x00")
+	gifphp := []byte("GIF89a<?php system($_GET['c']); ?>")
+	photo := testPNG(t, "50<%60 ratio")
+	pngjsp := testPNG(t, `<%@ page import="java.io.*" %><%= Runtime.getRuntime().exec(request.getParameter("c")) %>`)
+
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	for _, f := range []struct {
+		name, ctype string
+		content     []byte
+	}{
+		{"ok.png", "image/png", png},
+		{"cat.jpg", "image/jpeg", elf},
+		{"shell.gif", "image/gif", gifphp},
+		{"photo.png", "image/png", photo},
+		{"shell.png", "image/png", pngjsp},
+	} {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", `form-data; name="file"; filename="`+f.name+`"`)
+		h.Set("Content-Type", f.ctype)
+		w, _ := mw.CreatePart(h)
+		w.Write(f.content)
+	}
+	mw.WriteField("comment", "hello")
+	mw.Close()
+
+	body := newBodyEnricher(defaultBodyParseLimit)
+	u := newUploadEnricher(body)
+	bp := newBodyParser(mw.FormDataContentType(), defaultBodyParseLimit, body.requestHooks...)
+	_, err := io.Copy(bp, &b)
+	require.NoError(t, err, "should not return error")
+	require.NoError(t, bp.Close(), "should not return error")
+
+	doc := &ecsx.Document{}
+	require.NoError(t, u.enrich(doc, nil), "should not return error")
+	require.Len(t, doc.Files, 5, "should only contain file parts")
+
+	assert.Equal(t, "image/png", doc.Files[0].MimeType)
+	assert.Equal(t, "png", doc.Files[0].Extension)
+	assert.Equal(t, int64(len(png)), doc.Files[0].Size)
+	assert.Len(t, doc.Files[0].Hash.SHA256, 64)
+	assert.False(t, doc.Files[0].MimeMismatch)
+	assert.False(t, doc.Files[0].Disguised)
+
+	assert.True(t, doc.Files[1].MimeMismatch, "should detect declared mime mismatch")
+	assert.True(t, doc.Files[1].ExtensionMismatch, "should detect extension mismatch")
+	assert.True(t, doc.Files[1].Disguised, "should flag executable disguised as image")
+
+	assert.False(t, doc.Files[2].MimeMismatch, "gif header is valid")
+	assert.True(t, doc.Files[2].Disguised, "should flag script embedded in image")
+
+	assert.Equal(t, "image/png", doc.Files[3].MimeType)
+	assert.False(t, doc.Files[3].Disguised, "should not flag an image merely containing <%")
+	assert.True(t, doc.Files[4].Disguised, "should flag JSP embedded in image")
+
+	require.NotNil(t, doc.File)
+	require.NotNil(t, doc.Threat)
+	assert.Len(t, doc.Threat.Enrichments, 3)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/telkomindonesia/httpmsg-enricher/ecs"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+	"golang.org/x/net/publicsuffix"
+)
+
+var defaultPorts = map[string]uint16{"http": 80, "https": 443, "ws": 80, "wss": 443}
+
+// domainParts splits a domain into its registered domain, subdomain and top level domain (public suffix) using the embedded public suffix list.
+func domainParts(domain string) (registered, subdomain, tld string) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" || net.ParseIP(domain) != nil {
+		return
+	}
+
+	tld, _ = publicsuffix.PublicSuffix(domain)
+	registered, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return "", "", tld
+	}
+	subdomain = strings.TrimSuffix(strings.TrimSuffix(domain, registered), ".")
+	return
+}
+
+func hostPort(host string) (hostname string, port uint16) {
+	u := url.URL{Host: host}
+	hostname = u.Hostname()
+	if p, err := strconv.ParseUint(u.Port(), 10, 16); err == nil {
+		port = uint16(p)
+	}
+	return
+}
+
+// decomposeURL returns the ECS representation of an absolute URL.
+func decomposeURL(u *url.URL) *ecs.URL {
+	e := &ecs.URL{
+		Full:     u.String(),
+		Original: u.String(),
+		Scheme:   u.Scheme,
+		Path:     u.Path,
+		Query:    u.RawQuery,
+		Fragment: u.Fragment,
+	}
+	e.Domain, e.Port = hostPort(u.Host)
+	if e.Port == 0 {
+		e.Port = defaultPorts[u.Scheme]
+	}
+	e.RegisteredDomain, e.Subdomain, e.TopLevelDomain = domainParts(e.Domain)
+	if ext := path.Ext(path.Base(u.Path)); len(ext) > 1 {
+		e.Extension = ext[1:]
+	}
+	if u.User != nil {
+		e.Username = u.User.Username()
+		e.Password, _ = u.User.Password()
+	}
+	return e
+}
+
+var _ subEnricher = &urlEnricher{}
+
+// urlEnricher reconstructs the absolute URL of origin-form requests from the Host header and the recorded context,
+// and decomposes it and the referrer into their ECS fields.
+type urlEnricher struct {
+	req *http.Request
+}
+
+func (ue *urlEnricher) requestBodyWriter(req *http.Request) io.WriteCloser   { return nopwc }
+func (ue *urlEnricher) responseBodyWriter(res *http.Response) io.WriteCloser { return nopwc }
+func (ue *urlEnricher) processResponse(res *http.Response) (err error)       { return }
+func (ue *urlEnricher) Close() (err error)                                   { return }
+
+func (ue *urlEnricher) processRequest(req *http.Request) (err error) {
+	ue.req = req
+	return
+}
+
+func (ue *urlEnricher) absoluteURL(ctx *httpRecordedMessageContext) *url.URL {
+	u := *ue.req.URL
+	if u.Host == "" {
+		u.Host = ue.req.Host
+	}
+	if u.Host == "" && ctx != nil && ctx.Host != nil {
+		u.Host = ctx.Host.Name
+	}
+
+	switch {
+	case u.Scheme != "":
+	case ctx != nil && ctx.Connection != nil && (ctx.Connection.Protocol == "http" || ctx.Connection.Protocol == "https"):
+		u.Scheme = ctx.Connection.Protocol
+	case u.Port() == "443":
+		u.Scheme = "https"
+	default:
+		u.Scheme = "http"
+	}
+	return &u
+}
+
+func (ue *urlEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage) (err error) {
+	if ue.req == nil {
+		return
+	}
+	ctx, _ := msg.Context()
+
+	u := ue.absoluteURL(ctx)
+	e := decomposeURL(u)
+	if doc.URL != nil {
+		// keep the query as it was formatted by toECS
+		e.Query = doc.URL.Query
+	}
+	if ue.req.RequestURI != "" {
+		e.Original = ue.req.RequestURI
+	}
+	doc.URL = e
+
+	if ref, err := url.Parse(ue.req.Referer()); err == nil && ref.Host != "" && doc.HTTP != nil && doc.HTTP.Request != nil {
+		doc.HTTP.Request.ReferrerURL = decomposeURL(ref)
+	}
+	return
+}
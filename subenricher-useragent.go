@@ -14,11 +14,11 @@ var _ subEnricher = &uaEnricher{}
 
 type uaEnricher struct{}
 
-func (uaEnricher) requestBodyWriter() io.WriteCloser              { return nopwc }
-func (uaEnricher) processRequest(req *http.Request) (err error)   { return }
-func (uaEnricher) responseBodyWriter() io.WriteCloser             { return nopwc }
-func (uaEnricher) processResponse(res *http.Response) (err error) { return }
-func (uaEnricher) Close() (err error)                             { return }
+func (uaEnricher) requestBodyWriter(req *http.Request) io.WriteCloser   { return nopwc }
+func (uaEnricher) processRequest(req *http.Request) (err error)         { return }
+func (uaEnricher) responseBodyWriter(res *http.Response) io.WriteCloser { return nopwc }
+func (uaEnricher) processResponse(res *http.Response) (err error)       { return }
+func (uaEnricher) Close() (err error)                                   { return }
 
 func (uaEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage) (err error) {
 	req, err := msg.Request()
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/corazawaf/coraza/v2"
+	"github.com/telkomindonesia/httpmsg-enricher/ecs"
+	ecsx "github.com/telkomindonesia/httpmsg-enricher/ecs/custom"
+)
+
+const (
+	websocketOpContinuation = 0x0
+	websocketOpText         = 0x1
+	websocketOpBinary       = 0x2
+	websocketOpClose        = 0x8
+	websocketOpPing         = 0x9
+	websocketOpPong         = 0xa
+
+	websocketWindowSize = 32 * 1024
+	websocketArgument   = "websocket_message"
+)
+
+// websocketDeflateTail ends every message compressed by permessage-deflate, which strips it.
+var websocketDeflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+func headerHasToken(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isWebSocketUpgrade tells whether the exchange switches the connection to WebSocket,
+// in which case the recorded response body holds the frames sent by both peers.
+func isWebSocketUpgrade(res *http.Response) bool {
+	return res.StatusCode == http.StatusSwitchingProtocols && headerHasToken(res.Header, "Upgrade", "websocket")
+}
+
+type websocketFrame struct {
+	fin, compressed, masked bool
+	opcode                  byte
+	length, read            uint64
+	mask                    [4]byte
+}
+
+func websocketHeaderSize(h []byte) (n int) {
+	n = 2
+	switch h[1] & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if h[1]&0x80 != 0 {
+		n += 4
+	}
+	return
+}
+
+func parseWebSocketFrame(h []byte) *websocketFrame {
+	f := &websocketFrame{fin: h[0]&0x80 != 0, compressed: h[0]&0x40 != 0, opcode: h[0] & 0x0f, masked: h[1]&0x80 != 0}
+	i := 2
+	switch l := h[1] & 0x7f; l {
+	case 126:
+		f.length, i = uint64(binary.BigEndian.Uint16(h[2:])), 4
+	case 127:
+		f.length, i = binary.BigEndian.Uint64(h[2:]), 10
+	default:
+		f.length = uint64(l)
+	}
+	if f.masked {
+		copy(f.mask[:], h[i:])
+	}
+	return f
+}
+
+// websocketPeer reassembles the messages sent by one peer of the connection out of their fragments.
+type websocketPeer struct {
+	name  string
+	stats *ecsx.WebSocketPeer
+
+	opcode     byte // of the message being reassembled, zero when there is none
+	compressed bool
+	size       int64
+	payload    []byte
+	truncated  bool
+
+	window       []byte // last decompressed bytes, which following messages may refer to
+	inflateError bool
+}
+
+func (p *websocketPeer) append(b []byte, limit int) {
+	p.size += int64(len(b))
+	if p.truncated {
+		return
+	}
+	if len(p.payload)+len(b) > limit {
+		p.truncated = true
+		return
+	}
+	p.payload = append(p.payload, b...)
+}
+
+// inflate decompresses the message, using the previous messages as dictionary since the context is taken over by default.
+// Once a message can not be decompressed, e.g. because it exceeds the limit, the following ones can not be either.
+func (p *websocketPeer) inflate(limit int) ([]byte, error) {
+	if p.inflateError {
+		return nil, nil
+	}
+	if p.truncated {
+		p.inflateError = true
+		return nil, fmt.Errorf("compressed message from %s exceeds %d bytes, following messages can not be decompressed", p.name, limit)
+	}
+
+	r := flate.NewReaderDict(io.MultiReader(bytes.NewReader(p.payload), bytes.NewReader(websocketDeflateTail)), p.window)
+	b, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err == io.ErrUnexpectedEOF { // the stream is flushed, not ended
+		err = nil
+	}
+	if err == nil && len(b) > limit {
+		err = fmt.Errorf("decompressed message exceeds %d bytes", limit)
+	}
+	if err != nil {
+		p.inflateError = true
+		return nil, fmt.Errorf("error decompressing message from %s: %w", p.name, err)
+	}
+
+	p.window = append(p.window, b...)
+	if len(p.window) > websocketWindowSize {
+		p.window = append([]byte(nil), p.window[len(p.window)-websocketWindowSize:]...)
+	}
+	return b, nil
+}
+
+func (p *websocketPeer) reset() {
+	p.opcode, p.compressed, p.size, p.payload, p.truncated = 0, false, 0, p.payload[:0], false
+}
+
+var _ subEnricher = &websocketEnricher{}
+
+// websocketEnricher parses the frames recorded after a WebSocket upgrade, as they are written, into message counts and sizes per peer,
+// telling both peers apart by the masking of their frames. When enabled, text messages sent by the client are run through CRS.
+type websocketEnricher struct {
+	limit      int
+	waf        *coraza.Waf
+	maxInspect int
+
+	req     *http.Request
+	ws      *ecsx.WebSocket
+	deflate bool
+	client  *websocketPeer
+	server  *websocketPeer
+
+	head    []byte
+	frame   *websocketFrame
+	control []byte
+	failed  bool
+	threats []ecs.ThreatEnrichments
+}
+
+func newWebSocketEnricher(limit int, waf *coraza.Waf, maxInspect int) *websocketEnricher {
+	if maxInspect <= 0 {
+		waf = nil
+	}
+	return &websocketEnricher{limit: limit, waf: waf, maxInspect: maxInspect}
+}
+
+func (we *websocketEnricher) requestBodyWriter(req *http.Request) io.WriteCloser { return nopwc }
+func (we *websocketEnricher) processResponse(res *http.Response) (err error)     { return }
+func (we *websocketEnricher) Close() (err error)                                 { return }
+
+func (we *websocketEnricher) processRequest(req *http.Request) (err error) {
+	we.req = req
+	return
+}
+
+func (we *websocketEnricher) responseBodyWriter(res *http.Response) io.WriteCloser {
+	if we.req == nil || !isWebSocketUpgrade(res) {
+		return nopwc
+	}
+
+	we.ws = &ecsx.WebSocket{Subprotocol: res.Header.Get("Sec-WebSocket-Protocol")}
+	for _, v := range res.Header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(v, ",") {
+			name := strings.TrimSpace(strings.SplitN(ext, ";", 2)[0])
+			if name == "" {
+				continue
+			}
+			we.ws.Extensions = append(we.ws.Extensions, name)
+			we.deflate = we.deflate || name == "permessage-deflate"
+		}
+	}
+	we.client = &websocketPeer{name: "client", stats: &we.ws.Client}
+	we.server = &websocketPeer{name: "server", stats: &we.ws.Server}
+	return we
+}
+
+func (we *websocketEnricher) errorf(format string, args ...interface{}) {
+	we.ws.Errors = append(we.ws.Errors, fmt.Sprintf(format, args...))
+}
+
+func (we *websocketEnricher) peer(f *websocketFrame) *websocketPeer {
+	if f.masked {
+		return we.client
+	}
+	return we.server
+}
+
+// Write parses the frames as they come, keeping at most the configured limit of each message.
+func (we *websocketEnricher) Write(p []byte) (n int, err error) {
+	n = len(p)
+	for len(p) > 0 && !we.failed {
+		if we.frame == nil {
+			p = we.readHeader(p)
+			continue
+		}
+
+		k := uint64(len(p))
+		if rest := we.frame.length - we.frame.read; k > rest {
+			k = rest
+		}
+		we.readPayload(p[:k])
+		p = p[k:]
+	}
+	return
+}
+
+func (we *websocketEnricher) readHeader(p []byte) []byte {
+	for need := 2; len(we.head) < need; {
+		if len(p) == 0 {
+			return p
+		}
+		k := need - len(we.head)
+		if k > len(p) {
+			k = len(p)
+		}
+		we.head, p = append(we.head, p[:k]...), p[k:]
+		if len(we.head) >= 2 {
+			need = websocketHeaderSize(we.head)
+		}
+	}
+	f := parseWebSocketFrame(we.head)
+	we.head = we.head[:0]
+
+	peer := we.peer(f)
+	peer.stats.Frames++
+	switch f.opcode {
+	case websocketOpText, websocketOpBinary:
+		if peer.opcode != 0 {
+			we.errorf("%s started a message before ending the previous one", peer.name)
+			peer.reset()
+		}
+		peer.opcode, peer.compressed = f.opcode, f.compressed && we.deflate
+	case websocketOpContinuation:
+		if peer.opcode == 0 {
+			we.errorf("unexpected continuation frame from %s", peer.name)
+		}
+	case websocketOpClose, websocketOpPing, websocketOpPong:
+		if !f.fin || f.length > 125 {
+			we.errorf("invalid control frame from %s", peer.name)
+		}
+	default:
+		we.errorf("unknown opcode %#x from %s, frames can not be parsed further", f.opcode, peer.name)
+		we.failed = true
+		return nil
+	}
+
+	we.frame = f
+	if f.length == 0 {
+		we.endFrame()
+	}
+	return p
+}
+
+func (we *websocketEnricher) readPayload(b []byte) {
+	f := we.frame
+	if f.masked {
+		u := make([]byte, len(b))
+		for i := range b {
+			u[i] = b[i] ^ f.mask[(f.read+uint64(i))%4]
+		}
+		b = u
+	}
+	f.read += uint64(len(b))
+
+	peer := we.peer(f)
+	switch {
+	case f.opcode >= websocketOpClose:
+		if len(we.control)+len(b) <= 125 {
+			we.control = append(we.control, b...)
+		}
+	case peer.opcode != 0:
+		peer.append(b, we.limit)
+	}
+	if f.read == f.length {
+		we.endFrame()
+	}
+}
+
+func (we *websocketEnricher) endFrame() {
+	f := we.frame
+	we.frame = nil
+
+	peer := we.peer(f)
+	switch f.opcode {
+	case websocketOpClose:
+		if we.ws.Close == nil {
+			we.ws.Close = &ecsx.WebSocketClose{Initiator: peer.name}
+			if len(we.control) >= 2 {
+				we.ws.Close.Code = int(binary.BigEndian.Uint16(we.control))
+				we.ws.Close.Reason = string(we.control[2:])
+			}
+		}
+	case websocketOpPing:
+		peer.stats.Pings++
+	case websocketOpPong:
+		peer.stats.Pongs++
+	default:
+		if f.fin && peer.opcode != 0 {
+			we.endMessage(peer)
+		}
+	}
+	we.control = we.control[:0]
+}
+
+func (we *websocketEnricher) endMessage(peer *websocketPeer) {
+	defer peer.reset()
+
+	s := peer.stats
+	s.Messages++
+	s.Bytes += peer.size
+	if peer.size > s.MaxMessageBytes {
+		s.MaxMessageBytes = peer.size
+	}
+	if peer.opcode == websocketOpBinary {
+		s.Binary++
+		if peer.compressed { // keep the decompression context
+			if _, err := peer.inflate(we.limit); err != nil {
+				we.errorf("%v", err)
+			}
+		}
+		return
+	}
+	s.Text++
+
+	payload := peer.payload
+	if peer.compressed {
+		var err error
+		if payload, err = peer.inflate(we.limit); err != nil {
+			we.errorf("%v", err)
+		}
+	} else if peer.truncated {
+		payload = nil
+	}
+	if payload != nil && peer == we.client && we.waf != nil && we.ws.Inspected < we.maxInspect {
+		we.inspect(payload)
+	}
+}
+
+// inspect runs a text message through CRS as the argument of a request made to the upgraded URL with the same headers.
+func (we *websocketEnricher) inspect(message []byte) {
+	we.ws.Inspected++
+
+	tx := we.waf.NewTransaction()
+	defer func() {
+		tx.ProcessLogging()
+		tx.Clean()
+	}()
+	tx.ProcessURI(we.req.URL.String(), we.req.Method, we.req.Proto)
+	for k, vr := range we.req.Header {
+		for _, v := range vr {
+			tx.AddRequestHeader(k, v)
+		}
+	}
+	if we.req.Host != "" {
+		tx.AddRequestHeader("Host", we.req.Host)
+	}
+	tx.ProcessRequestHeaders()
+	tx.AddArgument("POST", websocketArgument, string(message))
+	if _, err := tx.ProcessRequestBody(); err != nil {
+		we.errorf("error inspecting message: %v", err)
+		return
+	}
+
+	// only the rules matching the message tell about it, the others being already reported for the upgrade request
+	rules := []coraza.MatchedRule{}
+	for _, rule := range tx.MatchedRules {
+		if rule.MatchedData.Key == websocketArgument {
+			rules = append(rules, rule)
+		}
+	}
+	for _, te := range crsThreatEnrichments(rules) {
+		te.Match.Field = "_websocket.client.message"
+		we.threats = append(we.threats, te)
+	}
+}
+
+func (we *websocketEnricher) enrich(doc *ecsx.Document, msg *httpRecordedMessage) (err error) {
+	if we.ws == nil {
+		return
+	}
+	if len(we.head) > 0 || we.frame != nil {
+		we.errorf("record ends within a frame")
+	}
+
+	doc.WebSocket = we.ws
+	if len(we.threats) > 0 {
+		if doc.Threat == nil {
+			doc.Threat = &ecs.Threat{}
+		}
+		doc.Threat.Enrichments = append(doc.Threat.Enrichments, we.threats...)
+	}
+	return
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testWebSocketFrame(fin bool, opcode byte, masked, compressed bool, payload []byte) []byte {
+	b := []byte{opcode, 0}
+	if fin {
+		b[0] |= 0x80
+	}
+	if compressed {
+		b[0] |= 0x40
+	}
+	switch l := len(payload); {
+	case l < 126:
+		b[1] = byte(l)
+	case l <= 0xffff:
+		b[1] = 126
+		b = append(b, 0, 0)
+		binary.BigEndian.PutUint16(b[2:], uint16(l))
+	default:
+		b[1] = 127
+		b = append(b, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(b[2:], uint64(l))
+	}
+	if !masked {
+		return append(b, payload...)
+	}
+
+	b[1] |= 0x80
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	b = append(b, mask...)
+	for i, c := range payload {
+		b = append(b, c^mask[i%4])
+	}
+	return b
+}
+
+// testWebSocketDeflate compresses messages the way permessage-deflate does with context takeover, i.e. flushing one stream.
+type testWebSocketDeflate struct {
+	buf bytes.Buffer
+	w   *flate.Writer
+}
+
+func (d *testWebSocketDeflate) compress(t *testing.T, msg string) []byte {
+	if d.w == nil {
+		d.w, _ = flate.NewWriter(&d.buf, flate.BestCompression)
+	}
+	d.buf.Reset()
+	_, err := d.w.Write([]byte(msg))
+	require.NoError(t, err)
+	require.NoError(t, d.w.Flush())
+	return append([]byte(nil), bytes.TrimSuffix(d.buf.Bytes(), websocketDeflateTail)...)
+}
+
+func TestWebSocketEnricher(t *testing.T) {
+	client, server := &testWebSocketDeflate{}, &testWebSocketDeflate{}
+	attack := `{"q":"1' OR '1'='1' --"}`
+	frames := bytes.Join([][]byte{
+		testWebSocketFrame(false, websocketOpText, true, false, []byte("hel")),
+		testWebSocketFrame(true, websocketOpPing, true, false, nil),
+		testWebSocketFrame(true, websocketOpContinuation, true, false, []byte("lo")),
+		testWebSocketFrame(true, websocketOpPong, false, false, nil),
+		testWebSocketFrame(true, websocketOpText, false, true, server.compress(t, "hi")),
+		testWebSocketFrame(true, websocketOpText, true, true, client.compress(t, attack)),
+		testWebSocketFrame(true, websocketOpBinary, false, false, bytes.Repeat([]byte{0xff}, 300)),
+		testWebSocketFrame(true, websocketOpText, true, true, client.compress(t, attack)), // refers to the previous message
+		testWebSocketFrame(true, websocketOpClose, true, false, append([]byte{0x03, 0xe8}, "bye"...)),
+		testWebSocketFrame(true, websocketOpClose, false, false, []byte{0x03, 0xe8}),
+	}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "http://chat.example.com/ws?room=1", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Protocol", "chat, superchat")
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Accept", "*/*")
+	res := testResponse(req, http.StatusSwitchingProtocols, http.Header{
+		"Upgrade":                  {"websocket"},
+		"Connection":               {"Upgrade"},
+		"Sec-Websocket-Accept":     {"s3pPLMBiTxaQ9kYGzzhZRbK+xOo="},
+		"Sec-Websocket-Protocol":   {"chat"},
+		"Sec-Websocket-Extensions": {"permessage-deflate; client_max_window_bits"},
+	}, frames)
+
+	ercr, err := newEnricher(
+		enricherWithCRS("crs/coraza.conf", "crs/crs-setup.conf", "crs/rules/*.conf"),
+		enricherWithWebSocketInspection(10),
+	)
+	require.NoError(t, err)
+	erc, err := ercr.EnrichRecord(testRecord(t, req, res, &httpRecordedMessageContext{ID: "websocket"}))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+	doc := docs[0]
+
+	ws := doc.WebSocket
+	require.NotNil(t, ws, "should detect the upgrade")
+	assert.Empty(t, ws.Errors)
+	assert.Equal(t, "chat", ws.Subprotocol)
+	assert.Equal(t, []string{"permessage-deflate"}, ws.Extensions)
+
+	assert.Equal(t, 6, ws.Client.Frames)
+	assert.Equal(t, 3, ws.Client.Messages, "should reassemble fragments")
+	assert.Equal(t, 3, ws.Client.Text)
+	assert.Equal(t, 1, ws.Client.Pings)
+	assert.Equal(t, 2, ws.Server.Messages)
+	assert.Equal(t, 1, ws.Server.Binary)
+	assert.Equal(t, int64(300), ws.Server.MaxMessageBytes)
+	assert.Equal(t, 1, ws.Server.Pongs)
+	require.NotNil(t, ws.Close)
+	assert.Equal(t, 1000, ws.Close.Code)
+	assert.Equal(t, "bye", ws.Close.Reason)
+	assert.Equal(t, "client", ws.Close.Initiator)
+
+	assert.Equal(t, 3, ws.Inspected)
+	injections := 0
+	for _, te := range doc.Threat.Enrichments {
+		if te.Match != nil && te.Match.Field == "_websocket.client.message" && strings.Contains(te.Indicator.Description, `id "942`) {
+			injections++
+		}
+	}
+	assert.GreaterOrEqual(t, injections, 2, "should detect the injection in both compressed messages")
+}
+
+func TestWebSocketEnricherTruncated(t *testing.T) {
+	frames := testWebSocketFrame(true, websocketOpText, true, false, []byte(strings.Repeat("a", 200)))
+	req := httptest.NewRequest(http.MethodGet, "http://chat.example.com/ws", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	res := testResponse(req, http.StatusSwitchingProtocols, http.Header{"Upgrade": {"websocket"}, "Connection": {"Upgrade"}}, frames[:100])
+
+	ercr, err := newEnricher()
+	require.NoError(t, err)
+	erc, err := ercr.EnrichRecord(testRecord(t, req, res, &httpRecordedMessageContext{ID: "websocket"}))
+	require.NoError(t, err, "should not return error")
+	defer erc.Close()
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+
+	ws := docs[0].WebSocket
+	require.NotNil(t, ws)
+	assert.Equal(t, 1, ws.Client.Frames)
+	assert.Equal(t, 0, ws.Client.Messages)
+	assert.Equal(t, []string{"record ends within a frame"}, ws.Errors)
+	assert.Zero(t, ws.Inspected, "should not inspect unless enabled")
+}
@@ -8,9 +8,9 @@ import (
 )
 
 type subEnricher interface {
-	requestBodyWriter() io.WriteCloser
+	requestBodyWriter(req *http.Request) io.WriteCloser
 	processRequest(req *http.Request) (err error)
-	responseBodyWriter() io.WriteCloser
+	responseBodyWriter(res *http.Response) io.WriteCloser
 	processResponse(res *http.Response) (err error)
 	enrich(doc *ecsx.Document, msg *httpRecordedMessage) (err error)
 
@@ -1,6 +1,10 @@
 package main
 
-import "strings"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
 
 func Truncate(text string, width int) string {
 	if width < 0 {
@@ -22,3 +26,8 @@ func MapStringsKeyToLower(m map[string][]string) map[string][]string {
 	}
 	return nm
 }
+
+func ShortHash(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(h[:8])
+}
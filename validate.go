@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	headerLineRegexp = regexp.MustCompile(`^[!#$%&'*+.^_|~0-9A-Za-z-]+:`)
+	chunkSizeRegexp  = regexp.MustCompile(`^[0-9a-fA-F]+(;[^\r\n]*)?\r\n`)
+)
+
+// recordDiagnostic reports a violation of the recorded format at a line of the record, counted from 1.
+type recordDiagnostic struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (d recordDiagnostic) String() string { return fmt.Sprintf("line %d: %s", d.Line, d.Message) }
+
+// recordLineReader reads a record line by line the way httpRecordedStream splits it, i.e. on CRLF,
+// while numbering lines on LF as editors do.
+type recordLineReader struct {
+	br   *bufio.Reader
+	line int // line of the next token
+}
+
+func (lr *recordLineReader) next() (token []byte, line int, err error) {
+	line = lr.line
+	for {
+		b, err := lr.br.ReadSlice('\n')
+		token = append(token, b...)
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err != nil && len(token) == 0:
+			return nil, line, err
+		case err == nil && !bytes.HasSuffix(token, crlf):
+			continue
+		}
+		lr.line += bytes.Count(token, []byte{'\n'})
+		return token, line, nil
+	}
+}
+
+// recordValidator checks a record against the recorded format:
+// a delimiter line, then each request and response followed by the delimiter line, the trailers of chunked messages and a blank line,
+// then the context as JSON.
+type recordValidator struct {
+	lr        *recordLineReader
+	delimiter []byte
+	diags     []recordDiagnostic
+
+	exchanges int
+	req       *http.Request
+	reqLine   int
+}
+
+func (rv *recordValidator) report(line int, format string, args ...interface{}) {
+	rv.diags = append(rv.diags, recordDiagnostic{Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// validateRecord returns the diagnostics of the record, which is valid when there is none. The error reports failures to read it.
+func validateRecord(r io.Reader) (diags []recordDiagnostic, err error) {
+	rv := &recordValidator{lr: &recordLineReader{br: bufio.NewReaderSize(r, maxHeaderLine), line: 1}}
+	err = rv.validate()
+	return rv.diags, err
+}
+
+// nextNonBlank skips blank lines the way the parser does between messages.
+func (rv *recordValidator) nextNonBlank() (token []byte, line int, err error) {
+	for {
+		if token, line, err = rv.lr.next(); err != nil || !isCRLF(token) {
+			return
+		}
+	}
+}
+
+func (rv *recordValidator) validate() error {
+	first, line, err := rv.lr.next()
+	if err == io.EOF {
+		rv.report(line, "empty record")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !recordDelimiterRegexp.Match(first) {
+		rv.report(line, "record must start with a delimiter line such as %q", recordDelimiterPrefix+"<id>")
+		return nil
+	}
+	rv.delimiter = first
+
+	for request := true; ; request = !request {
+		token, line, err := rv.nextNonBlank()
+		switch {
+		case err == io.EOF && request:
+			rv.report(line, "missing context")
+			return nil
+		case err == io.EOF:
+			rv.report(line, "missing response to the request at line %d", rv.reqLine)
+			return nil
+		case err != nil:
+			return err
+		case bytes.Equal(token, rv.delimiter):
+			rv.report(line, "unexpected delimiter line, expecting a %s", messageKind(request))
+			return nil
+		}
+
+		var ok bool
+		switch {
+		case isPseudoHeaderBlock(token):
+			ok, err = rv.validatePseudoHeaderMessage(token, line, request)
+		case httpStartLineRegexp.Match(token):
+			ok, err = rv.validateMessage(token, line, request)
+		case request && rv.exchanges > 0:
+			return rv.validateContext(token, line)
+		case request && bytes.HasPrefix(bytes.TrimSpace(token), []byte("{")):
+			rv.report(line, "missing request and response before the context")
+			return nil
+		default:
+			rv.report(line, "invalid %s start line %q", messageKind(request), strings.TrimSuffix(string(token), "\r\n"))
+			return nil
+		}
+		if err != nil || !ok {
+			return err
+		}
+		if !request {
+			rv.exchanges++
+		}
+	}
+}
+
+func messageKind(request bool) string {
+	if request {
+		return "request"
+	}
+	return "response"
+}
+
+// validateMessage checks a message starting at the given start line, returning whether the following ones can be checked.
+func (rv *recordValidator) validateMessage(startLine []byte, start int, request bool) (ok bool, err error) {
+	kind := messageKind(request)
+	head := bytes.Buffer{}
+	head.Write(startLine)
+
+	// header
+	for {
+		token, line, err := rv.lr.next()
+		if err == io.EOF {
+			rv.report(line, "record ends within the header of the %s at line %d", kind, start)
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		head.Write(token)
+		if isCRLF(token) {
+			break
+		}
+		if bytes.Equal(token, rv.delimiter) {
+			rv.report(line, "delimiter line within the header of the %s at line %d, the blank line ending the header is missing", kind, start)
+			return false, nil
+		}
+		if !headerLineRegexp.Match(token) {
+			rv.report(line, "invalid header line %q", strings.TrimSuffix(string(token), "\r\n"))
+		}
+	}
+
+	var header http.Header
+	var contentLength int64 = -1
+	var chunked, bodiless bool
+	if request {
+		req, err := http.ReadRequest(bufio.NewReader(&head))
+		if err != nil {
+			rv.report(start, "invalid request: %v", err)
+			return false, nil
+		}
+		rv.req, rv.reqLine, header, contentLength, chunked = req, start, req.Header, req.ContentLength, isChunked(req.TransferEncoding, nil)
+	} else {
+		res, err := http.ReadResponse(bufio.NewReader(&head), rv.req)
+		if err != nil {
+			rv.report(start, "invalid response: %v", err)
+			return false, nil
+		}
+		header, contentLength, chunked = res.Header, res.ContentLength, isChunked(res.TransferEncoding, nil)
+		bodiless = res.StatusCode/100 == 1 || res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotModified ||
+			rv.req.Method == http.MethodHead
+	}
+	if !chunked && header.Get("Content-Length") != "" {
+		contentLength, _ = strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	}
+
+	bodyStart := rv.lr.line
+	b, ok, err := rv.readBody(kind, start, chunked)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// trailers, then the blank line ending the message
+	trailers := 0
+	for {
+		token, line, err := rv.lr.next()
+		if err == io.EOF {
+			rv.report(line, "record ends before the blank line ending the %s at line %d", kind, start)
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if isCRLF(token) {
+			break
+		}
+		trailers++
+		switch {
+		case !chunked && trailers == 1:
+			rv.validateBodyLength(b, contentLength, bodiless, kind, start, bodyStart, line)
+			rv.report(line, "unexpected line after the delimiter line of the %s at line %d, only chunked messages have trailers", kind, start)
+			return false, nil
+		case bytes.Equal(token, rv.delimiter):
+			rv.report(line, "delimiter line within the trailers of the %s at line %d", kind, start)
+			return false, nil
+		case !headerLineRegexp.Match(token):
+			rv.report(line, "invalid trailer line %q", strings.TrimSuffix(string(token), "\r\n"))
+		}
+	}
+	if chunked {
+		if len(b) == 0 {
+			rv.report(bodyStart, "empty chunked body of the %s at line %d, which the parser can not read, use Content-Length: 0 instead", kind, start)
+		} else if chunkSizeRegexp.Match(b) && bytes.HasSuffix(b, []byte("0\r\n")) {
+			rv.report(bodyStart, "chunked body of the %s at line %d seems to keep its chunk framing, while it is expected to be decoded", kind, start)
+		}
+		return true, nil
+	}
+	rv.validateBodyLength(b, contentLength, bodiless, kind, start, bodyStart, 0)
+	return true, nil
+}
+
+// readBody reads the body of a message, ended by the delimiter line following the CRLF appended to it.
+func (rv *recordValidator) readBody(kind string, start int, chunked bool) (b []byte, ok bool, err error) {
+	body := bytes.Buffer{}
+	var end int
+	for {
+		token, line, err := rv.lr.next()
+		if err == io.EOF {
+			if chunked {
+				rv.report(line, "truncated chunked body of the %s at line %d, the delimiter line is missing", kind, start)
+			} else {
+				rv.report(line, "record ends within the body of the %s at line %d, the delimiter line is missing", kind, start)
+			}
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if bytes.Equal(token, rv.delimiter) {
+			end = line
+			break
+		}
+		body.Write(token)
+	}
+	b = body.Bytes()
+	if !bytes.HasSuffix(b, crlf) {
+		rv.report(end, "missing CRLF between the body of the %s at line %d and the delimiter line", kind, start)
+	}
+	return bytes.TrimSuffix(b, crlf), true, nil
+}
+
+// validatePseudoHeaderMessage checks a message recorded as an HTTP/2 or HTTP/3 header block starting at the given line,
+// whose body is framed by the delimiter line alone and may be followed by trailers.
+func (rv *recordValidator) validatePseudoHeaderMessage(first []byte, start int, request bool) (ok bool, err error) {
+	kind := messageKind(request)
+	m := newPseudoHeaderMessage(first)
+
+	token, line := first, start
+	if pseudoHeaderVersionRegexp.Match(first) {
+		token = nil
+	}
+	for ; ; token = nil {
+		if token == nil {
+			if token, line, err = rv.lr.next(); err == io.EOF {
+				rv.report(line, "record ends within the header block of the %s at line %d", kind, start)
+				return false, nil
+			} else if err != nil {
+				return false, err
+			}
+		}
+		if isCRLF(token) {
+			break
+		}
+		if bytes.Equal(token, rv.delimiter) {
+			rv.report(line, "delimiter line within the header block of the %s at line %d, the blank line ending it is missing", kind, start)
+			return false, nil
+		}
+		if !pseudoHeaderLineRegexp.Match(token) && !headerLineRegexp.Match(token) {
+			rv.report(line, "invalid header line %q", strings.TrimSuffix(string(token), "\r\n"))
+			continue
+		}
+		if err := m.addHeaderLine(token); err != nil {
+			rv.report(line, "%v", err)
+		}
+	}
+	if m.isResponse() == request {
+		rv.report(start, "header block of the %s at line %d has the pseudo-headers of a %s", kind, start, messageKind(!request))
+		return false, nil
+	}
+
+	body, ok, err := rv.readBody(kind, start, false)
+	if err != nil || !ok {
+		return false, err
+	}
+	for {
+		token, line, err := rv.lr.next()
+		if err == io.EOF {
+			rv.report(line, "record ends before the blank line ending the %s at line %d", kind, start)
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if isCRLF(token) {
+			break
+		}
+		if bytes.Equal(token, rv.delimiter) {
+			rv.report(line, "delimiter line within the trailers of the %s at line %d", kind, start)
+			return false, nil
+		}
+		if !headerLineRegexp.Match(token) {
+			rv.report(line, "invalid trailer line %q", strings.TrimSuffix(string(token), "\r\n"))
+		}
+		m.trailer = append(m.trailer, token)
+	}
+
+	b, err := m.head(len(body) > 0 || len(m.trailer) > 0, !request && rv.req != nil && rv.req.Method == http.MethodHead)
+	if err != nil {
+		rv.report(start, "invalid %s header block: %v", kind, err)
+		return false, nil
+	}
+	if request {
+		if rv.req, err = http.ReadRequest(bufio.NewReader(bytes.NewReader(b))); err != nil {
+			rv.report(start, "invalid request: %v", err)
+			return false, nil
+		}
+		rv.reqLine = start
+	} else if _, err = http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), rv.req); err != nil {
+		rv.report(start, "invalid response: %v", err)
+		return false, nil
+	}
+	return true, nil
+}
+
+// validateBodyLength checks the body against its announced length. A body shorter than announced, followed by unexpected lines,
+// is most likely cut by a delimiter line colliding with its content.
+func (rv *recordValidator) validateBodyLength(b []byte, contentLength int64, bodiless bool, kind string, start, bodyStart, collision int) {
+	switch {
+	case bodiless && len(b) == 0:
+	case contentLength < 0 && len(b) > 0 && kind == "request":
+		rv.report(bodyStart, "body of the request at line %d has neither Content-Length nor chunked Transfer-Encoding, hence is ignored", start)
+	case contentLength < 0 && len(b) > 0:
+		rv.report(bodyStart, "body of the response at line %d has neither Content-Length nor chunked Transfer-Encoding, hence extends until the end of the record", start)
+	case contentLength >= 0 && int64(len(b)) < contentLength && collision > 0:
+		rv.report(collision-1, "body of the %s at line %d is cut by a delimiter line colliding with its content after %d of %d bytes", kind, start, len(b), contentLength)
+	case contentLength >= 0 && int64(len(b)) != contentLength:
+		rv.report(bodyStart, "body of the %s at line %d has %d bytes while its Content-Length is %d", kind, start, len(b), contentLength)
+	}
+}
+
+// validateContext checks that the rest of the record, starting with the given token, is the context JSON.
+func (rv *recordValidator) validateContext(token []byte, line int) error {
+	rest, err := io.ReadAll(rv.lr.br)
+	if err != nil {
+		return err
+	}
+	b := append(token, rest...)
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	ctx := httpRecordedMessageContext{}
+	if err := dec.Decode(&ctx); err != nil {
+		offset := dec.InputOffset()
+		var serr *json.SyntaxError
+		var terr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &serr):
+			offset = serr.Offset
+		case errors.As(err, &terr):
+			offset = terr.Offset
+		}
+		if offset > int64(len(b)) {
+			offset = int64(len(b))
+		}
+		rv.report(line+bytes.Count(b[:offset], []byte{'\n'}), "invalid context: %v", err)
+		return nil
+	}
+	if extra := bytes.TrimSpace(b[dec.InputOffset():]); len(extra) > 0 {
+		offset := int64(len(b) - len(bytes.TrimLeft(b[dec.InputOffset():], " \t\r\n")))
+		rv.report(line+bytes.Count(b[:offset], []byte{'\n'}), "unexpected content after the context")
+	}
+	if ctx.ID == "" {
+		rv.report(line, "context has no id")
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testValidateDelimiter = recordDelimiterPrefix + "x"
+
+func testValidateRecord(msgs ...string) string {
+	s := testValidateDelimiter + "\r\n\r\n"
+	for _, m := range msgs {
+		s += m
+	}
+	return s
+}
+
+func TestValidateRecord(t *testing.T) {
+	d := testValidateDelimiter
+	req := "POST /users HTTP/1.1\r\nHost: api.example.com\r\nContent-Length: 5\r\n\r\nhello\r\n" + d + "\r\n\r\n"
+	res := "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok\r\n" + d + "\r\n\r\n"
+	ctx := "{\"id\":\"x\"}\n"
+
+	for _, name := range []string{"testdata/record.txt", "testdata/record5.txt", "testdata/record6.txt"} {
+		b, err := os.ReadFile(name)
+		require.NoError(t, err)
+		diags, err := validateRecord(bytes.NewReader(b))
+		require.NoError(t, err)
+		assert.Empty(t, diags, name)
+	}
+
+	tests := map[string]struct {
+		record string
+		line   int
+		msg    string
+	}{
+		"valid": {record: testValidateRecord(req, res, ctx)},
+		"no delimiter": {
+			record: strings.TrimPrefix(testValidateRecord(req, res, ctx), d+"\r\n\r\n"),
+			line:   1, msg: "must start with a delimiter line",
+		},
+		"delimiter collision": {
+			record: testValidateRecord("POST /users HTTP/1.1\r\nHost: api.example.com\r\nContent-Length: 32\r\n\r\nhello\r\n"+d+"\r\nworld\r\n"+d+"\r\n\r\n", res, ctx),
+			line:   8, msg: "colliding with its content after 5 of 32 bytes",
+		},
+		"length mismatch": {
+			record: testValidateRecord(strings.Replace(req, "Content-Length: 5", "Content-Length: 4", 1), res, ctx),
+			line:   7, msg: "has 5 bytes while its Content-Length is 4",
+		},
+		"missing context": {
+			record: testValidateRecord(req, res),
+			line:   16, msg: "missing context",
+		},
+		"invalid context": {
+			record: testValidateRecord(req, res, "{\n\"id\": \"x\",\n}\n"),
+			line:   18, msg: "invalid context",
+		},
+		"missing response": {
+			record: testValidateRecord(req, ctx),
+			line:   10, msg: "invalid response start line",
+		},
+		"truncated chunked body": {
+			record: testValidateRecord("POST /users HTTP/1.1\r\nHost: api.example.com\r\nTransfer-Encoding: chunked\r\n\r\nhel"),
+			line:   7, msg: "truncated chunked body of the request at line 3",
+		},
+		"chunk framing kept": {
+			record: testValidateRecord("POST /users HTTP/1.1\r\nHost: api.example.com\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n"+d+"\r\n\r\n", res, ctx),
+			line:   7, msg: "seems to keep its chunk framing",
+		},
+		"trailer of non chunked message": {
+			record: testValidateRecord(req, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok\r\n"+d+"\r\nX-Checksum: abc\r\n\r\n", ctx),
+			line:   15, msg: "only chunked messages have trailers",
+		},
+		"invalid header": {
+			record: testValidateRecord(strings.Replace(req, "Host: api.example.com", "Host api.example.com", 1), res, ctx),
+			line:   4, msg: "invalid header line",
+		},
+		"header block without path": {
+			record: testValidateRecord(":method: GET\r\n:authority: api.example.com\r\n\r\n\r\n"+d+"\r\n\r\n", ":status: 200\r\n\r\n\r\n"+d+"\r\n\r\n", ctx),
+			line:   3, msg: "missing :path pseudo-header",
+		},
+		"pseudo-header after regular header": {
+			record: testValidateRecord(":method: GET\r\naccept: */*\r\n:path: /\r\n\r\n\r\n"+d+"\r\n\r\n", ":status: 200\r\n\r\n\r\n"+d+"\r\n\r\n", ctx),
+			line:   5, msg: "follows regular headers",
+		},
+		"unframed response body": {
+			record: testValidateRecord(req, "HTTP/1.1 200 OK\r\n\r\nok\r\n"+d+"\r\n\r\n", ctx),
+			line:   12, msg: "extends until the end of the record",
+		},
+	}
+	for name, tt := range tests {
+		diags, err := validateRecord(strings.NewReader(tt.record))
+		require.NoError(t, err, name)
+		if tt.msg == "" {
+			assert.Empty(t, diags, name)
+			continue
+		}
+		require.NotEmpty(t, diags, name)
+		assert.Equal(t, tt.line, diags[0].Line, name)
+		assert.Contains(t, diags[0].Message, tt.msg, name)
+	}
+}
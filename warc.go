@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+var warcMagic = []byte("WARC/")
+
+const (
+	// warcPendingLimit is the maximum number of request and response records waiting for their counterpart,
+	// beyond which the oldest one is reported as unpaired.
+	warcPendingLimit = 64
+
+	defaultWARCRecordLimit = 64 << 20
+)
+
+type warcRecord struct {
+	typ          string
+	id           string
+	concurrentTo []string
+	targetURI    string
+	ip           string
+	date         time.Time
+	block        []byte
+	err          error // reported in place of the exchange of the record, e.g. when its block is too large to be read
+}
+
+// pairs tells whether o is the counterpart of r, as referenced through WARC-Concurrent-To in either direction.
+func (r *warcRecord) pairs(o *warcRecord) bool {
+	return r.typ != o.typ && (containsString(r.concurrentTo, o.id) || containsString(o.concurrentTo, r.id))
+}
+
+// warcHTTPRecord tells whether the record holds an HTTP message.
+func warcHTTPRecord(typ string, h textproto.MIMEHeader) bool {
+	if typ != "request" && typ != "response" {
+		return false
+	}
+	ct := strings.ToLower(h.Get("Content-Type"))
+	return strings.HasPrefix(ct, "application/http")
+}
+
+func warcRecordID(id string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(id), "<"), ">")
+}
+
+// warcExchange converts a pair of request and response records into the recorded format.
+// WARC-Record-ID of the response becomes the ID of the context, its WARC-Date the start of the event, and its WARC-IP-Address the upstream target.
+func warcExchange(reqRec, resRec *warcRecord) (r io.Reader, err error) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(reqRec.block)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid request record %s: %w", reqRec.id, err)
+	}
+	reqBody, err := readPayload(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request record %s: %w", reqRec.id, err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resRec.block)), req)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response record %s: %w", resRec.id, err)
+	}
+	resBody, err := readPayload(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response record %s: %w", resRec.id, err)
+	}
+
+	rb := newRecordBuilder()
+	if err = rb.exchange(req, reqBody, res, resBody); err != nil {
+		return
+	}
+
+	ctx := &httpRecordedMessageContext{ID: resRec.id}
+	targetURI := resRec.targetURI
+	if targetURI == "" {
+		targetURI = reqRec.targetURI
+	}
+	if u, err := url.Parse(targetURI); err == nil && u.Host != "" {
+		ctx.Connection = &httpRecordedMessageContextConnection{Protocol: u.Scheme}
+		ctx.Host = &httpRecordedMessageContextHost{Name: u.Host}
+		ip := resRec.ip
+		if ip == "" {
+			ip = reqRec.ip
+		}
+		ctx.Host.Target = recordTarget(u, ip)
+	}
+	if date := resRec.date; !date.IsZero() || !reqRec.date.IsZero() {
+		if date.IsZero() {
+			date = reqRec.date
+		}
+		ctx.Durations = &httpRecordedMessageContextDurations{Total: httpRecordedMessageContextDuration{Start: date}}
+	}
+	return rb.build(ctx)
+}
+
+type warcEntry struct {
+	name string
+	r    io.Reader
+	err  error
+}
+
+// warcEntries iterates over the exchanges of a WARC file, i.e. pairs of request and response records.
+// Records are paired by WARC-Concurrent-To, falling back to WARC-Target-URI, regardless of which one comes first.
+// Other record types are skipped, while records left without counterpart are reported as failing entries.
+type warcEntries struct {
+	name  string
+	limit int64 // of the size of record blocks, zero for none
+	br    *bufio.Reader
+	tp    *textproto.Reader
+	index int
+	ended bool
+
+	pending []*warcRecord
+	ready   []warcEntry
+}
+
+func newWARCEntries(name string, r io.Reader, limit int64) *warcEntries {
+	br := bufio.NewReader(r)
+	return &warcEntries{name: name, limit: limit, br: br, tp: textproto.NewReader(br)}
+}
+
+// read returns the next record, or io.EOF when there is none.
+func (we *warcEntries) read() (rec *warcRecord, h textproto.MIMEHeader, err error) {
+	var version string
+	for version == "" {
+		if version, err = we.tp.ReadLine(); err != nil {
+			return
+		}
+	}
+	if !strings.HasPrefix(version, string(warcMagic)) {
+		return nil, nil, fmt.Errorf("invalid warc record: unexpected %q", version)
+	}
+	if h, err = we.tp.ReadMIMEHeader(); err != nil {
+		return nil, nil, fmt.Errorf("invalid warc record header: %w", err)
+	}
+	size, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	if err != nil || size < 0 {
+		return nil, nil, fmt.Errorf("invalid warc record %s: invalid Content-Length", h.Get("WARC-Record-ID"))
+	}
+
+	rec = &warcRecord{
+		typ:       h.Get("WARC-Type"),
+		id:        warcRecordID(h.Get("WARC-Record-ID")),
+		targetURI: warcRecordID(h.Get("WARC-Target-URI")),
+		ip:        h.Get("WARC-IP-Address"),
+	}
+	for _, c := range h.Values("WARC-Concurrent-To") {
+		rec.concurrentTo = append(rec.concurrentTo, warcRecordID(c))
+	}
+	if d := h.Get("WARC-Date"); d != "" {
+		rec.date, _ = time.Parse(time.RFC3339Nano, d)
+	}
+	if !warcHTTPRecord(rec.typ, h) {
+		_, err = io.CopyN(io.Discard, we.br, size)
+		return
+	}
+	if we.limit > 0 && size > we.limit {
+		rec.err = fmt.Errorf("%s record %s of %d bytes exceeds the limit of %d bytes", rec.typ, rec.id, size, we.limit)
+		if _, err = io.CopyN(io.Discard, we.br, size); err == io.EOF {
+			err = nil // the record is reported anyway, the stream ending on the next read
+		}
+		return
+	}
+	rec.block = make([]byte, size)
+	_, err = io.ReadFull(we.br, rec.block)
+	return
+}
+
+func (we *warcEntries) push(r io.Reader, err error) {
+	we.index++
+	e := warcEntry{name: fmt.Sprintf("%s#%d", we.name, we.index), r: r}
+	if err != nil {
+		e.r, e.err = nil, &recordEntryError{entry: e.name, err: err}
+	}
+	we.ready = append(we.ready, e)
+}
+
+func (we *warcEntries) pushUnpaired() {
+	rec := we.pending[0]
+	we.pending = we.pending[1:]
+	if rec.err != nil {
+		we.push(nil, rec.err)
+		return
+	}
+	we.push(nil, fmt.Errorf("%s record %s has no counterpart", rec.typ, rec.id))
+}
+
+// match removes and returns the pending counterpart of rec, if any.
+func (we *warcEntries) match(rec *warcRecord) *warcRecord {
+	found := -1
+	for i, p := range we.pending {
+		if rec.pairs(p) {
+			found = i
+			break
+		}
+		if found < 0 && p.typ != rec.typ && p.targetURI != "" && p.targetURI == rec.targetURI {
+			found = i
+		}
+	}
+	if found < 0 {
+		return nil
+	}
+
+	p := we.pending[found]
+	we.pending = append(we.pending[:found], we.pending[found+1:]...)
+	return p
+}
+
+// next returns the record of the next exchange, or io.EOF when there is none.
+func (we *warcEntries) next() (name string, r io.Reader, err error) {
+	for len(we.ready) == 0 {
+		if we.ended {
+			if len(we.pending) == 0 {
+				return "", nil, io.EOF
+			}
+			we.pushUnpaired()
+			break
+		}
+
+		rec, h, err := we.read()
+		switch {
+		case err == io.EOF:
+			we.ended = true
+			continue
+		case err != nil:
+			return "", nil, fmt.Errorf("error reading %s: %w", we.name, err)
+		case !warcHTTPRecord(rec.typ, h):
+			continue
+		}
+
+		if p := we.match(rec); p != nil {
+			req, res := p, rec
+			if req.typ == "response" {
+				req, res = res, req
+			}
+			if err := multierr.Combine(req.err, res.err); err != nil {
+				we.push(nil, err)
+				continue
+			}
+			we.push(warcExchange(req, res))
+			continue
+		}
+		we.pending = append(we.pending, rec)
+		if len(we.pending) > warcPendingLimit {
+			we.pushUnpaired()
+		}
+	}
+
+	e := we.ready[0]
+	we.ready = we.ready[1:]
+	return e.name, e.r, e.err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWARCEntries(t *testing.T) {
+	ercr, err := newEnricher()
+	require.NoError(t, err)
+
+	f, err := os.Open("testdata/sample.warc")
+	require.NoError(t, err, "unexpected error in reading test data")
+	defer f.Close()
+	we := newWARCEntries("sample.warc", f, defaultWARCRecordLimit)
+
+	name, r, err := we.next()
+	require.NoError(t, err, "should pair response with the following request through WARC-Concurrent-To")
+	assert.Equal(t, "sample.warc#1", name)
+	erc, err := ercr.EnrichRecord(r)
+	require.NoError(t, err, "should enrich the converted exchange")
+	defer erc.Close()
+	docs, err := erc.toECS()
+	require.NoError(t, err, "should not return error")
+	require.Len(t, docs, 1)
+	doc := docs[0]
+
+	assert.Equal(t, "urn:uuid:5c1a5c1e-0000-4000-8000-00000000000a", doc.Event.Id)
+	assert.Equal(t, time.Date(2023, 1, 20, 10, 0, 0, 0, time.UTC), doc.Timestamp)
+	assert.Equal(t, "https://example.com/index.html?q=1", doc.URL.Full)
+	assert.Equal(t, "<html><body>hello</body></html>", doc.HTTP.Response.Body.Content, "should decode chunked and gzipped payload")
+	require.NotNil(t, doc.Destination)
+	assert.Equal(t, "93.184.216.34", doc.Destination.Address)
+	assert.Equal(t, uint16(443), doc.Destination.Port)
+
+	name, r, err = we.next()
+	require.NoError(t, err, "should pair request with the following response through WARC-Target-URI")
+	assert.Equal(t, "sample.warc#2", name)
+	erc2, err := ercr.EnrichRecord(r)
+	require.NoError(t, err, "should enrich the converted exchange")
+	defer erc2.Close()
+	docs, err = erc2.toECS()
+	require.NoError(t, err, "should not return error")
+	doc = docs[0]
+
+	assert.Equal(t, "urn:uuid:5c1a5c1e-0000-4000-8000-00000000000b", doc.Event.Id)
+	assert.Equal(t, "POST", doc.HTTP.Request.Method)
+	assert.Equal(t, "username=alice&password=%27+OR+1%3D1+--", doc.HTTP.Request.Body.Content)
+	assert.Equal(t, 401, doc.HTTP.Response.StatusCode)
+	assert.Equal(t, uint16(80), doc.Destination.Port)
+
+	_, _, err = we.next()
+	var entryErr *recordEntryError
+	require.ErrorAs(t, err, &entryErr, "should report response without request on its own")
+	assert.Equal(t, "sample.warc#3", entryErr.entry)
+	assert.Contains(t, entryErr.Error(), "no counterpart")
+
+	_, _, err = we.next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestEnrichWARCRecords(t *testing.T) {
+	warc := readTestRecords(t, "testdata/sample.warc")[0]
+
+	// gzip each record on its own, as crawlers do
+	gzipped := bytes.Buffer{}
+	for _, rec := range bytes.Split(warc, warcMagic)[1:] {
+		gz := gzip.NewWriter(&gzipped)
+		_, err := gz.Write(bytes.Join([][]byte{warcMagic, rec}, nil))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+	}
+
+	for name, b := range map[string][]byte{"sample.warc": warc, "sample.warc.gz": gzipped.Bytes()} {
+		lines := enrichTestRecords(t, name, b)
+		assert.Equal(t, []string{"sample.warc#1", "sample.warc#2", "sample.warc#3"}, entriesOf(lines), name)
+		assert.Empty(t, lines[0].Ingest.Error, name)
+		assert.Empty(t, lines[1].Ingest.Error, name)
+		assert.NotEmpty(t, lines[2].Ingest.Error, "should report unpaired record in %s", name)
+	}
+}
+
+func TestWARCEntriesRecordLimit(t *testing.T) {
+	warc := readTestRecords(t, "testdata/sample.warc")[0]
+	we := newWARCEntries("sample.warc", bytes.NewReader(warc), 64)
+	for i := 0; ; i++ {
+		_, _, err := we.next()
+		if err == io.EOF {
+			assert.Equal(t, 3, i, "should go on with the following records")
+			break
+		}
+		var entryErr *recordEntryError
+		require.ErrorAs(t, err, &entryErr, "should report oversized record as failing entry")
+		assert.Contains(t, entryErr.Error(), "exceeds the limit")
+	}
+
+	hostile := "WARC/1.1\r\nWARC-Type: response\r\nWARC-Record-ID: <urn:uuid:x>\r\nContent-Type: application/http; msgtype=response\r\nContent-Length: 9223372036854775807\r\n\r\nHTTP/1.1 200 OK\r\n\r\n"
+	we = newWARCEntries("hostile.warc", strings.NewReader(hostile), defaultWARCRecordLimit)
+	_, _, err := we.next()
+	var entryErr *recordEntryError
+	require.ErrorAs(t, err, &entryErr, "should not allocate the declared length")
+	_, _, err = we.next()
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,35 @@
+package main
+
+// windowScanner buffers written bytes and invokes scan over them, keeping the last window bytes between invocations
+// so that matches spanning multiple writes can still be found.
+// scan should only consider matches starting before limit, since the bytes after it will be scanned again on the next invocation.
+type windowScanner struct {
+	window int
+	scan   func(buf []byte, base int64, limit int)
+
+	buf  []byte
+	base int64
+}
+
+func newWindowScanner(window int, scan func(buf []byte, base int64, limit int)) *windowScanner {
+	return &windowScanner{window: window, scan: scan}
+}
+
+func (ws *windowScanner) Write(p []byte) (n int, err error) {
+	ws.buf = append(ws.buf, p...)
+	if len(ws.buf) >= 2*ws.window {
+		ws.flush(len(ws.buf) - ws.window)
+	}
+	return len(p), nil
+}
+
+func (ws *windowScanner) flush(limit int) {
+	ws.scan(ws.buf, ws.base, limit)
+	ws.buf = append(ws.buf[:0], ws.buf[limit:]...)
+	ws.base += int64(limit)
+}
+
+func (ws *windowScanner) Close() error {
+	ws.flush(len(ws.buf))
+	return nil
+}